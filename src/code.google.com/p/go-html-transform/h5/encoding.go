@@ -0,0 +1,514 @@
+package h5
+
+// Character encodings. The parser reads utf-8; sources in other encodings
+// are decoded to it first. Which encoding a source is in is worked out the
+// way the html5 spec says browsers do, see
+// http://www.whatwg.org/specs/web-apps/current-work/multipage/parsing.html#determining-the-character-encoding
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// The encoding a source is read in when nothing says what it is in.
+const defaultEncoding = "windows-1252"
+
+// How much of a source is looked at to work out its encoding.
+const sniffSize = 1024
+
+// The names of the supported encodings by their labels.
+var encodingNames = map[string]string{
+	"unicode-1-1-utf-8": "utf-8",
+	"utf-8":             "utf-8",
+	"utf8":              "utf-8",
+	"utf-16":            "utf-16le",
+	"utf-16le":          "utf-16le",
+	"utf-16be":          "utf-16be",
+	"ansi_x3.4-1968":    "windows-1252",
+	"ascii":             "windows-1252",
+	"cp1252":            "windows-1252",
+	"cp819":             "windows-1252",
+	"csisolatin1":       "windows-1252",
+	"ibm819":            "windows-1252",
+	"iso-8859-1":        "windows-1252",
+	"iso-ir-100":        "windows-1252",
+	"iso8859-1":         "windows-1252",
+	"iso88591":          "windows-1252",
+	"iso_8859-1":        "windows-1252",
+	"iso_8859-1:1987":   "windows-1252",
+	"l1":                "windows-1252",
+	"latin1":            "windows-1252",
+	"us-ascii":          "windows-1252",
+	"windows-1252":      "windows-1252",
+	"x-cp1252":          "windows-1252",
+	"x-user-defined":    "windows-1252",
+	"csisolatin2":       "iso-8859-2",
+	"iso-8859-2":        "iso-8859-2",
+	"iso-ir-101":        "iso-8859-2",
+	"iso8859-2":         "iso-8859-2",
+	"iso88592":          "iso-8859-2",
+	"iso_8859-2":        "iso-8859-2",
+	"iso_8859-2:1987":   "iso-8859-2",
+	"l2":                "iso-8859-2",
+	"latin2":            "iso-8859-2",
+	"csisolatin9":       "iso-8859-15",
+	"iso-8859-15":       "iso-8859-15",
+	"iso8859-15":        "iso-8859-15",
+	"iso885915":         "iso-8859-15",
+	"iso_8859-15":       "iso-8859-15",
+	"l9":                "iso-8859-15",
+	"cp1250":            "windows-1250",
+	"windows-1250":      "windows-1250",
+	"x-cp1250":          "windows-1250",
+	"cp1251":            "windows-1251",
+	"windows-1251":      "windows-1251",
+	"x-cp1251":          "windows-1251",
+}
+
+// The single byte encodings by name.
+var singleByteEncodings = map[string]*[128]rune{
+	"windows-1252": &windows1252,
+	"iso-8859-2":   &iso88592,
+	"iso-8859-15":  &iso885915,
+	"windows-1250": &windows1250,
+	"windows-1251": &windows1251,
+}
+
+// EncodingName returns the name of the encoding a label like "latin1" or
+// "UTF8" stands for, or "" if the encoding isn't supported.
+func EncodingName(label string) string {
+	return encodingNames[strings.ToLower(strings.Trim(label, "\t\n\f\r "))]
+}
+
+// DetermineEncoding works out the encoding of an html5 source from as much
+// of it as has been read and the Content-Type it was served with, if any. It
+// looks for a byte order mark, then the charset of the Content-Type, then a
+// <meta> naming a charset in the first 1024 bytes. Failing those a source
+// whose bytes read so far are valid utf-8 is taken to be utf-8, anything else
+// windows-1252. It returns the name of the encoding and whether the source
+// said what it was.
+func DetermineEncoding(prefix []byte, contentType string) (name string, certain bool) {
+	if name, _ := bom(prefix); name != "" {
+		return name, true
+	}
+	if name := EncodingName(charsetParam(contentType)); name != "" {
+		return name, true
+	}
+	head := prefix
+	if len(head) > sniffSize {
+		head = head[:sniffSize]
+	}
+	if name := prescan(head); name != "" {
+		return name, true
+	}
+	if isUTF8(prefix) {
+		return "utf-8", false
+	}
+	return defaultEncoding, false
+}
+
+// bom returns the encoding a byte order mark at the start of b is for and
+// its length.
+func bom(b []byte) (string, int) {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8", 3
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		return "utf-16be", 2
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return "utf-16le", 2
+	}
+	return "", 0
+}
+
+// isUTF8 reports whether b holds something other than ascii and is valid
+// utf-8, but for a character cut off at the end.
+func isUTF8(b []byte) bool {
+	ascii := true
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			return !ascii && len(b) < utf8.UTFMax && !utf8.FullRune(b)
+		}
+		ascii = ascii && size == 1
+		b = b[size:]
+	}
+	return !ascii
+}
+
+// charsetParam returns the charset a Content-Type like
+// "text/html; charset=utf-8" names.
+func charsetParam(contentType string) string {
+	i := strings.Index(contentType, ";")
+	if i < 0 {
+		return ""
+	}
+	for _, param := range strings.Split(contentType[i+1:], ";") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "charset") {
+			return strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		}
+	}
+	return ""
+}
+
+// prescan looks through the start of a source for a <meta> naming its
+// encoding, skipping comments and the attributes of other tags.
+func prescan(b []byte) string {
+	for i := 0; i < len(b); i++ {
+		if b[i] != '<' {
+			continue
+		}
+		rest := b[i:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			end := bytes.Index(rest[2:], []byte("-->"))
+			if end < 0 {
+				return ""
+			}
+			i += end + 4
+		case len(rest) > 5 && bytes.EqualFold(rest[:5], []byte("<meta")) &&
+			(isSpaceByte(rest[5]) || rest[5] == '/'):
+			name, n := prescanMeta(rest[5:])
+			if name != "" {
+				return name
+			}
+			if n < 0 {
+				return ""
+			}
+			i += 4 + n
+		case len(rest) > 1 && isLetterByte(rest[1]),
+			len(rest) > 2 && rest[1] == '/' && isLetterByte(rest[2]):
+			// skip the tag name, then its attributes
+			j := 1
+			for j < len(rest) && !isSpaceByte(rest[j]) && rest[j] != '>' {
+				j++
+			}
+			for {
+				name, _, n := prescanAttr(rest[j:])
+				if n < 0 {
+					return ""
+				}
+				j += n
+				if name == "" {
+					break
+				}
+			}
+			i += j - 1
+		case bytes.HasPrefix(rest, []byte("<!")), bytes.HasPrefix(rest, []byte("</")),
+			bytes.HasPrefix(rest, []byte("<?")):
+			end := bytes.IndexByte(rest, '>')
+			if end < 0 {
+				return ""
+			}
+			i += end
+		}
+	}
+	return ""
+}
+
+// prescanMeta reads the attributes of a <meta> up to its '>'. It returns the
+// encoding they name, if any, and how many bytes they took, -1 if the end of
+// b came first.
+func prescanMeta(b []byte) (string, int) {
+	seen := map[string]bool{}
+	charset, pragma, needPragma := "", false, 0 // 0 unknown, 1 true, 2 false
+	i := 0
+	for {
+		name, value, n := prescanAttr(b[i:])
+		if n < 0 {
+			return "", -1
+		}
+		i += n
+		if name == "" {
+			break
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		switch name {
+		case "http-equiv":
+			pragma = pragma || strings.EqualFold(value, "content-type")
+		case "content":
+			if charset == "" {
+				if c := charsetFromContent(value); c != "" {
+					charset, needPragma = c, 1
+				}
+			}
+		case "charset":
+			if charset == "" {
+				charset, needPragma = value, 2
+			}
+		}
+	}
+	if needPragma == 0 || needPragma == 1 && !pragma {
+		return "", i
+	}
+	name := EncodingName(charset)
+	if strings.HasPrefix(name, "utf-16") {
+		// a source that can say so in ascii isn't in utf-16
+		name = "utf-8"
+	}
+	return name, i
+}
+
+// prescanAttr reads an attribute in a tag. It returns its name and value,
+// lower case, and how many bytes they took, -1 if the end of b came first.
+// The name is "" at the end of the tag.
+func prescanAttr(b []byte) (name, value string, n int) {
+	i := 0
+	for i < len(b) && (isSpaceByte(b[i]) || b[i] == '/') {
+		i++
+	}
+	if i == len(b) {
+		return "", "", -1
+	}
+	if b[i] == '>' {
+		return "", "", i + 1
+	}
+	start := i
+	for i < len(b) && (i == start || b[i] != '=') && b[i] != '/' && b[i] != '>' &&
+		!isSpaceByte(b[i]) {
+		i++
+	}
+	name = strings.ToLower(string(b[start:i]))
+	for i < len(b) && isSpaceByte(b[i]) {
+		i++
+	}
+	if i == len(b) {
+		return "", "", -1
+	}
+	if b[i] != '=' {
+		return name, "", i
+	}
+	i++
+	for i < len(b) && isSpaceByte(b[i]) {
+		i++
+	}
+	if i == len(b) {
+		return "", "", -1
+	}
+	if q := b[i]; q == '"' || q == '\'' {
+		end := bytes.IndexByte(b[i+1:], q)
+		if end < 0 {
+			return "", "", -1
+		}
+		value = string(b[i+1 : i+1+end])
+		return name, strings.ToLower(value), i + end + 2
+	}
+	start = i
+	for i < len(b) && !isSpaceByte(b[i]) && b[i] != '>' {
+		i++
+	}
+	if i == len(b) {
+		return "", "", -1
+	}
+	return name, strings.ToLower(string(b[start:i])), i
+}
+
+// charsetFromContent returns the charset the content of a
+// <meta http-equiv="content-type"> names.
+func charsetFromContent(content string) string {
+	s := strings.ToLower(content)
+	for {
+		i := strings.Index(s, "charset")
+		if i < 0 {
+			return ""
+		}
+		s = strings.TrimLeft(s[i+len("charset"):], "\t\n\f\r ")
+		if strings.HasPrefix(s, "=") {
+			break
+		}
+	}
+	s = strings.TrimLeft(s[1:], "\t\n\f\r ")
+	if s == "" {
+		return ""
+	}
+	if q := s[0]; q == '"' || q == '\'' {
+		end := strings.IndexByte(s[1:], q)
+		if end < 0 {
+			return ""
+		}
+		return s[1 : 1+end]
+	}
+	if end := strings.IndexAny(s, "\t\n\f\r ;"); end >= 0 {
+		return s[:end]
+	}
+	return s
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func isLetterByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// NewDecoder returns a reader of the utf-8 an html5 source decodes to, and
+// the name of the encoding DetermineEncoding found it is in. contentType is
+// the Content-Type the source was served with, or "".
+func NewDecoder(r io.Reader, contentType string) (io.Reader, string) {
+	// the first bytes of the source are read to sniff its encoding, and
+	// read again through the decoder
+	prefix := make([]byte, sniffSize)
+	n, err := io.ReadFull(r, prefix)
+	prefix = prefix[:n]
+	name, _ := DetermineEncoding(prefix, contentType)
+	if bomName, size := bom(prefix); bomName == name {
+		prefix = prefix[size:]
+	}
+	var src io.Reader = bytes.NewReader(prefix)
+	if err == nil {
+		src = io.MultiReader(src, r)
+	} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+		src = io.MultiReader(src, &errReader{err})
+	}
+	return newDecodingReader(src, name), name
+}
+
+// Decode converts a whole html5 source to utf-8. It returns the utf-8 and the
+// name of the encoding DetermineEncoding found the source is in.
+// contentType is the Content-Type the source was served with, or "".
+func Decode(b []byte, contentType string) ([]byte, string) {
+	name, _ := DetermineEncoding(b, contentType)
+	if bomName, size := bom(b); bomName == name {
+		b = b[size:]
+	}
+	decode := decoderFor(name)
+	if decode == nil {
+		return b, name
+	}
+	out, n := decode(make([]byte, 0, len(b)), b, true)
+	return append(out, b[n:]...), name
+}
+
+// A decodeFunc appends the utf-8 src decodes to to dst. It returns that and
+// how much of src it decoded, which is less than all of it if src ends part
+// way through a character and more may follow. At the end of the source
+// (atEOF) it decodes all of it.
+type decodeFunc func(dst, src []byte, atEOF bool) ([]byte, int)
+
+// decoderFor returns the decoder for an encoding, or nil for utf-8.
+func decoderFor(name string) decodeFunc {
+	switch name {
+	case "utf-16le":
+		return makeUTF16Decoder(false)
+	case "utf-16be":
+		return makeUTF16Decoder(true)
+	}
+	if table, ok := singleByteEncodings[name]; ok {
+		return makeSingleByteDecoder(table)
+	}
+	return nil
+}
+
+func makeSingleByteDecoder(table *[128]rune) decodeFunc {
+	return func(dst, src []byte, atEOF bool) ([]byte, int) {
+		var buf [utf8.UTFMax]byte
+		for _, c := range src {
+			if c < 0x80 {
+				dst = append(dst, c)
+				continue
+			}
+			n := utf8.EncodeRune(buf[:], table[c-0x80])
+			dst = append(dst, buf[:n]...)
+		}
+		return dst, len(src)
+	}
+}
+
+func makeUTF16Decoder(bigEndian bool) decodeFunc {
+	unit := func(b []byte) rune {
+		if bigEndian {
+			return rune(b[0])<<8 | rune(b[1])
+		}
+		return rune(b[1])<<8 | rune(b[0])
+	}
+	return func(dst, src []byte, atEOF bool) ([]byte, int) {
+		var buf [utf8.UTFMax]byte
+		i := 0
+		for i+1 < len(src) {
+			r, size := unit(src[i:]), 2
+			switch {
+			case 0xD800 <= r && r < 0xDC00:
+				if i+3 >= len(src) {
+					if !atEOF {
+						return dst, i
+					}
+					r = utf8.RuneError
+					break
+				}
+				if r2 := unit(src[i+2:]); 0xDC00 <= r2 && r2 < 0xE000 {
+					r, size = 0x10000+(r-0xD800)<<10+(r2-0xDC00), 4
+				} else {
+					r = utf8.RuneError
+				}
+			case 0xDC00 <= r && r < 0xE000:
+				r = utf8.RuneError
+			}
+			n := utf8.EncodeRune(buf[:], r)
+			dst = append(dst, buf[:n]...)
+			i += size
+		}
+		if atEOF && i < len(src) {
+			// half a code unit
+			dst = append(dst, string(utf8.RuneError)...)
+			i = len(src)
+		}
+		return dst, i
+	}
+}
+
+// A decodingReader reads the utf-8 its source decodes to.
+type decodingReader struct {
+	r      io.Reader
+	decode decodeFunc
+	src    []byte // read but not decoded yet
+	out    []byte // decoded but not read yet
+	err    error
+}
+
+// newDecodingReader returns a reader of the utf-8 r decodes to from the
+// encoding name.
+func newDecodingReader(r io.Reader, name string) io.Reader {
+	decode := decoderFor(name)
+	if decode == nil {
+		return r
+	}
+	return &decodingReader{r: r, decode: decode}
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		var buf [4096]byte
+		n, err := d.r.Read(buf[:])
+		d.err = err
+		src := append(d.src, buf[:n]...)
+		out, used := d.decode(nil, src, err != nil)
+		d.src = append(d.src[:0], src[used:]...)
+		d.out = out
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// An errReader fails every read with err.
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// Copyright 2011 Jeremy Wall (jeremy@marzhillstudios.com)
+// Use of this source code is governed by the Artistic License 2.0.
+// That License is included in the LICENSE file.
@@ -15,28 +15,64 @@ package h5
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"unicode"
 )
 
-// Represents an html5 parsing error. holds a message and the current html5 node
-// when the error occured.
+// Represents an html5 parsing error. holds the name the html5 spec gives the
+// error, where in the source it occured and the current html5 node when the
+// error occured.
 type ParseError struct {
-	msg  string
-	node *Node
+	Code    string   // The spec's name for the error, eg: "eof-in-comment"
+	Msg     string   // A description of the error, if the code needs one
+	Pos     Position // Where in the source the error occured
+	Context string   // The source leading up to the error
+	Node    *Node    // The node being parsed when the error occured, if any
 }
 
 // Constructor for an html5 parsing error
 func NewParseError(n *Node, msg string, args ...interface{}) *ParseError {
-	return &ParseError{node: n, msg: fmt.Sprintf(msg, args...)}
+	return &ParseError{Node: n, Msg: fmt.Sprintf(msg, args...)}
 }
 
 // Represent the parse error as a string
 func (e ParseError) Error() string {
-	return e.msg
+	msg := e.Msg
+	switch {
+	case e.Code == "":
+	case msg == "":
+		msg = e.Code
+	default:
+		msg = e.Code + ": " + msg
+	}
+	if e.Pos.Line > 0 {
+		msg = fmt.Sprintf("%d:%d: %s", e.Pos.Line, e.Pos.Column, msg)
+	}
+	if e.Context != "" {
+		msg = fmt.Sprintf("%s near %q", msg, e.Context)
+	}
+	return msg
+}
+
+// How much of the source a ParseError quotes as its Context
+const contextSize = 32
+
+// newParseError returns the error code at pos with the source read so far
+// as its context.
+func newParseError(p *Parser, pos Position, code, msg string) *ParseError {
+	context := p.recent
+	if len(context) > contextSize {
+		context = context[len(context)-contextSize:]
+	}
+	return &ParseError{Code: code, Msg: msg, Pos: pos,
+		Context: string(context), Node: p.curr}
+}
+
+// parseError records a recoverable parse error at the last character read.
+func parseError(p *Parser, code string) {
+	p.Errors = append(p.Errors, newParseError(p, p.last, code, ""))
 }
 
 // The html5 insertion mode for parsing
@@ -61,190 +97,12 @@ const (
 	im_inSelect           insertionMode = iota
 	im_inSelectInTable    insertionMode = iota
 	im_afterBody          insertionMode = iota
+	im_inFrameset         insertionMode = iota
 	im_afterFrameset      insertionMode = iota
 	im_afterAfterBody     insertionMode = iota
 	im_afterAfterFrameset insertionMode = iota
 )
 
-func insertionModeSwitch(p *Parser) stateHandler {
-	//fmt.Println("In insertionModeSwitch")
-	n := p.curr
-	currMode := p.Mode
-	switch currMode {
-	case im_initial:
-		fallthrough
-	case im_beforeHtml:
-		//fmt.Println("starting doctypeStateHandler")
-		p.Mode = im_beforeHead
-		return handleChar(startDoctypeStateHandler)
-		//fallthrough
-	case im_beforeHead:
-		switch n.Type {
-		case DoctypeNode:
-			// TODO(jwall): parse error
-		case CommentNode:
-		case ElementNode:
-			switch n.Data() {
-			case "head":
-				p.Mode = im_inHead
-			case "body":
-				p.Mode = im_inBody
-			case "script": // we need to handle scripts in the snippet case.
-				//fmt.Println("In a script tag")
-				p.Mode = im_text
-				return handleChar(startScriptDataState)
-			default:
-				// TODO(jwall): parse error
-			}
-		default:
-			// TODO(jwall): parse error
-		}
-	case im_inHead:
-		// TODO refactor into a function
-		switch n.Type {
-		case DoctypeNode:
-			// TODO(jwall): parse error
-		case CommentNode:
-		case ElementNode:
-			switch n.Data() {
-			case "script":
-				//fmt.Println("In a script tag")
-				p.Mode = im_text
-				return handleChar(startScriptDataState)
-			case "body":
-				p.Mode = im_inBody
-			case "title":
-				return rcDataStateStartHandler
-			default:
-				// TODO(jwall): parse error
-			}
-		default:
-			// TODO(jwall): parse error
-		}
-	case im_inHeadNoScript:
-	case im_afterHead:
-		// TODO refactor into a function
-		switch n.Type {
-		case DoctypeNode:
-			// TODO(jwall): parse error
-		case CommentNode:
-		case ElementNode:
-			switch n.Data() {
-			case "body":
-				p.Mode = im_inBody
-			default:
-				// TODO(jwall): parse error
-				// inject a body tag first?
-			}
-		default:
-			// TODO(jwall): parse error
-		}
-	case im_inTable:
-		fallthrough
-	case im_inTableText:
-		fallthrough
-	case im_inCaption:
-		fallthrough
-	case im_inColumnGroup:
-		fallthrough
-	case im_inTableBody:
-		fallthrough
-	case im_inRow:
-		fallthrough
-	case im_inCell:
-		fallthrough
-	case im_inSelect:
-		fallthrough
-	case im_inSelectInTable:
-		fallthrough
-	case im_afterBody:
-		fallthrough
-	case im_inBody:
-		// TODO refactor into a function
-		switch n.Type {
-		case DoctypeNode:
-			// TODO(jwall): parse error
-		case CommentNode:
-		case ElementNode:
-			// see http://www.whatwg.org/specs/web-apps/current-work/multipage/tree-construction.html#parsing-main-inbody
-			switch n.Data() {
-			case "script":
-				//fmt.Println("In a script tag")
-				p.Mode = im_text
-				return handleChar(startScriptDataState)
-			case "h1", "h2", "h3", "h4", "h5", "h6":
-				// TODO
-				maybeCloseTags(n, []string{"h1", "h2", "h3", "h4", "h5", "h6"},
-					allScope)
-				fallthrough
-			case "pre", "listing":
-				fallthrough
-			case "form":
-				fallthrough
-			case "hr":
-				fallthrough
-			case "address", "article", "aside", "blockquote", "center",
-				"details", "dialog", "dir", "div", "dl", "fieldset",
-				"figcaption", "figure", "footer", "header", "hgroup",
-				"menu", "nav", "ol", "p", "section", "summary", "ul":
-				// TODO(jwall): should this live in the start-tag code?
-				maybeCloseTag(n, "p", buttonScope)
-			case "li":
-				// TODO handle isSpecial
-				// TODO handle no more content in parent element
-				maybeCloseTag(n, "li", allScope)
-				maybeCloseTag(n, "p", buttonScope)
-			case "dd", "dt":
-				// TODO handle no more content in parent element
-				maybeCloseTags(n, []string{"dd", "dt"}, allScope)
-				maybeCloseTag(n, "p", buttonScope)
-			case "plaintext":
-				maybeCloseTag(n, "p", buttonScope)
-				// TODO plaintext state
-			case "button":
-				maybeCloseTag(n, "button", baseScope)
-			case "rp", "rt":
-				// TODO check for ruby element in scope
-				genImpliedEndTags(p)
-			case "option":
-				// TODO handle no more content in parent element
-				maybeCloseTag(n, "option", allScope)
-			case "optgroup":
-				// TODO handle no more content in parent element
-				maybeCloseTags(n, []string{"optgroup", "option"}, allScope)
-			case "colgroup":
-				// check for space or comment if not then:
-				//   maybeCloseTag(n, "optgroup", baseScope)
-			case "tr":
-				maybeCloseTag(n, "tr", allScope)
-			case "td", "th":
-				maybeCloseTags(n, []string{"td", "th"}, allScope)
-			case "textarea":
-				return rcDataStateStartHandler
-			default:
-				// TODO(jwall): parse error
-			}
-		}
-	case im_text:
-		// TODO refactor into a function
-		switch n.Data() {
-		case "script", "head", "html", "body":
-			p.Mode = im_inBody
-			popNode(p)
-			return handleChar(dataStateHandler)
-		}
-		return handleChar(scriptDataStateHandler)
-	case im_afterFrameset:
-		fallthrough
-	case im_afterAfterFrameset:
-		fallthrough
-	case im_afterAfterBody:
-		fallthrough
-		// TODO(jwall): parse error
-	}
-	return handleChar(dataStateHandler)
-}
-
 func oneOf(t string, ts ...string) bool {
 	for _, s := range ts {
 		if t == s {
@@ -254,34 +112,104 @@ func oneOf(t string, ts ...string) bool {
 	return false
 }
 
-func maybeCloseTag(n *Node, target string, scope map[string]bool) {
-	maybeCloseTags(n, []string{target}, scope)
+// dataStateHandlerSwitch hands the token the tokenizer just finished to the
+// tree construction stage and returns the tokenizer state it asks for.
+func dataStateHandlerSwitch(p *Parser) stateHandler {
+	emitToken(p)
+	switch p.state {
+	case rcDataState:
+		return handleChar(rcDataStateHandler)
+	case rawTextState:
+		return handleChar(rawTextStateHandler)
+	case scriptDataState:
+		return handleChar(scriptDataStateHandler)
+	case plainTextState:
+		return handleChar(plainTextStateHandler)
+	}
+	return handleChar(dataStateHandler)
 }
 
-func maybeCloseTags(n *Node, targets []string, scope map[string]bool) {
-	if n.Parent == nil {
+// emitToken takes the node the tokenizer was filling in out of the tree and
+// processes it as a token.
+func emitToken(p *Parser) {
+	n := p.tok
+	if n == nil {
 		return
-	} else if _, ok := scope[n.Parent.Data()]; ok {
-		// parse error?
+	}
+	p.tok = nil
+	detach(p, n)
+	n.Pos = p.tagPos
+	t := &token{node: n, selfClosing: p.selfClosing, pos: p.tagPos}
+	p.selfClosing = false
+	p.textPos = p.pos
+	switch n.Type {
+	case ElementNode:
+		t.kind, t.name = StartTagToken, n.Data()
+	case CommentNode:
+		t.kind = CommentToken
+	case DoctypeNode:
+		t.kind = DoctypeToken
+	default:
 		return
-	} else if oneOf(n.Parent.Data(), targets...) {
-		tag := n.Parent
-		gp := tag.Parent
-		tag.Children = tag.Children[:len(tag.Children)-1]
-		gp.Children = append(gp.Children, n)
-		n.Parent = gp
 	}
+	p.skipNewline = false
+	send(p, t)
 }
 
-func dataStateHandlerSwitch(p *Parser) stateHandler {
-	/*name := ""
-	if p.curr != nil {
-		name = p.curr.Data()
+// flushText processes the text read since the last token.
+func flushText(p *Parser) {
+	text := p.text
+	pos := p.textPos
+	p.text = nil
+	p.textPos = p.pos
+	if p.skipNewline && len(text) > 0 && text[0] == '\n' {
+		text = text[1:]
+	}
+	if len(text) > 0 {
+		p.skipNewline = false
+		send(p, &token{kind: TextToken, text: text, pos: pos})
+	}
+}
+
+// emitEndTag processes an end tag. It returns the error for an end tag with
+// no open element to close.
+func emitEndTag(p *Parser, tag []rune) error {
+	flushText(p)
+	p.skipNewline = false
+	send(p, &token{kind: EndTagToken, name: string(tag), pos: p.tagPos})
+	p.textPos = p.pos
+	return p.err
+}
+
+// send hands a token to the tree construction stage, or to p.emit if it is
+// set.
+func send(p *Parser, t *token) {
+	if p.emit != nil {
+		p.emit(t)
+		return
+	}
+	process(p, t)
+}
+
+// endOfFile processes what is left when the input runs out. A tag cut off
+// by the end of the input is dropped.
+func endOfFile(p *Parser) {
+	if p.tok != nil {
+		switch p.tok.Type {
+		case ElementNode:
+			parseError(p, "eof-in-tag")
+			detach(p, p.tok)
+			p.tok = nil
+		case CommentNode:
+			parseError(p, "eof-in-comment")
+		case DoctypeNode:
+			parseError(p, "eof-in-doctype")
+			p.forceQuirks = true
+		}
 	}
-	fmt.Printf(
-		"insertionMode: %v in dataStateHandlerSwitch with node: %q\n",
-		p.Mode, name)*/
-	return insertionModeSwitch(p)
+	emitToken(p)
+	flushText(p)
+	send(p, &token{kind: eofToken, pos: p.pos})
 }
 
 // An html5 parsing struct. It holds the parsing state for the html5 parsing
@@ -293,6 +221,62 @@ type Parser struct {
 	c    *rune
 	Mode insertionMode
 	buf  []rune // temporary buffer
+	// Document makes Parse build a whole html document: Top is then a
+	// DocumentNode and the html, head and body elements are made up if the
+	// source leaves them out.
+	Document bool
+	// Recover makes Parse handle all malformed html the way the html5 spec
+	// says to, like a browser does, rather than stop at problems it can't
+	// recover from without guessing, like an end tag with no element open
+	// to close. Parse then always builds a tree and the problems are all
+	// recorded in Errors.
+	Recover bool
+	// ContentType is the Content-Type the source was served with, if any.
+	// Setting it, or DetectEncoding, makes Parse work out the encoding of
+	// the source the way a browser does, see DetermineEncoding, and decode
+	// it. Otherwise the source is read as utf-8.
+	ContentType    string
+	DetectEncoding bool
+	// The name of the encoding Parse read the source in.
+	Encoding string
+	// Context makes Parse read the source as the contents of an element
+	// like Context, the way setting innerHTML does, rather than as a
+	// snippet or a whole document. Parse then builds a Document and the
+	// nodes of the source are in Fragment.
+	Context *Node
+	// The recoverable parse errors found so far, in the order they were
+	// found. The tree is built as the html5 spec says to despite them.
+	Errors []*ParseError
+
+	pos     Position // where the next character is read from
+	last    Position // where the last character read starts
+	prev    Position // where the character before that starts
+	backPos Position // pos before the pushed back character
+	tagPos  Position // where the tag being read starts
+	textPos Position // where the text being read starts
+	emit    func(*token)
+	recent  []rune // the last characters read, to quote in errors
+
+	tok         *Node  // the tag, comment or doctype being read
+	t           *token // the token being processed
+	selfClosing bool   // tok ended with "/>"
+	forceQuirks bool   // tok is a doctype that puts the document in quirks mode
+	text        []rune // the text read since the last token
+	state       textState
+	rawTag      string // the element whose contents are raw text
+
+	open         []*Node // the stack of open elements
+	afe          []*Node // active formatting elements, nil for markers
+	head         *Node
+	form         *Node
+	noFrameset   bool // the frameset-ok flag, inverted
+	foster       bool // foster parent nodes inserted into a table
+	originalMode insertionMode
+	tableText    []rune
+	tableTextPos Position
+	skipNewline  bool // drop a newline right after a pre or textarea
+	quirks       bool
+	err          error
 }
 
 type stateHandler func(p *Parser) (stateHandler, error)
@@ -311,134 +295,343 @@ func (p *Parser) nextInput() (rune, error) {
 	if p.c != nil {
 		c := p.c
 		p.c = nil
+		p.prev, p.last, p.pos = p.last, p.pos, p.backPos
 		//fmt.Printf("reread rune: %c\n", *c)
 		return *c, nil
 	}
-	r, _, err := p.In.ReadRune()
+	r, size, err := p.In.ReadRune()
 	//fmt.Printf("rune: %c\n", r)
+	if err == nil {
+		if len(p.recent) == 2*contextSize {
+			p.recent = append(p.recent[:0], p.recent[contextSize:]...)
+		}
+		p.recent = append(p.recent, r)
+		p.prev, p.last = p.last, p.pos
+		p.pos.Offset += size
+		p.pos.Column++
+		if r == '\n' {
+			p.pos.Line++
+			p.pos.Column = 1
+		}
+	}
 	return r, err
 }
 
 func (p *Parser) pushBack(c rune) {
 	p.c = &c
+	p.backPos, p.pos, p.last = p.pos, p.last, p.prev
 }
 
 // Parse an html stream.
-// Returns an os.Error if there was problem parsing the stream.
+// Returns a *ParseError if there was a problem parsing the stream that it
+// can't recover from, or the error reading it. The errors it recovers from
+// are collected in p.Errors. With p.Recover set the only error returned is
+// the error reading the stream, and the tree holds what was read before it.
 // The result of parsing can be retrieved with p.Tree()
 func (p *Parser) Parse() error {
-	// we start in the Doctype state
+	if p.Context != nil {
+		p.Document = true
+	}
+	if p.Document && p.Top == nil {
+		p.Top = &Node{Type: DocumentNode}
+	}
+	if p.DetectEncoding || p.ContentType != "" {
+		var r io.Reader
+		r, p.Encoding = NewDecoder(p.In, p.ContentType)
+		p.In = bufio.NewReader(r)
+	}
+	start(p)
+	if p.Context != nil && len(p.open) == 0 {
+		startFragment(p)
+	}
+	// we start in the Data state
 	// and in the Initial insertionMode
-	// start with a docType
 	h := dataStateHandlerSwitch(p)
 	for h != nil {
-		//if p.curr != nil && p.curr.data != nil {
-		//fmt.Printf("YYY: %v\n", p.curr.Data())
-		//}
 		h2, err := h(p)
 		if err == io.EOF {
-			//fmt.Println("End of file:")
-			return nil
+			break
 		}
 		if err != nil {
-			//fmt.Println("End of file: ", err)
-			// TODO parse error
-			return errors.New(fmt.Sprintf("Parse error: %s", err))
+			if p.Recover {
+				endOfFile(p)
+			}
+			return err
 		}
 		h = h2
 	}
+	endOfFile(p)
 	return nil
 }
 
+// start puts the parser at the beginning of the source.
+func start(p *Parser) {
+	if p.pos.Line == 0 {
+		p.pos = Position{Line: 1, Column: 1}
+		p.textPos = p.pos
+	}
+}
+
 // Return the parsed html5 tree or nil if parsing hasn't occured yet
 func (p *Parser) Tree() *Node {
 	return p.Top
 }
 
-func rcDataStateStartHandler(p *Parser) (stateHandler, error) {
-	pushNode(p)
-	p.Mode = im_text
-	return handleChar(rcDataStateHandler), nil
+// Fragment returns the nodes parsed with p.Context set. They are taken out
+// of the tree and have no parent, ready to go into another one.
+func (p *Parser) Fragment() []*Node {
+	if p.Context == nil || p.Top == nil {
+		return nil
+	}
+	for _, root := range p.Top.Children {
+		if root.Type == ElementNode && root.Data() == "html" {
+			ns := root.Children
+			root.Children = nil
+			for _, n := range ns {
+				n.Parent = nil
+			}
+			return ns
+		}
+	}
+	return nil
 }
 
-// TODO(jwall): UNITTESTS!!!!
+// Section 11.2.4.3
 func rcDataStateHandler(p *Parser, c rune) stateHandler {
 	switch c {
 	case '<':
-		return handleChar(rcDataLessThanSignState)
+		return handleChar(rawLessThanSignHandler(rcDataStateHandler))
 	case '&':
-		// TODO character references?
-		fallthrough
+		textConsumer(p, consumeCharRef(p, false)...)
 	default:
 		textConsumer(p, c)
-		return handleChar(rcDataStateHandler)
 	}
-	panic("unreachable")
+	return handleChar(rcDataStateHandler)
 }
 
-func rcDataLessThanSignState(p *Parser, c rune) stateHandler {
-	switch c {
-	case '/':
-		p.buf = nil
-		return handleChar(rcDataEndTagOpenState)
-	default:
-		textConsumer(p, c)
-		return handleChar(rcDataStateHandler)
+// Section 11.2.4.5
+func rawTextStateHandler(p *Parser, c rune) stateHandler {
+	if c == '<' {
+		return handleChar(rawLessThanSignHandler(rawTextStateHandler))
 	}
-	panic("unreachable")
+	textConsumer(p, c)
+	return handleChar(rawTextStateHandler)
 }
 
-func rcDataEndTagOpenState(p *Parser, c rune) stateHandler {
-	switch {
-	case 'A' <= c, c <= 'Z':
-		c = unicode.ToLower(c)
-		fallthrough
-	case 'a' <= c, c <= 'z':
-		popNode(p)
-		p.buf = append(p.buf, c)
-		return handleChar(rcDataEndTagNameState)
-	default:
+// Section 11.2.4.7
+func plainTextStateHandler(p *Parser, c rune) stateHandler {
+	textConsumer(p, c)
+	return handleChar(plainTextStateHandler)
+}
+
+// Sections 11.2.4.11, 11.2.4.14 and 11.2.4.17
+// rawLessThanSignHandler looks for an end tag after a '<' in the contents of
+// a raw text element. state reads the text around it. What is read of the
+// end tag goes into the text too, in case it turns out not to be one.
+func rawLessThanSignHandler(state func(*Parser, rune) stateHandler) func(*Parser, rune) stateHandler {
+	return func(p *Parser, c rune) stateHandler {
+		textConsumer(p, '<')
+		p.tagPos = p.prev
+		if c != '/' {
+			return state(p, c)
+		}
 		textConsumer(p, c)
-		return handleChar(rcDataStateHandler)
+		p.buf = p.buf[:0]
+		return handleChar(rawEndTagNameHandler(state))
 	}
-	panic("unreachable")
 }
 
-func rcDataEndTagNameState(p *Parser, c rune) stateHandler {
-	switch {
-	case c == '\t', c == '\n', c == '\r', c == ' ':
-	case c == '/':
-		return handleChar(selfClosingTagStartHandler)
-	case c == '>':
-		//fmt.Printf("buf: %q, tag %q\n", string(p.buf), p.curr.Data())
-		if string(p.buf) == p.curr.Data() { // appropriate end tag
-			return dataStateHandlerSwitch(p)
-		} else {
-			pushNode(p)
-			textConsumer(p, '>', c)
-			textConsumer(p, p.buf...)
-			return dataStateHandlerSwitch(p)
+// Sections 11.2.4.12-13, 11.2.4.15-16 and 11.2.4.18-19
+// rawEndTagNameHandler reads the name of an end tag in raw text. Only the end
+// tag of the element the text is in closes it, anything else is more text.
+func rawEndTagNameHandler(state func(*Parser, rune) stateHandler) func(*Parser, rune) stateHandler {
+	return func(p *Parser, c rune) stateHandler {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z':
+			p.buf = append(p.buf, c)
+			textConsumer(p, c)
+			return handleChar(rawEndTagNameHandler(state))
+		case len(p.buf) > 0 && strings.ToLower(string(p.buf)) == p.rawTag:
+			switch c {
+			case '\t', '\n', '\f', ' ', '/', '>':
+				// take the end tag back out of the text
+				p.text = p.text[:len(p.text)-len(p.buf)-2]
+				if c == '>' {
+					return endRawText(p)
+				}
+				return skipEndTagHandler
+			}
 		}
-	case 'A' <= c, c <= 'Z':
-		c = unicode.ToLower(c)
-		fallthrough
-	case 'a' <= c, c <= 'z':
-		p.buf = append(p.buf, c)
-		return handleChar(rcDataEndTagNameState)
-	default:
-		textConsumer(p, c)
-		textConsumer(p, p.buf...)
-		return handleChar(rcDataStateHandler)
+		return state(p, c)
 	}
-	panic("unreachable")
 }
 
-// TODO(jwall): UNITTESTS!!!!
+// skipEndTagHandler skips the attributes of the end tag of a raw text
+// element.
+func skipEndTagHandler(p *Parser) (stateHandler, error) {
+	for {
+		c, err := p.nextInput()
+		if err != nil {
+			return nil, err
+		}
+		if c == '>' {
+			return endRawText(p), nil
+		}
+	}
+}
+
+// endRawText closes a raw text element and goes back to the data state.
+func endRawText(p *Parser) stateHandler {
+	p.state = dataState
+	emitEndTag(p, []rune(p.rawTag))
+	return dataStateHandlerSwitch(p)
+}
+
 func textConsumer(p *Parser, chars ...rune) {
-	if p.curr == nil {
-		pushNode(p)
+	p.text = append(p.text, chars...)
+}
+
+// Section 11.2.4.69
+// consumeCharRef reads the character reference following an '&' and returns
+// the characters it stands for. Input that turns out not to be a reference
+// is returned as it was read. In attribute values (inAttr) a named reference
+// without its semicolon is left alone when a letter, a digit or '=' follows,
+// so that urls like "?a=1&copy=2" survive.
+func consumeCharRef(p *Parser, inAttr bool) []rune {
+	c, err := p.nextInput()
+	if err != nil {
+		return []rune{'&'}
+	}
+	if c == '#' {
+		return consumeNumericCharRef(p)
+	}
+	name := make([]rune, 0, longestEntityName)
+	for isAlnum(c) && len(name) < longestEntityName {
+		name = append(name, c)
+		if c, err = p.nextInput(); err != nil {
+			break
+		}
+	}
+	// the character after the name, if any
+	next, hasNext := c, err == nil
+	if hasNext && c == ';' && len(name) > 0 {
+		name = append(name, c)
+		next, hasNext = 0, false
+		if c, err = p.nextInput(); err == nil {
+			next, hasNext = c, true
+		}
+	}
+	if hasNext {
+		p.pushBack(next)
+	}
+	// the longest prefix of name that is a reference wins
+	for i := len(name); i > 0; i-- {
+		ref := string(name[:i])
+		r, ok := entity[ref]
+		r2, ok2 := entity2[ref]
+		if !ok && !ok2 {
+			continue
+		}
+		if name[i-1] != ';' && inAttr {
+			follows := next
+			if i < len(name) {
+				follows = name[i]
+			}
+			if (i < len(name) || hasNext) && (follows == '=' || isAlnum(follows)) {
+				break
+			}
+		}
+		if name[i-1] != ';' {
+			parseError(p, "missing-semicolon-after-character-reference")
+		}
+		var decoded []rune
+		if ok {
+			decoded = []rune{r}
+		} else {
+			decoded = r2[:]
+		}
+		return append(decoded, name[i:]...)
+	}
+	return append([]rune{'&'}, name...)
+}
+
+// Section 11.2.4.69
+// consumeNumericCharRef reads a decimal or hex reference following "&#".
+func consumeNumericCharRef(p *Parser) []rune {
+	read := []rune{'&', '#'}
+	c, err := p.nextInput()
+	if err != nil {
+		return read
+	}
+	base := rune(10)
+	if c == 'x' || c == 'X' {
+		base = 16
+		read = append(read, c)
+		if c, err = p.nextInput(); err != nil {
+			return read
+		}
+	}
+	var n rune
+	digits := 0
+	for {
+		d := digitValue(c, base)
+		if d < 0 {
+			break
+		}
+		digits++
+		if n <= unicode.MaxRune {
+			n = n*base + d
+		}
+		if c, err = p.nextInput(); err != nil {
+			break
+		}
 	}
-	p.curr.data = append(p.curr.data, chars...) // ugly but safer
+	if digits == 0 {
+		// not a reference after all
+		parseError(p, "absence-of-digits-in-numeric-character-reference")
+		if err == nil {
+			p.pushBack(c)
+		}
+		return read
+	}
+	if err != nil || c != ';' {
+		parseError(p, "missing-semicolon-after-character-reference")
+	}
+	if err == nil && c != ';' {
+		p.pushBack(c)
+	}
+	switch {
+	case 0x80 <= n && n <= 0x9F && c1Replacements[n-0x80] != 0:
+		parseError(p, "control-character-reference")
+		n = c1Replacements[n-0x80]
+	case n == 0:
+		parseError(p, "null-character-reference")
+		n = unicode.ReplacementChar
+	case n > unicode.MaxRune:
+		parseError(p, "character-reference-outside-unicode-range")
+		n = unicode.ReplacementChar
+	case 0xD800 <= n && n <= 0xDFFF:
+		parseError(p, "surrogate-character-reference")
+		n = unicode.ReplacementChar
+	}
+	return []rune{n}
+}
+
+func isAlnum(c rune) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// digitValue returns the value of c as a digit in base, or -1 if it isn't one.
+func digitValue(c, base rune) rune {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case base == 16 && 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	case base == 16 && 'A' <= c && c <= 'F':
+		return c - 'A' + 10
+	}
+	return -1
 }
 
 // TODO(jwall): UNITTESTS!!!!
@@ -453,54 +646,34 @@ func handleChar(h func(*Parser, rune) stateHandler) stateHandler {
 	}
 }
 
-func startDoctypeStateHandler(p *Parser, c rune) stateHandler {
-	//fmt.Printf("Starting Doctype handler c:%c\n", c)
-	switch c {
-	case '<':
-		c2, err := p.nextInput()
-		if err != nil {
-			// correctly handle EOF
-			return nil
-		}
-		if c2 == '!' { // its a doc type tag yay
-			return handleChar(doctypeStateHandler)
-		} else { // whoops not a doctype tag
-			// TODO setup a default doctype
-			// TODO we need a way to reconsume two characters :-(
-			p.pushBack(c2)
-			return dataStateHandler(p, c)
-		}
-	default:
-		// TODO setup a default doctype
-		return dataStateHandler(p, c)
-	}
-	panic("unreachable")
-}
-
 // Section 11.2.4.52
 func doctypeStateHandler(p *Parser, c rune) stateHandler {
-	//fmt.Printf("Parsing Doctype c:%c\n", c)
 	switch c {
 	case '\t', '\n', '\f', ' ':
 		return handleChar(beforeDoctypeHandler)
 	default:
-		// TODO parse error
+		parseError(p, "missing-whitespace-before-doctype-name")
 		// reconsume in BeforeDoctypeState
 		return beforeDoctypeHandler(p, c)
 	}
-	panic("unreachable")
 }
 
 // Section 11.2.4.53
 func beforeDoctypeHandler(p *Parser, c rune) stateHandler {
-	curr := pushNode(p)
-	curr.Type = DoctypeNode
+	curr := p.tok
+	if curr == nil {
+		curr = pushNode(p)
+		curr.Type = DoctypeNode
+		p.tok = curr
+		p.forceQuirks = false
+	}
 	switch {
 	case c == '\t', c == '\n', c == '\f', c == ' ':
 		// ignore
 		return handleChar(beforeDoctypeHandler)
 	case c == '>':
-		// TODO parse error, quirks mode
+		parseError(p, "missing-doctype-name")
+		p.forceQuirks = true
 		return dataStateHandlerSwitch(p)
 	case 'A' <= c && c <= 'Z':
 		lc := unicode.ToLower(c)
@@ -510,7 +683,6 @@ func beforeDoctypeHandler(p *Parser, c rune) stateHandler {
 		curr.data = append(curr.data, c)
 		return handleChar(doctypeNameState)
 	}
-	panic("unreachable")
 }
 
 // Section 11.2.4.54
@@ -518,10 +690,9 @@ func doctypeNameState(p *Parser, c rune) stateHandler {
 	n := p.curr
 	switch {
 	case c == '\t', c == '\n', c == '\f', c == ' ':
-		// ignore
 		return afterDoctypeNameHandler
 	case c == '>':
-		return afterDoctypeNameHandler
+		return dataStateHandlerSwitch(p)
 	case 'A' <= c && c <= 'Z':
 		lc := unicode.ToLower(c)
 		n.data = append(n.data, lc)
@@ -530,7 +701,6 @@ func doctypeNameState(p *Parser, c rune) stateHandler {
 		n.data = append(n.data, c)
 		return handleChar(doctypeNameState)
 	}
-	panic("unreachable")
 }
 
 var (
@@ -542,345 +712,288 @@ var (
 
 // Section 11.2.4.55
 func afterDoctypeNameHandler(p *Parser) (stateHandler, error) {
-	firstSix := make([]rune, 0, 6)
-	//n := p.curr
+	keyword := make([]rune, 0, 6)
 	for {
 		c, err := p.nextInput()
-		if err == io.EOF {
-			// TODO parse error
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case c == '>':
+			if len(keyword) > 0 {
+				parseError(p, "invalid-character-sequence-after-doctype-name")
+				p.forceQuirks = true
+			}
 			return dataStateHandlerSwitch(p), nil
+		case len(keyword) == 0 && (c == '\t' || c == '\n' || c == '\f' || c == ' '):
+			// ignore
+			continue
+		}
+		keyword = append(keyword, unicode.ToLower(c))
+		if len(keyword) < cap(keyword) {
+			continue
 		}
+		switch k := string(keyword); k {
+		case public, system:
+			return handleChar(afterDoctypeHandler(k)), nil
+		}
+		parseError(p, "invalid-character-sequence-after-doctype-name")
+		p.forceQuirks = true
+		return bogusDoctypeHandler, nil
+	}
+}
+
+// Sections 11.2.4.56 and 11.2.4.62
+// afterDoctypeHandler reads what follows the PUBLIC or SYSTEM keyword of a
+// doctype.
+func afterDoctypeHandler(keyword string) func(*Parser, rune) stateHandler {
+	return func(p *Parser, c rune) stateHandler {
 		switch c {
 		case '\t', '\n', '\f', ' ':
 			// ignore
-			return afterDoctypeNameHandler, nil
+			return handleChar(beforeDoctypeIdentHandler(keyword))
+		case '"', '\'':
+			parseError(p, "missing-whitespace-after-doctype-keyword")
+			return handleChar(makeIdentQuotedHandler(keyword, c))
 		case '>':
-			return dataStateHandlerSwitch(p), nil
-		default:
-			if len(firstSix) == cap(firstSix) {
-				switch string(firstSix) {
-				case public:
-					p.curr.Public = true
-					return handleChar(afterDoctypeHandler), nil
-				case system:
-					p.curr.System = true
-					return handleChar(afterDoctypeHandler), nil
-				}
-			} else {
-				lc := unicode.ToLower(c)
-				firstSix = append(firstSix, lc)
-			}
+			parseError(p, "missing-doctype-identifier")
+			p.forceQuirks = true
+			return dataStateHandlerSwitch(p)
 		}
+		parseError(p, "missing-quote-before-doctype-identifier")
+		p.forceQuirks = true
+		return bogusDoctypeHandler
 	}
-	panic("unreachable")
-}
-
-// Section 11.2.4.56
-func afterDoctypeHandler(p *Parser, c rune) stateHandler {
-	switch c {
-	case '\t', '\n', '\f', ' ':
-		// ignore
-		return handleChar(beforeDoctypeIdentHandler)
-	case '"', '\'':
-		// TODO parse error
-		return handleChar(makeIdentQuotedHandler(c))
-	case '>':
-		// TODO parse error
-		return dataStateHandlerSwitch(p)
-	default:
-		// TODO parse error
-		// TODO bogusDoctypeState
-	}
-	panic("unreachable")
 }
 
-// Section 11.2.4.57
-func beforeDoctypeIdentHandler(p *Parser, c rune) stateHandler {
-	switch c {
-	case '\t', '\n', '\f', ' ':
-		// ignore
-		return handleChar(beforeDoctypeIdentHandler)
-	case '"', '\'':
-		return handleChar(makeIdentQuotedHandler(c))
-	case '>':
-		// TODO parse error
-		return dataStateHandlerSwitch(p)
-	default:
-		// TODO parse error
-		// TODO bogusDoctypeState
+// Sections 11.2.4.57 and 11.2.4.63
+func beforeDoctypeIdentHandler(keyword string) func(*Parser, rune) stateHandler {
+	return func(p *Parser, c rune) stateHandler {
+		switch c {
+		case '\t', '\n', '\f', ' ':
+			// ignore
+			return handleChar(beforeDoctypeIdentHandler(keyword))
+		case '"', '\'':
+			return handleChar(makeIdentQuotedHandler(keyword, c))
+		case '>':
+			parseError(p, "missing-doctype-identifier")
+			p.forceQuirks = true
+			return dataStateHandlerSwitch(p)
+		}
+		parseError(p, "missing-quote-before-doctype-identifier")
+		p.forceQuirks = true
+		return bogusDoctypeHandler
 	}
-	panic("unreachable")
 }
 
-// Section 11.2.4.58
-func makeIdentQuotedHandler(q rune) func(*Parser, rune) stateHandler {
+// Sections 11.2.4.58-59 and 11.2.4.64-65
+// makeIdentQuotedHandler reads the public or system identifier, as keyword
+// says, quoted by q. A system identifier following a public one goes into
+// SystemIdentifier.
+func makeIdentQuotedHandler(keyword string, q rune) func(*Parser, rune) stateHandler {
 	return func(p *Parser, c rune) stateHandler {
+		n := p.tok
+		id := &n.Identifier
+		switch {
+		case keyword == public:
+			n.Public = true
+		case n.Public:
+			n.System = true
+			id = &n.SystemIdentifier
+		default:
+			n.System = true
+		}
 		c2 := c
 		for {
 			if q == c2 {
-				return handleChar(afterDoctypeIdentifierHandler)
+				return handleChar(afterDoctypeIdentifierHandler(keyword))
 			}
 			if c2 == '>' {
-				// TODO parse error
+				parseError(p, "abrupt-doctype-identifier")
+				p.forceQuirks = true
 				return dataStateHandlerSwitch(p)
 			}
-			p.curr.Identifier = append(p.curr.Identifier, c2)
+			*id = append(*id, c2)
 			next, err := p.nextInput()
 			if err != nil {
-				// TODO parse error
 				return nil
 			}
 			c2 = next
 		}
-		panic("unreachable")
-	}
-}
-
-// section 11.2.4.59
-func afterDoctypeIdentifierHandler(p *Parser, c rune) stateHandler {
-	switch c {
-	case '\t', '\n', '\f', ' ':
-		return handleChar(afterDoctypeIdentifierHandler)
-	case '>':
-		p.Mode = im_beforeHtml
-		return dataStateHandlerSwitch(p)
-	default:
-		// TODO parse error
-		// TODO switch to bogus Doctype state
 	}
-	panic("unreachable")
 }
 
-func startScriptDataState(p *Parser, c rune) stateHandler {
-	//fmt.Println("Adding TextNode")
-	pushNode(p) // push a text node onto the stack
-	return scriptDataStateHandler(p, c)
-}
-
-func scriptDataStringHandler(s rune) func(p *Parser, c rune) stateHandler {
+// Sections 11.2.4.60 and 11.2.4.66
+// afterDoctypeIdentifierHandler reads what follows the public or system
+// identifier of a doctype. A public one can be followed by a system one.
+func afterDoctypeIdentifierHandler(keyword string) func(*Parser, rune) stateHandler {
 	return func(p *Parser, c rune) stateHandler {
-		textConsumer(p, c)
-		for {
-			c2, err := p.nextInput()
-			if err != nil {
-				// TODO parseError
-				return nil
-			}
-
-			if c2 == s {
-				textConsumer(p, c2)
-				return handleChar(scriptDataStateHandler)
+		switch c {
+		case '\t', '\n', '\f', ' ':
+			if keyword == public {
+				return handleChar(betweenDoctypeIdentifiersHandler)
 			}
-
-			if c2 == '\\' {
-				textConsumer(p, c2)
-				c2, err = p.nextInput()
-				if err != nil {
-					println("Incomplete String")
-					return nil
-				}
+			return handleChar(afterDoctypeIdentifierHandler(keyword))
+		case '>':
+			return dataStateHandlerSwitch(p)
+		case '"', '\'':
+			if keyword == public {
+				parseError(p, "missing-whitespace-between-doctype-public-and-system-identifiers")
+				return handleChar(makeIdentQuotedHandler(system, c))
 			}
-
-			textConsumer(p, c2)
 		}
-		panic("unreachable")
-	}
-}
-
-func scriptDataStateHandler(p *Parser, c rune) stateHandler {
-	switch c {
-	case '<':
-		return handleChar(scriptDataLessThanHandler)
-	default:
-		textConsumer(p, c)
-		for {
-			c2, err := p.nextInput()
-			if err != nil {
-				// TODO parseError
-				return nil
-			}
-			if c2 == '<' {
-				return handleChar(scriptDataLessThanHandler)
-			}
-
-			if c2 == '"' || c2 == '\'' {
-				textConsumer(p, c2)
-				return handleChar(scriptDataStringHandler(c2))
-			}
-			textConsumer(p, c2)
+		if keyword == public {
+			parseError(p, "missing-quote-before-doctype-system-identifier")
+			p.forceQuirks = true
+		} else {
+			parseError(p, "unexpected-character-after-doctype-system-identifier")
 		}
+		return bogusDoctypeHandler
 	}
-	panic("unreachable")
 }
 
-func scriptDataLessThanHandler(p *Parser, c rune) stateHandler {
-	//fmt.Printf("handling a '<' in script data c: %c\n", c)
+// Section 11.2.4.61
+func betweenDoctypeIdentifiersHandler(p *Parser, c rune) stateHandler {
 	switch c {
-	case '/':
-		p.buf = make([]rune, 0, 1)
-		return handleChar(scriptDataEndTagOpenHandler)
-	default:
-		textConsumer(p, '<', c)
-		return handleChar(scriptDataStateHandler)
-	}
-	panic("unreachable")
-}
-
-func scriptDataEndTagOpenHandler(p *Parser, c rune) stateHandler {
-	//fmt.Printf("trying to close script tag c: %c\n", c)
-	switch {
-	case 'A' <= c && c <= 'Z':
-		lc := unicode.ToLower(c)
-		p.buf = append(p.buf, lc)
-		return handleChar(scriptDataEndTagNameHandler)
-	case 'a' <= c && c <= 'z':
-		p.buf = append(p.buf, c)
-		return handleChar(scriptDataEndTagNameHandler)
-	default:
-		textConsumer(p, '<', '/')
-		return handleChar(scriptDataStateHandler)
+	case '\t', '\n', '\f', ' ':
+		// ignore
+		return handleChar(betweenDoctypeIdentifiersHandler)
+	case '"', '\'':
+		return handleChar(makeIdentQuotedHandler(system, c))
+	case '>':
+		return dataStateHandlerSwitch(p)
 	}
-	panic("unreachable")
+	parseError(p, "missing-quote-before-doctype-system-identifier")
+	p.forceQuirks = true
+	return bogusDoctypeHandler
 }
 
-func scriptDataEndTagNameHandler(p *Parser, c rune) stateHandler {
-	//fmt.Printf("script tag name handler c:%c\n", c)
-	n := p.curr
-	switch {
-	case c == '\t', c == '\f', c == '\n', c == ' ':
-		if n.Data() == string(p.buf) {
-			return handleChar(beforeAttributeNameHandler)
-		} else {
-			p.buf = append(p.buf, c)
-			return handleChar(scriptDataStateHandler)
+// Section 11.2.4.67
+func bogusDoctypeHandler(p *Parser) (stateHandler, error) {
+	for {
+		c, err := p.nextInput()
+		if err == io.EOF {
+			// the doctype is complete, with no eof-in-doctype error
+			emitToken(p)
 		}
-	case c == '/':
-		if n.Parent.Data() == string(p.buf) {
-			return handleChar(selfClosingTagStartHandler)
-		} else {
-			//fmt.Println("we don't match :-( keep going")
-			return handleChar(scriptDataStateHandler)
+		if err != nil {
+			return nil, err
 		}
-	case c == '>':
-		if n.Parent.Data() == string(p.buf) {
-			//fmt.Printf("time to see about closing it :-)")
-			popNode(p)
-			return dataStateHandlerSwitch(p)
-		} else {
-			//fmt.Println("we don't match :-( keep going")
-			return handleChar(scriptDataStateHandler)
+		if c == '>' {
+			return dataStateHandlerSwitch(p), nil
 		}
-	case 'A' <= c && c <= 'Z':
-		lc := unicode.ToLower(c)
-		p.buf = append(p.buf, lc)
-		return handleChar(scriptDataEndTagNameHandler)
-	case 'a' <= c && c <= 'z':
-		p.buf = append(p.buf, c)
-		return handleChar(scriptDataEndTagNameHandler)
-	default:
-		textConsumer(p, '<', '/')
-		textConsumer(p, p.buf...)
-		return handleChar(scriptDataStateHandler)
 	}
-	panic("unreachable")
+}
+
+// Section 11.2.4.6
+func scriptDataStateHandler(p *Parser, c rune) stateHandler {
+	if c == '<' {
+		return handleChar(rawLessThanSignHandler(scriptDataStateHandler))
+	}
+	textConsumer(p, c)
+	return handleChar(scriptDataStateHandler)
 }
 
 // Section 11.2.4.1
 func dataStateHandler(p *Parser, c rune) stateHandler {
-	//fmt.Printf("In dataStateHandler c:%c\n", c)
-	//if p.curr != nil { fmt.Println("curr node: ", p.curr.Data()) }
-	//fmt.Println("curr node textNode?",
-	//	(p.curr != nil) && (p.curr.Type == TextNode))
-	// consume the token
-	if p.curr != nil {
-		switch p.curr.Data() {
-		case "base", "bgsound", "command", "link", "meta",
-			"area", "br", "embed", "img", "keygen", "wbr",
-			"param", "source", "track", "hr", "input", "image":
-			popNode(p)
+	for {
+		switch c {
+		case '<':
+			// the text up to a tag is a token of its own
+			flushText(p)
+			p.tagPos = p.last
+			return handleChar(tagOpenHandler)
+		case '&':
+			textConsumer(p, consumeCharRef(p, false)...)
+		default:
+			textConsumer(p, c)
 		}
-		// this is the end of the textNode so pop it from stack
-		//fmt.Println("TTT: popping textNode from stack")
-		if p.curr.Type == TextNode {
-			popNode(p)
+		c2, err := p.nextInput()
+		if err != nil {
+			return nil
 		}
+		c = c2
 	}
-	switch c {
-	case '<':
-		return handleChar(tagOpenHandler)
-	default:
-		pushNode(p)
-		textConsumer(p, c)
-		for {
-			c2, err := p.nextInput()
-			if err != nil {
-				// TODO parseError
-				return nil
+}
+
+// Section 11.2.4.45
+func markupDeclarationOpenHandler(p *Parser) (stateHandler, error) {
+	if p.c == nil {
+		if b, _ := p.In.Peek(2); string(b) == "--" {
+			p.nextInput()
+			p.nextInput()
+			n := pushNode(p)
+			n.Type = CommentNode
+			p.tok = n
+			return htmlCommentHandler, nil
+		}
+		if b, _ := p.In.Peek(7); strings.EqualFold(string(b), "doctype") {
+			for i := 0; i < len(b); i++ {
+				p.nextInput()
 			}
-			if c2 == '<' { // for loop end condition
-				return dataStateHandler(p, c2)
+			return handleChar(doctypeStateHandler), nil
+		}
+		n := adjustedCurrentNode(p)
+		if b, _ := p.In.Peek(7); string(b) == "[CDATA[" &&
+			n != nil && n.Type == ElementNode && n.Namespace != "" {
+			for i := 0; i < len(b); i++ {
+				p.nextInput()
 			}
-			textConsumer(p, c2)
+			p.textPos = p.pos
+			return cdataSectionHandler, nil
 		}
 	}
-	panic("Unreachable")
-}
-
-func startHtmlCommentHandler(p *Parser) (stateHandler, error) {
-	//fmt.Println("handling an html comment")
-	d1, err := p.nextInput()
-	if err != nil {
-		// TODO parse error
-		return nil, err
-	}
-	d2, err := p.nextInput()
-	if err != nil {
-		// TODO parse error
-		return nil, err
-	}
-	if d1 == '-' && d2 == '-' {
-		n := pushNode(p)
-		n.Type = CommentNode
-		return htmlCommentHandler, nil
-	}
-	return dataStateHandlerSwitch(p), nil
+	parseError(p, "incorrectly-opened-comment")
+	return bogusCommentHandler, nil
 }
 
-func htmlCommentHandler(p *Parser) (stateHandler, error) {
-	n := p.curr
+// Section 11.2.4.68
+// cdataSectionHandler reads a CDATA section in foreign content up to the
+// "]]>" that ends it. What is in it is text.
+func cdataSectionHandler(p *Parser) (stateHandler, error) {
 	for {
 		c, err := p.nextInput()
+		if err == io.EOF {
+			parseError(p, "eof-in-cdata")
+		}
 		if err != nil {
 			return nil, err
 		}
-		if c == '-' {
-			return endHtmlCommentHandler, nil
-		} else {
-			n.data = append(n.data, c)
+		textConsumer(p, c)
+		if k := len(p.text); c == '>' && k >= 3 && string(p.text[k-3:]) == "]]>" {
+			p.text = p.text[:k-3]
+			return handleChar(dataStateHandler), nil
 		}
 	}
-	return dataStateHandlerSwitch(p), nil
 }
 
-func endHtmlCommentHandler(p *Parser) (stateHandler, error) {
-	c, err := p.nextInput()
-	if err != nil {
-		return nil, err
-	}
-	if c == '-' {
-		c2, err := p.nextInput()
+// Sections 11.2.4.46-51
+// htmlCommentHandler reads a comment up to the "-->" that ends it.
+func htmlCommentHandler(p *Parser) (stateHandler, error) {
+	n := p.curr
+	for {
+		c, err := p.nextInput()
 		if err != nil {
 			return nil, err
 		}
-		if c2 == '>' { // close the comment
-			popNode(p)
-			return dataStateHandlerSwitch(p), nil
-		} else { // still in comment
-			return htmlCommentHandler, nil
+		n.data = append(n.data, c)
+		if c != '>' {
+			continue
 		}
+		switch s := string(n.data); {
+		case s == ">", s == "->":
+			parseError(p, "abrupt-closing-of-empty-comment")
+			n.data = n.data[:0]
+		case strings.HasSuffix(s, "-->"):
+			n.data = n.data[:len(n.data)-3]
+		case strings.HasSuffix(s, "--!>"):
+			parseError(p, "incorrectly-closed-comment")
+			n.data = n.data[:len(n.data)-4]
+		default:
+			continue
+		}
+		return dataStateHandlerSwitch(p), nil
 	}
-	// still in a comment
-	return htmlCommentHandler, nil
 }
 
 // Section 11.2.4.8
@@ -888,10 +1001,12 @@ func tagOpenHandler(p *Parser, c rune) stateHandler {
 	//fmt.Printf("opening a tag\n")
 	switch {
 	case c == '!': // markup declaration state
-		return startHtmlCommentHandler
+		return markupDeclarationOpenHandler
 	case c == '/': // end tag open state
 		return endTagOpenHandler
-	case c == '?': // TODO parse error // bogus comment state
+	case c == '?': // bogus comment state
+		parseError(p, "unexpected-question-mark-instead-of-tag-name")
+		p.pushBack(c)
 		return bogusCommentHandler
 	case 'A' <= c && c <= 'Z':
 		//fmt.Printf("ZZZ: opening a new tag\n")
@@ -899,17 +1014,21 @@ func tagOpenHandler(p *Parser, c rune) stateHandler {
 		curr.Type = ElementNode
 		lc := unicode.ToLower(c)
 		curr.data = []rune{lc}
+		p.tok = curr
 		return handleChar(tagNameHandler)
 	case 'a' <= c && c <= 'z', c == '_', c == '-':
 		//fmt.Printf("ZZZ: opening a new tag\n")
 		curr := pushNode(p)
 		curr.Type = ElementNode
 		curr.data = []rune{c}
+		p.tok = curr
 		return handleChar(tagNameHandler)
-	default: // parse error // recover using Section 11.2.4.8 rules
-		// TODO
 	}
-	return nil
+	parseError(p, "invalid-first-character-of-tag-name")
+	// not a tag after all, the '<' is text
+	p.textPos = p.tagPos
+	textConsumer(p, '<')
+	return dataStateHandler(p, c)
 }
 
 // Section 11.2.4.10
@@ -931,7 +1050,6 @@ func tagNameHandler(p *Parser, c rune) stateHandler {
 		n.data = append(n.data, c)
 		return handleChar(tagNameHandler)
 	}
-	panic("Unreachable")
 }
 
 // Section 11.2.4.34
@@ -951,8 +1069,11 @@ func beforeAttributeNameHandler(p *Parser, c rune) stateHandler {
 		newAttr.Name = string(lc)
 		n.Attr = append(n.Attr, newAttr)
 		return handleChar(attributeNameHandler)
-	case c == '=', c == '"', c == '\'', c == '<':
-		// TODO parse error
+	case c == '=':
+		parseError(p, "unexpected-equals-sign-before-attribute-name")
+		fallthrough
+	case c == '"', c == '\'', c == '<':
+		parseError(p, "unexpected-character-in-attribute-name")
 		fallthrough
 	default:
 		newAttr := new(Attribute)
@@ -960,7 +1081,6 @@ func beforeAttributeNameHandler(p *Parser, c rune) stateHandler {
 		n.Attr = append(n.Attr, newAttr)
 		return handleChar(attributeNameHandler)
 	}
-	panic("Unreachable")
 }
 
 // Section 11.2.4.35
@@ -981,14 +1101,13 @@ func attributeNameHandler(p *Parser, c rune) stateHandler {
 		currAttr.Name += string(lc)
 		return handleChar(attributeNameHandler)
 	case c == '"', c == '\'', c == '<':
-		// TODO parse error
+		parseError(p, "unexpected-character-in-attribute-name")
 		fallthrough
 	default:
 		currAttr := n.Attr[len(n.Attr)-1]
 		currAttr.Name += string(c)
 		return handleChar(attributeNameHandler)
 	}
-	panic("Unreachable")
 }
 
 // Section 11.2.4.37
@@ -1003,14 +1122,16 @@ func beforeAttributeValueHandler(p *Parser, c rune) stateHandler {
 		return handleChar(makeAttributeValueQuotedHandler(c))
 	case '>':
 		return dataStateHandlerSwitch(p)
+	case '&':
+		currAttr.Value += string(consumeCharRef(p, true))
+		return handleChar(attributeValueUnquotedHandler)
 	case '<', '=', '`':
-		// todo parse error
+		parseError(p, "unexpected-character-in-unquoted-attribute-value")
 		fallthrough
 	default:
 		currAttr.Value += string(c)
 		return handleChar(attributeValueUnquotedHandler)
 	}
-	panic("Unreachable")
 }
 
 // Section 11.2.4.3{8,9}
@@ -1025,10 +1146,13 @@ func makeAttributeValueQuotedHandler(c rune) func(p *Parser, c rune) stateHandle
 			fallthrough
 		default:
 			currAttr := n.Attr[len(n.Attr)-1]
-			currAttr.Value += string(c2)
+			if c2 == '&' {
+				currAttr.Value += string(consumeCharRef(p, true))
+			} else {
+				currAttr.Value += string(c2)
+			}
 			return handleChar(makeAttributeValueQuotedHandler(c))
 		}
-		panic("Unreachable")
 	}
 }
 
@@ -1040,15 +1164,18 @@ func attributeValueUnquotedHandler(p *Parser, c rune) stateHandler {
 		return handleChar(beforeAttributeNameHandler)
 	case '>':
 		return dataStateHandlerSwitch(p)
+	case '&':
+		currAttr := n.Attr[len(n.Attr)-1]
+		currAttr.Value += string(consumeCharRef(p, true))
+		return handleChar(attributeValueUnquotedHandler)
 	case '"', '\'', '<', '=', '`':
-		// TODO parse error
+		parseError(p, "unexpected-character-in-unquoted-attribute-value")
 		fallthrough
 	default:
 		currAttr := n.Attr[len(n.Attr)-1]
 		currAttr.Value += string(c)
 		return handleChar(attributeValueUnquotedHandler)
 	}
-	panic("Unreachable")
 }
 
 // Section 11.2.4.42
@@ -1061,10 +1188,10 @@ func afterAttributeValueQuotedHandler(p *Parser, c rune) stateHandler {
 	case '>':
 		return dataStateHandlerSwitch(p)
 	default:
-		// TODO Parse error Reconsume the Character in the before attribute name state
-		return handleChar(beforeAttributeNameHandler)
+		parseError(p, "missing-whitespace-between-attributes")
+		// reconsume in the before attribute name state
+		return beforeAttributeNameHandler(p, c)
 	}
-	panic("Unreachable")
 }
 
 // Section 11.2.4.36
@@ -1086,7 +1213,7 @@ func afterAttributeNameHandler(p *Parser, c rune) stateHandler {
 		n.Attr = append(n.Attr, newAttr)
 		return handleChar(attributeNameHandler)
 	case c == '"', c == '\'', c == '<':
-		// TODO parse error
+		parseError(p, "unexpected-character-in-attribute-name")
 		fallthrough
 	default:
 		newAttr := new(Attribute)
@@ -1094,7 +1221,6 @@ func afterAttributeNameHandler(p *Parser, c rune) stateHandler {
 		n.Attr = append(n.Attr, newAttr)
 		return handleChar(attributeNameHandler)
 	}
-	panic("Unreachable")
 }
 
 // Section 11.2.4.43
@@ -1102,42 +1228,18 @@ func selfClosingTagStartHandler(p *Parser, c rune) stateHandler {
 	//fmt.Println("starting self closing tag handler")
 	switch c {
 	case '>':
-		popNode(p)
+		p.selfClosing = true
 		return dataStateHandlerSwitch(p)
 	default:
-		// TODO parse error reconsume as before attribute handler
-		return handleChar(beforeAttributeNameHandler)
+		parseError(p, "unexpected-solidus-in-tag")
+		// reconsume in the before attribute name state
+		return beforeAttributeNameHandler(p, c)
 	}
-	panic("Unreachable")
-}
-
-func newEndTagError(problem string, n *Node, tag []rune) error {
-	msg := fmt.Sprintf(
-		"%s: End Tag does not match Start Tag start:[%s] end:[%s]",
-		problem, n.Data(), string(tag))
-	//fmt.Println(msg)
-	return NewParseError(n, msg)
-}
-
-func genImpliedEndTags(p *Parser) {
-	for {
-		switch p.curr.Data() {
-		case "dd", "dt", "li", "option", "optgroup", "p", "rp", "rt":
-			//fmt.Println("Found an implied end tag", p.curr.Data())
-			popNode(p)
-		default:
-			return
-		}
-	}
-	return
 }
 
 // Section 11.2.4.9
 func endTagOpenHandler(p *Parser) (stateHandler, error) {
-	// compare to current tags name
-	//fmt.Println("YYY: attempting to close a node")
-	n := p.curr
-	tag := make([]rune, 0, len(n.data))
+	tag := make([]rune, 0, 8)
 	for {
 		c, err := p.nextInput()
 		if err == io.EOF { // Parse Error
@@ -1147,46 +1249,63 @@ func endTagOpenHandler(p *Parser) (stateHandler, error) {
 			return nil, err
 		}
 		switch {
+		case c == '>' && len(tag) == 0:
+			parseError(p, "missing-end-tag-name")
+			return dataStateHandlerSwitch(p), nil
 		case c == '>':
 			// TODO tests for this
-			switch string(tag) { // quirks mode case
-			case "base", "bgsound", "command", "link", "meta",
-				"area", "br", "embed", "img", "keygen", "wbr",
-				"param", "source", "track", "hr", "input", "image":
+			if voidElements[string(tag)] && string(tag) != "br" { // quirks mode case
 				return dataStateHandlerSwitch(p), nil
-			case "address", "article", "aside", "blockquote", "button",
-				"center", "details", "dir", "div", "dl", "fieldset",
-				"figcaption", "figure", "footer", "header", "hgroup",
-				"listing", "menu", "nav", "ol", "pre", "section", "summary",
-				"ul", "td", "th", "font", "body":
-				// generate implied end tags
-				genImpliedEndTags(p)
-				// reset the current node
-				n = p.curr
 			}
-			if string(n.data) != string(tag) {
-				return nil, newEndTagError("NotSameTag", n, tag)
+			if err := emitEndTag(p, tag); err != nil {
+				return nil, err
 			}
-			//fmt.Println("YYY: closing a tag")
-			popNode(p)
 			return dataStateHandlerSwitch(p), nil
 		case 'A' <= c && c <= 'Z':
 			lc := unicode.ToLower(c)
 			tag = append(tag, lc)
-		case 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '_', c == '-':
+		case len(tag) > 0 && (c == '\t' || c == '\n' || c == '\f' || c == ' ' || c == '/'):
+			return makeEndTagAttributesHandler(tag), nil
+		case len(tag) > 0, 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '_', c == '-':
 			tag = append(tag, c)
 		default: // Bogus Comment state
-			tag = append(tag, c)
-			return bogusCommentHandler, NewParseError(n,
-				"Strange characters in end tag: [%c] switching to BogusCommentState", c)
+			parseError(p, "invalid-first-character-of-tag-name")
+			p.pushBack(c)
+			return bogusCommentHandler, nil
+		}
+	}
+}
+
+// makeEndTagAttributesHandler skips what follows the name of an end tag up
+// to its '>'. An end tag has no attributes.
+func makeEndTagAttributesHandler(tag []rune) stateHandler {
+	return func(p *Parser) (stateHandler, error) {
+		attrs := false
+		for {
+			c, err := p.nextInput()
+			if err != nil {
+				return nil, err
+			}
+			if c != '>' {
+				attrs = attrs || !isSpace(c) && c != '/'
+				continue
+			}
+			if attrs {
+				parseError(p, "end-tag-with-attributes")
+			}
+			if err := emitEndTag(p, tag); err != nil {
+				return nil, err
+			}
+			return dataStateHandlerSwitch(p), nil
 		}
 	}
-	panic("Unreachable")
 }
 
 // Section 11.2.4.44
 func bogusCommentHandler(p *Parser) (stateHandler, error) {
 	n := addSibling(p)
+	n.Type = CommentNode
+	p.tok = n
 	for {
 		c, err := p.nextInput()
 		if err != nil {
@@ -1194,43 +1313,54 @@ func bogusCommentHandler(p *Parser) (stateHandler, error) {
 		}
 		switch c {
 		case '>':
-			return dataStateHandlerSwitch(p), nil
+			return emitHandler, nil
 		default:
 			n.data = append(n.data, c)
 		}
 	}
-	panic("Unreachable")
 }
 
+// emitHandler processes the token read so far before going on.
+func emitHandler(p *Parser) (stateHandler, error) {
+	return dataStateHandlerSwitch(p), nil
+}
+
+// addSibling pushes a node that comes after the current one, or a child if
+// the current node has no parent.
 func addSibling(p *Parser) *Node {
-	//fmt.Printf("adding sibling to: %s\n", p.curr.Data())
+	if p.curr == nil || p.curr.Parent == nil {
+		return pushNode(p)
+	}
 	n := new(Node)
-	p.curr.Parent.Children = append(p.curr.Parent.Children, n)
+	n.Parent = p.curr.Parent
+	n.Parent.Children = append(n.Parent.Children, n)
+	p.open = append(p.open, n)
 	p.curr = n
 	return n
 }
 
+// pushNode adds a node as a child of the current one and pushes it onto the
+// stack of open elements.
 func pushNode(p *Parser) *Node {
 	n := new(Node)
 	if p.Top == nil {
 		p.Top = n
 	}
-	if p.curr == nil {
-		p.curr = n
-	} else {
-		//fmt.Printf("pushing child onto curr node: %s\n", p.curr.Data())
+	if p.curr != nil {
 		n.Parent = p.curr
 		n.Parent.Children = append(n.Parent.Children, n)
-		p.curr = n
 	}
+	p.open = append(p.open, n)
+	p.curr = n
 	return n
 }
 
+// popNode pops the current node off the stack of open elements. The root of
+// the tree stays.
 func popNode(p *Parser) *Node {
-	if p.curr != nil && p.curr.Parent != nil {
-		//fmt.Printf("popping node: %s\n", p.curr.Data())
-		p.curr = p.curr.Parent
-		//fmt.Printf("curr node: %s\n", p.curr.Data())
+	if len(p.open) > 1 {
+		p.open = p.open[:len(p.open)-1]
+		p.curr = p.open[len(p.open)-1]
 	}
 	return p.curr
 }
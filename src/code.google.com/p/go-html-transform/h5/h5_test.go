@@ -1,7 +1,11 @@
 package h5
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 	//	"os"
 	"reflect"
@@ -134,13 +138,15 @@ func TestEndTagOpenHandlerWrong(t *testing.T) {
 }
 
 func TestEndTagOpenHandlerBogusComment(t *testing.T) {
-	p := NewParserFromString("f\no>")
+	p := NewParserFromString(" fo>")
 	curr := pushNode(p)
 	curr.data = []rune("foo")
 	assertTrue(t, p.curr != nil, "curr is not nil")
 	st, err := endTagOpenHandler(p)
 	assertTrue(t, st != nil, "next state handler is not nil")
-	assertTrue(t, err != nil, "err is nil")
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, len(p.Errors), 1)
+	assertEqual(t, p.Errors[0].Code, "invalid-first-character-of-tag-name")
 	assertEqual(t, p.curr, curr)
 }
 
@@ -450,6 +456,504 @@ func TestTitleTag(t *testing.T) {
 		"<html><head><title></title></head><body></body></html>")
 }
 
+func TestCharRefs(t *testing.T) {
+	cases := map[string]string{
+		"&#36;1.25":           "$1.25",
+		"&#x24;1.25":          "$1.25",
+		"&#X24;":              "$",
+		"a &amp; b":           "a & b",
+		"a&nbsp;b":            "a\u00a0b",
+		"&nbspb":              "\u00a0b",
+		"&notin;":             "\u2209",
+		"&notit;":             "\u00acit;",
+		"&NotEqualTilde;":     "\u2242\u0338",
+		"&#128;":              "\u20ac",
+		"&#0;":                "\ufffd",
+		"&#x110000;":          "\ufffd",
+		"&#;":                 "&#;",
+		"&#x;":                "&#x;",
+		"& b":                 "& b",
+		"&bogus;":             "&bogus;",
+		"&amp":                "&",
+		"AT&T":                "AT&T",
+		"&#36&#x24":           "$$",
+		"&lt;b&gt; not a tag": "<b> not a tag",
+	}
+	for in, out := range cases {
+		p := NewParserFromString(in)
+		err := p.Parse()
+		assertTrue(t, err == nil, "%q: err is not nil: %v", in, err)
+		assertTrue(t, p.Top != nil, "%q: no tree", in)
+		if p.Top != nil {
+			assertTrue(t, p.Top.Data() == out, "%q: got %q want %q",
+				in, p.Top.Data(), out)
+		}
+	}
+}
+
+func TestCharRefsInElements(t *testing.T) {
+	p := NewParserFromString(
+		"<td class=avg>&#36;10.25</td><title>Tom &amp; Jerry</title>")
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, p.Top.Children[0].Data(), "$10.25")
+}
+
+func TestAttrCharRefs(t *testing.T) {
+	cases := map[string]string{
+		`<a href="?a=1&amp;b=2">`:       "?a=1&b=2",
+		`<a href="?a=1&copy=2">`:        "?a=1&copy=2",
+		`<a href="?a=1&copy;=2">`:       "?a=1\u00a9=2",
+		`<a href='&copy'>`:              "\u00a9",
+		`<a href=&#36;1&amp;x>`:         "$1&x",
+		`<a href="&#x22;quoted&quot;">`: `"quoted"`,
+	}
+	for in, out := range cases {
+		p := NewParserFromString(in)
+		err := p.Parse()
+		assertTrue(t, err == nil, "%q: err is not nil: %v", in, err)
+		assertEqual(t, len(p.Top.Attr), 1)
+		if len(p.Top.Attr) == 1 {
+			assertTrue(t, p.Top.Attr[0].Value == out, "%q: got %q want %q",
+				in, p.Top.Attr[0].Value, out)
+		}
+	}
+}
+
+func TestRcDataCharRefs(t *testing.T) {
+	p := NewParserFromString("<textarea>a &lt; b</textarea>")
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, p.Top.Data(), "textarea")
+	assertEqual(t, p.Top.Children[0].Data(), "a < b")
+}
+
+func TestNodeStringEscapes(t *testing.T) {
+	p := NewParserFromString(
+		`<p title="a &quot;b&quot; &amp; c">1 &lt; 2 &amp;&amp; 3 &gt; 2&nbsp;</p>`)
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, p.Top.Children[0].Data(), "1 < 2 && 3 > 2\u00a0")
+	assertEqual(t, p.Top.String(),
+		`<p title="a &quot;b&quot; &amp; c">1 &lt; 2 &amp;&amp; 3 &gt; 2&nbsp;</p>`)
+}
+
+func TestNodeStringSingleQuotes(t *testing.T) {
+	p := NewParserFromString(`<a href='it&#39;s "here"'></a>`)
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, p.Top.String(), `<a href='it&#39;s "here"'></a>`)
+}
+
+func TestNodeStringRawText(t *testing.T) {
+	p := NewParserFromString(
+		"<script> if (a < b && c) { x = '</p>' }</script>")
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, p.Top.String(),
+		"<script> if (a < b && c) { x = '</p>' }</script>")
+	// text added by hand is escaped anywhere else
+	div := &Node{Type: ElementNode, data: []rune("div")}
+	div.Children = []*Node{Text("<script>alert(1)</script>")}
+	assertEqual(t, div.String(),
+		"<div>&lt;script&gt;alert(1)&lt;/script&gt;</div>")
+}
+
+func TestNodeStringVoidElements(t *testing.T) {
+	br := &Node{Type: ElementNode, data: []rune("br")}
+	br.Children = []*Node{Text("ignored")}
+	assertEqual(t, br.String(), "<br>")
+	p := NewParserFromString(`<div><img src="a.png"><br/>x<wbr></div>`)
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, p.Top.String(), `<div><img src="a.png"><br>x<wbr></div>`)
+}
+
+func TestRender(t *testing.T) {
+	doc := "<html><head><title>a &amp; b</title></head>" +
+		"<body><div id=\"foo\"><p>x<br>y</p></div></body></html>"
+	p := NewParserFromString(doc)
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	var b bytes.Buffer
+	err = p.Top.Render(&b)
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, b.String(), doc)
+	assertEqual(t, p.Top.String(), doc)
+}
+
+func TestRenderIndent(t *testing.T) {
+	p := NewParserFromString("<html><body><ul>\n <li>a</li><li>b <i>c</i> </li></ul>" +
+		"<pre><b>x</b>\n  y</pre></body></html>")
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	var b bytes.Buffer
+	err = p.Top.RenderIndent(&b, "  ")
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, b.String(), `<html>
+  <body>
+    <ul>
+      <li>a</li>
+      <li>
+        b
+        <i>c</i>
+      </li>
+    </ul>
+    <pre><b>x</b>
+  y</pre>
+  </body>
+</html>`)
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestRenderError(t *testing.T) {
+	p := NewParserFromString("<div>foo</div>")
+	p.Parse()
+	err := p.Top.Render(errWriter{})
+	assertTrue(t, err != nil, "err is nil")
+}
+
+func TestTreeConstruction(t *testing.T) {
+	cases := map[string]string{
+		// implied tbody
+		"<table><tr><td>a</td></tr></table>": "<table><tbody><tr><td>a</td></tr></tbody></table>",
+		// foster parenting
+		"<div><table>foo<tr><td>a</td></tr></table></div>": "<div>foo<table><tbody><tr><td>a</td></tr></tbody></table></div>",
+		// adoption agency
+		"<div><b><i>x</b>y</i></div>":  "<div><b><i>x</i></b><i>y</i></div>",
+		"<div><b>1<p>2</b>3</p></div>": "<div><b>1</b><p><b>2</b>3</p></div>",
+		// reconstructing the active formatting elements
+		"<div><p><b>x</p><p>y</p></div>": "<div><p><b>x</b></p><p><b>y</b></p></div>",
+		// implied end tags
+		"<ul><li>a<li>b</ul>":                            "<ul><li>a</li><li>b</li></ul>",
+		"<div><p>a<div>b</div></div>":                    "<div><p>a</p><div>b</div></div>",
+		"<div><select><option>a<option>b</select></div>": "<div><select><option>a</option><option>b</option></select></div>",
+		"<pre>\nfoo</pre>":                               "<pre>foo</pre>",
+		// implied end tags stop at the root of a snippet
+		"<p><form><p></form>": "<p><form></form><p></p></p>",
+	}
+	for in, out := range cases {
+		p := NewParserFromString(in)
+		err := p.Parse()
+		assertTrue(t, err == nil, "%q: err is not nil: %v", in, err)
+		assertTrue(t, p.Top.String() == out, "%q: got %q want %q",
+			in, p.Top.String(), out)
+	}
+}
+
+func TestDocumentMode(t *testing.T) {
+	p := NewParserFromString("<title>t</title>x<!-- c -->")
+	p.Document = true
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, p.Top.Type, DocumentNode)
+	assertEqual(t, p.Top.String(),
+		"<html><head><title>t</title></head><body>x<!-- c --></body></html>")
+}
+
+func TestStrayEndTag(t *testing.T) {
+	p := NewParserFromString("<div></span></div>")
+	err := p.Parse()
+	assertTrue(t, err != nil, "err is nil")
+	perr, ok := err.(*ParseError)
+	assertTrue(t, ok, "err is not a *ParseError: %v", err)
+	if ok {
+		assertEqual(t, perr.Code, "unexpected-end-tag")
+		assertEqual(t, perr.Pos, Position{Offset: 5, Line: 1, Column: 6})
+		assertEqual(t, perr.Context, "<div></span>")
+		assertEqual(t, perr.Error(),
+			`1:6: unexpected-end-tag: </span> near "<div></span>"`)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	p := NewParserFromString("<div></span>a<b></i></div>")
+	p.Recover = true
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, p.Top.String(), "<div>a<b></b></div>")
+	var codes []string
+	for _, e := range p.Errors {
+		codes = append(codes, e.Code)
+	}
+	assertEqual(t, codes, []string{"unexpected-end-tag", "unexpected-end-tag"})
+}
+
+func TestSolidusInTag(t *testing.T) {
+	// html5lib tests2.dat:695, tests26.dat:197 and webkit01.dat:162. The
+	// character after a stray '/' in a tag starts the next attribute.
+	cases := map[string]string{
+		"<!DOCTYPE html>X<p/x/y/z>": "<!DOCTYPE html><html><head></head><body>" +
+			`X<p x="" y="" z=""></p></body></html>`,
+		"<p><code x</code></p>\n": "<html><head></head><body>" +
+			`<p><code x<="" code=""></code></p><code x<="" code="">` + "\n</code></body></html>",
+		"<rdar://problem/6869687>": "<html><head></head><body>" +
+			`<rdar: problem="" 6869687=""></rdar:></body></html>`,
+		`<p a="1"b="2">`: "<html><head></head><body>" +
+			`<p a="1" b="2"></p></body></html>`,
+	}
+	for in, out := range cases {
+		p := NewParserFromString(in)
+		p.Document = true
+		p.Recover = true
+		err := p.Parse()
+		assertTrue(t, err == nil, "%q: err is not nil: %v", in, err)
+		assertTrue(t, p.Top.String() == out, "%q: got %q want %q",
+			in, p.Top.String(), out)
+	}
+}
+
+func TestForeignContent(t *testing.T) {
+	cases := map[string]string{
+		`<svg viewbox="0 0 1 1"><foreignobject><p>a</p></foreignobject><path/></svg>`: "<html><head></head><body>" +
+			`<svg viewBox="0 0 1 1"><foreignObject><p>a</p></foreignObject><path></path></svg></body></html>`,
+		// an html element ends the svg element it is in
+		"<svg><title>t</title><g><div>d</div>": "<html><head></head><body>" +
+			"<svg><title>t</title><g></g></svg><div>d</div></body></html>",
+		`<math><mi>x</mi><a xlink:href="y"/></math>`: "<html><head></head><body>" +
+			`<math><mi>x</mi><a xlink:href="y"></a></math></body></html>`,
+		"<svg><![CDATA[a<b]]></svg>": "<html><head></head><body>" +
+			"<svg>a&lt;b</svg></body></html>",
+		"<svg><image/><script>1<2</script></svg>": "<html><head></head><body>" +
+			"<svg><image></image><script>1&lt;2</script></svg></body></html>",
+	}
+	for in, out := range cases {
+		p := NewParserFromString(in)
+		p.Document = true
+		p.Recover = true
+		err := p.Parse()
+		assertTrue(t, err == nil, "%q: err is not nil: %v", in, err)
+		assertTrue(t, p.Top.String() == out, "%q: got %q want %q",
+			in, p.Top.String(), out)
+	}
+
+	p := NewParserFromString(`<p><svg><a xlink:href="x">a</a></svg></p>`)
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	svg := p.Top.Children[0]
+	assertEqual(t, svg.Namespace, "svg")
+	a := svg.Children[0]
+	assertEqual(t, a.Namespace, "svg")
+	assertEqual(t, *a.Attr[0], Attribute{Name: "href", Value: "x",
+		Namespace: "xlink", quote: '"'})
+}
+
+func TestDoctypeIdentifiers(t *testing.T) {
+	p := NewParserFromString(`<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN"` +
+		` "http://www.w3.org/TR/html4/strict.dtd">`)
+	p.Document = true
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	doctype := p.Top.Children[0]
+	assertTrue(t, doctype.Public && doctype.System, "not a PUBLIC doctype with a system identifier")
+	assertEqual(t, string(doctype.Identifier), "-//W3C//DTD HTML 4.01//EN")
+	assertEqual(t, string(doctype.SystemIdentifier), "http://www.w3.org/TR/html4/strict.dtd")
+
+	// a p element is only closed by a table in standards mode
+	cases := map[string]bool{
+		"<!DOCTYPE html>": false,
+		`<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN">`:                               true,
+		`<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/">`: false,
+		`<!DOCTYPE html PUBLIC "-//IETF//DTD HTML 2.0//EN">`:                                            true,
+		`<!DOCTYPE html SYSTEM "http://www.ibm.com/data/dtd/v11/ibmxhtml1-transitional.dtd">`:           true,
+		"<!DOCTYPE html PUBLIC>": true,
+		"<!DOCTYPE svg>":         true,
+	}
+	for doctype, quirks := range cases {
+		p := NewParserFromString(doctype + "<p><table></table>")
+		p.Document = true
+		err := p.Parse()
+		assertTrue(t, err == nil, "%q: err is not nil: %v", doctype, err)
+		body := p.Top.Children[1].Children[1]
+		assertEqual(t, len(body.Children) == 1, quirks)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	p := NewParserFromString("<p a=1>x &amp y\n<!-- c --!></p>")
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	var codes []string
+	for _, e := range p.Errors {
+		codes = append(codes, fmt.Sprintf("%d:%d %s", e.Pos.Line, e.Pos.Column, e.Code))
+	}
+	assertEqual(t, codes, []string{
+		"1:13 missing-semicolon-after-character-reference",
+		"2:11 incorrectly-closed-comment",
+	})
+	assertEqual(t, p.Top.Children[0].Data(), "x & y\n")
+}
+
+func TestNodePositions(t *testing.T) {
+	p := NewParserFromString("<ul>\n  <li class=a>one\n  <li>two</ul>")
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	var got []string
+	p.Top.Walk(func(n *Node) {
+		got = append(got, fmt.Sprintf("%q %d:%d@%d",
+			n.Data(), n.Pos.Line, n.Pos.Column, n.Pos.Offset))
+	})
+	assertEqual(t, got, []string{
+		`"ul" 1:1@0`,
+		`"\n  " 1:5@4`,
+		`"li" 2:3@7`,
+		`"one\n  " 2:15@19`,
+		`"li" 3:3@25`,
+		`"two" 3:7@29`,
+	})
+}
+
+func TestTokenizer(t *testing.T) {
+	z := NewTokenizerFromString(
+		"<!DOCTYPE html>\n<td class=avg>1 &amp; 2</td><!-- c --><br/>a < b")
+	want := []Token{
+		{Type: DoctypeToken, Data: "html",
+			Pos: Position{Offset: 0, Line: 1, Column: 1}},
+		{Type: TextToken, Data: "\n",
+			Pos: Position{Offset: 15, Line: 1, Column: 16}},
+		{Type: StartTagToken, Data: "td",
+			Attr: []*Attribute{{Name: "class", Value: "avg"}},
+			Pos:  Position{Offset: 16, Line: 2, Column: 1}},
+		{Type: TextToken, Data: "1 & 2",
+			Pos: Position{Offset: 30, Line: 2, Column: 15}},
+		{Type: EndTagToken, Data: "td",
+			Pos: Position{Offset: 39, Line: 2, Column: 24}},
+		{Type: CommentToken, Data: " c ",
+			Pos: Position{Offset: 44, Line: 2, Column: 29}},
+		{Type: StartTagToken, Data: "br", SelfClosing: true,
+			Pos: Position{Offset: 54, Line: 2, Column: 39}},
+		{Type: TextToken, Data: "a < b",
+			Pos: Position{Offset: 59, Line: 2, Column: 44}},
+	}
+	for i := range want {
+		tok, err := z.Next()
+		assertTrue(t, err == nil, "err is not nil: %v", err)
+		if tok == nil {
+			return
+		}
+		assertEqual(t, *tok, want[i])
+	}
+	_, err := z.Next()
+	assertEqual(t, err, io.EOF)
+	_, err = z.Next()
+	assertEqual(t, err, io.EOF)
+}
+
+func TestTokenizerRawText(t *testing.T) {
+	z := NewTokenizerFromString(
+		"<script>if (a<b) { x = '</p>'; }</script ><title>&lt;</title>")
+	var got []string
+	for {
+		tok, err := z.Next()
+		if err != nil {
+			assertEqual(t, err, io.EOF)
+			break
+		}
+		got = append(got, fmt.Sprintf("%s %s", tok.Type, tok.Data))
+	}
+	assertEqual(t, got, []string{
+		"StartTag script",
+		"Text if (a<b) { x = '</p>'; }",
+		"EndTag script",
+		"StartTag title",
+		"Text <",
+		"EndTag title",
+	})
+}
+
+func TestDetermineEncoding(t *testing.T) {
+	for _, c := range []struct {
+		in, contentType string
+		name            string
+		certain         bool
+	}{
+		{"\xef\xbb\xbf<p>", "text/html; charset=iso-8859-2", "utf-8", true},
+		{"\xff\xfe<\x00p\x00", "", "utf-16le", true},
+		{"<p>", "text/html; charset=\"Latin1\"", "windows-1252", true},
+		{"<!-- <meta charset=koi8-r> --><meta charset='iso-8859-15'>", "",
+			"iso-8859-15", true},
+		{`<meta http-equiv="Content-Type" content="text/html; charset=cp1251">`,
+			"", "windows-1251", true},
+		{"<meta charset=utf-16>", "", "utf-8", true},
+		{"<p>caf\xc3\xa9", "text/html", "utf-8", false},
+		{"<p>caf\xe9", "", "windows-1252", false},
+	} {
+		name, certain := DetermineEncoding([]byte(c.in), c.contentType)
+		assertTrue(t, name == c.name && certain == c.certain,
+			"%q %q: got %s %v want %s %v",
+			c.in, c.contentType, name, certain, c.name, c.certain)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	out, name := Decode([]byte("<p>caf\xe9 \x80 \x81"), "")
+	assertEqual(t, name, "windows-1252")
+	assertEqual(t, string(out), "<p>caf\u00e9 \u20ac \u0081")
+	out, name = Decode([]byte("\xfe\xff\x00<\x00p\x00>\xd8\x3d\xde\x00"), "")
+	assertEqual(t, name, "utf-16be")
+	assertEqual(t, string(out), "<p>\U0001f600")
+	out, name = Decode([]byte("<p>\xb5"), "text/html; charset=iso-8859-2")
+	assertEqual(t, name, "iso-8859-2")
+	assertEqual(t, string(out), "<p>\u013e")
+	// the whole source is checked for utf-8, not just the part sniffed for
+	// a <meta>
+	in := strings.Repeat("<p>Jace</p>", 200) + "<p>Jace \u2014 Architect"
+	out, name = Decode([]byte(in), "")
+	assertEqual(t, name, "utf-8")
+	assertEqual(t, string(out), in)
+}
+
+func TestParseEncoding(t *testing.T) {
+	// long enough to be decoded in more than one read
+	in := "<meta charset=windows-1251><p>" + strings.Repeat("\xe4", 5000)
+	p := NewParserFromString(in)
+	p.DetectEncoding = true
+	err := p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, p.Encoding, "windows-1251")
+	assertEqual(t, p.Top.Children[0].Children[0].Data(),
+		strings.Repeat("\u0434", 5000))
+
+	p = NewParserFromString("<p>caf\xe9</p>")
+	p.ContentType = "text/html; charset=iso-8859-15"
+	err = p.Parse()
+	assertTrue(t, err == nil, "err is not nil: %v", err)
+	assertEqual(t, p.Encoding, "iso-8859-15")
+	assertEqual(t, p.Top.String(), "<p>caf\u00e9</p>")
+}
+
+func TestFragment(t *testing.T) {
+	form := Element("form")
+	div := Element("div")
+	div.Parent = form
+	for _, c := range []struct {
+		context *Node
+		in, out string
+	}{
+		{Element("tr"), "<td>x<td>y", "<td>x</td><td>y</td>"},
+		{Element("select"), "<option>a<p>b</option>", "<option>ab</option>"},
+		{Element("textarea"), "<b>&amp;</textarea>", "&lt;b&gt;&amp;&lt;/textarea&gt;"},
+		{div, "<form><input></form>x", "<input>x"},
+	} {
+		p := NewParserFromString(c.in)
+		p.Context = c.context
+		err := p.Parse()
+		assertTrue(t, err == nil, "%q: err is not nil: %v", c.in, err)
+		var out []string
+		for _, n := range p.Fragment() {
+			assertTrue(t, n.Parent == nil, "%q: %s has a parent", c.in, n)
+			out = append(out, n.String())
+		}
+		assertTrue(t, strings.Join(out, "") == c.out, "%q: got %q want %q",
+			c.in, strings.Join(out, ""), c.out)
+	}
+}
+
 // TODO micro benchmarks
 func BenchmarkDocParse(t *testing.B) {
 	for i := 0; i < t.N; i++ {
@@ -467,3 +971,24 @@ func BenchmarkDocParse(t *testing.B) {
 			" if (foo < 10) { }")
 	}
 }
+
+func bigDoc() string {
+	var b strings.Builder
+	b.WriteString("<html><body><table>")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&b, "<tr class=\"row\"><td><a href=\"/card?id=%d&amp;set=rtr\">Card %d</a></td>"+
+			"<td class=\"avg\">&#36;%d.25</td></tr>", i, i, i)
+	}
+	b.WriteString("</table></body></html>")
+	return b.String()
+}
+
+func BenchmarkRender(b *testing.B) {
+	p := NewParserFromString(bigDoc())
+	p.Parse()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		p.Top.Render(&buf)
+	}
+}
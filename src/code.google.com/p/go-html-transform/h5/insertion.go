@@ -0,0 +1,2132 @@
+package h5
+
+// The html5 tree construction stage. The tokenizer hands each token it reads
+// to process, which runs the rules of the current insertion mode. See
+// http://www.whatwg.org/specs/web-apps/current-work/multipage/tree-construction.html
+//
+// Unless the Parser is building a whole Document the html, head and body
+// elements are not made up when the source leaves them out. The first node of
+// the source is then the root of the tree and stands in for the html element.
+
+import (
+	"strings"
+)
+
+// A token as the tree construction stage sees it.
+type token struct {
+	kind        TokenType
+	name        string // the tag name of start and end tags
+	node        *Node  // the element, comment or doctype node
+	text        []rune
+	selfClosing bool
+	pos         Position // where the token starts in the source
+}
+
+// The tokenizer states the tree construction stage can switch to for the
+// contents of an element.
+type textState int
+
+const (
+	dataState textState = iota
+	rcDataState
+	rawTextState
+	scriptDataState
+	plainTextState
+)
+
+// process runs the token through the rules of the insertion mode, and those
+// of any mode it is handed on to. A token handed back to the mode it is
+// already in is dropped, as that only happens when the element it would
+// close is the root of a snippet. Tokens in svg and mathml elements follow
+// the rules for foreign content instead, unless those hand them back.
+func process(p *Parser, t *token) {
+	p.t = t
+	for {
+		mode := p.Mode
+		if inForeignContent(p, t) && foreignContentIM(p, t) {
+			return
+		}
+		if modeHandler(mode)(p, t) || p.Mode == mode {
+			return
+		}
+	}
+}
+
+// modeHandler returns the rules of an insertion mode. They return false if
+// the token has to be processed again in the mode they switched to.
+func modeHandler(m insertionMode) func(*Parser, *token) bool {
+	switch m {
+	case im_initial:
+		return initialIM
+	case im_beforeHtml:
+		return beforeHtmlIM
+	case im_beforeHead:
+		return beforeHeadIM
+	case im_inHead, im_inHeadNoScript:
+		return inHeadIM
+	case im_afterHead:
+		return afterHeadIM
+	case im_text:
+		return textIM
+	case im_inTable:
+		return inTableIM
+	case im_inTableText:
+		return inTableTextIM
+	case im_inCaption:
+		return inCaptionIM
+	case im_inColumnGroup:
+		return inColumnGroupIM
+	case im_inTableBody:
+		return inTableBodyIM
+	case im_inRow:
+		return inRowIM
+	case im_inCell:
+		return inCellIM
+	case im_inSelect:
+		return inSelectIM
+	case im_inSelectInTable:
+		return inSelectInTableIM
+	case im_afterBody:
+		return afterBodyIM
+	case im_inFrameset:
+		return inFramesetIM
+	case im_afterFrameset:
+		return afterFramesetIM
+	case im_afterAfterBody:
+		return afterAfterBodyIM
+	case im_afterAfterFrameset:
+		return afterAfterFramesetIM
+	}
+	return inBodyIM
+}
+
+// The elements of the special category.
+var specialElements = map[string]bool{
+	"address": true, "applet": true, "area": true, "article": true,
+	"aside": true, "base": true, "basefont": true, "bgsound": true,
+	"blockquote": true, "body": true, "br": true, "button": true,
+	"caption": true, "center": true, "col": true, "colgroup": true,
+	"command": true, "dd": true, "details": true, "dir": true, "div": true,
+	"dl": true, "dt": true, "embed": true, "fieldset": true,
+	"figcaption": true, "figure": true, "footer": true, "form": true,
+	"frame": true, "frameset": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "head": true, "header": true,
+	"hgroup": true, "hr": true, "html": true, "iframe": true, "img": true,
+	"input": true, "isindex": true, "li": true, "link": true,
+	"listing": true, "main": true, "marquee": true, "menu": true,
+	"meta": true, "nav": true, "noembed": true, "noframes": true,
+	"noscript": true, "object": true, "ol": true, "p": true, "param": true,
+	"plaintext": true, "pre": true, "script": true, "section": true,
+	"select": true, "source": true, "style": true, "summary": true,
+	"table": true, "tbody": true, "td": true, "textarea": true,
+	"tfoot": true, "th": true, "thead": true, "title": true, "tr": true,
+	"track": true, "ul": true, "wbr": true, "xmp": true,
+	"math mi": true, "math mo": true, "math mn": true, "math ms": true,
+	"math mtext": true, "math annotation-xml": true,
+	"svg foreignObject": true, "svg desc": true, "svg title": true,
+}
+
+// The elements that end up in the list of active formatting elements.
+var formattingElements = map[string]bool{
+	"a": true, "b": true, "big": true, "code": true, "em": true,
+	"font": true, "i": true, "nobr": true, "s": true, "small": true,
+	"strike": true, "strong": true, "tt": true, "u": true,
+}
+
+// Start tags that close an open p element.
+var closesP = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"center": true, "details": true, "dialog": true, "dir": true,
+	"div": true, "dl": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "header": true, "hgroup": true,
+	"main": true, "menu": true, "nav": true, "ol": true, "p": true,
+	"section": true, "summary": true, "ul": true,
+}
+
+// The parts of a table that are only allowed in one.
+var tableParts = map[string]bool{
+	"caption": true, "col": true, "colgroup": true, "tbody": true,
+	"td": true, "tfoot": true, "th": true, "thead": true, "tr": true,
+}
+
+func isSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// splitSpace splits text into its leading whitespace and the rest.
+func splitSpace(text []rune) ([]rune, []rune) {
+	i := 0
+	for i < len(text) && isSpace(text[i]) {
+		i++
+	}
+	return text[:i], text[i:]
+}
+
+// skipSpace takes the leading whitespace off a text token. It returns the
+// whitespace and where it starts.
+func skipSpace(t *token) ([]rune, Position) {
+	pos := t.pos
+	space, rest := splitSpace(t.text)
+	t.text = rest
+	for _, c := range space {
+		t.pos.Offset++
+		t.pos.Column++
+		if c == '\n' {
+			t.pos.Line++
+			t.pos.Column = 1
+		}
+	}
+	return space, pos
+}
+
+func isHeading(name string) bool {
+	return len(name) == 2 && name[0] == 'h' && '1' <= name[1] && name[1] <= '6'
+}
+
+func attrValue(n *Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func newElement(name string) *Node {
+	return &Node{Type: ElementNode, data: []rune(name)}
+}
+
+// elementName is the name of n as the scopes and specialElements know it:
+// the namespace and name of a foreign element, like "svg desc", and the name
+// of an html one.
+func elementName(n *Node) string {
+	if n.Namespace != "" {
+		return n.Namespace + " " + n.Data()
+	}
+	return n.Data()
+}
+
+// shallowClone copies an element without its children, as the active
+// formatting elements get reopened.
+func shallowClone(n *Node) *Node {
+	clone := &Node{Type: n.Type, data: make([]rune, len(n.data)), Namespace: n.Namespace}
+	copy(clone.data, n.data)
+	for _, a := range n.Attr {
+		c := a.Clone()
+		c.quote = a.quote
+		clone.Attr = append(clone.Attr, c)
+	}
+	return clone
+}
+
+func removeChild(parent, n *Node) {
+	for i, c := range parent.Children {
+		if c == n {
+			parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+			break
+		}
+	}
+	n.Parent = nil
+}
+
+// insertChild adds n to the children of parent before ref, or at the end if
+// ref is nil.
+func insertChild(parent, n, ref *Node) {
+	if n.Parent != nil {
+		removeChild(n.Parent, n)
+	}
+	n.Parent = parent
+	for i, c := range parent.Children {
+		if c == ref {
+			parent.Children = append(parent.Children, nil)
+			copy(parent.Children[i+1:], parent.Children[i:])
+			parent.Children[i] = n
+			return
+		}
+	}
+	parent.Children = append(parent.Children, n)
+}
+
+// detach takes the node of a token the tokenizer was filling in back off the
+// stack of open elements and out of the tree.
+func detach(p *Parser, n *Node) {
+	if k := len(p.open); k > 0 && p.open[k-1] == n {
+		p.open = p.open[:k-1]
+		p.curr = nil
+		if k > 1 {
+			p.curr = p.open[k-2]
+		}
+	}
+	if n.Parent != nil {
+		removeChild(n.Parent, n)
+	}
+	if p.Top == n {
+		p.Top = nil
+	}
+}
+
+// The stack of open elements
+
+func pushOpen(p *Parser, n *Node) {
+	p.open = append(p.open, n)
+	p.curr = n
+}
+
+// popTo pops elements off the stack until only i are left. The root of the
+// tree is never popped.
+func popTo(p *Parser, i int) {
+	if i < 1 {
+		i = 1
+	}
+	if i < len(p.open) {
+		p.open = p.open[:i]
+		p.curr = p.open[i-1]
+	}
+}
+
+// indexOpen returns where n is in the stack of open elements or -1.
+func indexOpen(p *Parser, n *Node) int {
+	for i := len(p.open) - 1; i >= 0; i-- {
+		if p.open[i] == n {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeOpen(p *Parser, n *Node) {
+	if i := indexOpen(p, n); i > 0 {
+		p.open = append(p.open[:i], p.open[i+1:]...)
+		p.curr = p.open[len(p.open)-1]
+	}
+}
+
+func currentIs(p *Parser, names ...string) bool {
+	return p.curr != nil && p.curr.Type == ElementNode &&
+		p.curr.Namespace == "" && oneOf(p.curr.Data(), names...)
+}
+
+// inScope finds the nearest open element named one of names, stopping at the
+// elements of scope. It returns its index or -1.
+func inScope(p *Parser, scope map[string]bool, names ...string) int {
+	for i := len(p.open) - 1; i >= 0; i-- {
+		n := p.open[i]
+		if n.Type != ElementNode {
+			continue
+		}
+		name := elementName(n)
+		if oneOf(name, names...) {
+			return i
+		}
+		if scope[name] {
+			return -1
+		}
+	}
+	return -1
+}
+
+// inSelectScope is inScope for the select scope, which everything but
+// optgroup and option elements ends.
+func inSelectScope(p *Parser, name string) int {
+	for i := len(p.open) - 1; i >= 0; i-- {
+		n := elementName(p.open[i])
+		if n == name {
+			return i
+		}
+		if n != "optgroup" && n != "option" {
+			return -1
+		}
+	}
+	return -1
+}
+
+// popUntil pops elements until the one found by inScope is gone. It returns
+// false if there was none.
+func popUntil(p *Parser, scope map[string]bool, names ...string) bool {
+	i := inScope(p, scope, names...)
+	if i < 0 {
+		return false
+	}
+	popTo(p, i)
+	return true
+}
+
+// clearStackBack pops elements until the current node is one of names or the
+// root.
+func clearStackBack(p *Parser, names ...string) {
+	for len(p.open) > 1 && !currentIs(p, names...) {
+		popNode(p)
+	}
+}
+
+// genImpliedEndTags closes the elements whose end tags may be left out. The
+// root of the tree is never closed.
+func genImpliedEndTags(p *Parser, except ...string) {
+	for len(p.open) > 1 &&
+		currentIs(p, "dd", "dt", "li", "option", "optgroup", "p", "rp", "rt") &&
+		!oneOf(p.curr.Data(), except...) {
+		popNode(p)
+	}
+}
+
+// closePElement closes the p element in button scope, if there is one.
+func closePElement(p *Parser) {
+	if inScope(p, buttonScope, "p") >= 0 {
+		genImpliedEndTags(p, "p")
+		popUntil(p, buttonScope, "p")
+	}
+}
+
+// Inserting nodes
+
+// insertionPlace returns where a node goes in the tree: as a child of parent
+// before ref, or after its last child if ref is nil. Nodes are foster
+// parented in front of a table they turned up in.
+func insertionPlace(p *Parser, target *Node) (parent, ref *Node) {
+	if target == nil {
+		target = p.curr
+	}
+	if !p.foster || target == nil || target.Type != ElementNode ||
+		!oneOf(elementName(target), "table", "tbody", "tfoot", "thead", "tr") {
+		return target, nil
+	}
+	for i := len(p.open) - 1; i >= 0; i-- {
+		if n := p.open[i]; elementName(n) == "table" {
+			switch {
+			case n.Parent != nil:
+				return n.Parent, n
+			case i > 0:
+				return p.open[i-1], nil
+			}
+			return n, nil
+		}
+	}
+	return p.open[0], nil
+}
+
+// insertElement adds n to the tree and pushes it onto the stack.
+func insertElement(p *Parser, n *Node) *Node {
+	parent, ref := insertionPlace(p, nil)
+	switch {
+	case parent != nil:
+		insertChild(parent, n, ref)
+	case p.Document:
+		insertChild(p.Top, n, nil)
+	default:
+		p.Top = n
+	}
+	pushOpen(p, n)
+	return n
+}
+
+// insertText adds the text found at pos to the tree, appending it to a text
+// node just before.
+func insertText(p *Parser, text []rune, pos Position) {
+	if len(text) == 0 {
+		return
+	}
+	parent, ref := insertionPlace(p, nil)
+	if parent == nil {
+		if p.Document {
+			return
+		}
+		// the first node of a snippet
+		insertElement(p, &Node{data: append([]rune(nil), text...), Pos: pos})
+		return
+	}
+	prev := len(parent.Children) - 1
+	if ref != nil {
+		for i, c := range parent.Children {
+			if c == ref {
+				prev = i - 1
+			}
+		}
+	}
+	if prev >= 0 && parent.Children[prev].Type == TextNode {
+		last := parent.Children[prev]
+		last.data = append(last.data, text...)
+		return
+	}
+	insertChild(parent, &Node{data: append([]rune(nil), text...), Pos: pos}, ref)
+}
+
+// insertComment adds a comment as the last child of parent, or where nodes
+// go if parent is nil.
+func insertComment(p *Parser, n *Node, parent *Node) {
+	if parent == nil {
+		parent = p.curr
+	}
+	if parent == nil {
+		if p.Document {
+			parent = p.Top
+		} else {
+			// the first node of a snippet
+			insertElement(p, n)
+			return
+		}
+	}
+	insertChild(parent, n, nil)
+}
+
+// documentNode is where comments after the html element go.
+func documentNode(p *Parser) *Node {
+	if p.Document {
+		return p.Top
+	}
+	return p.open[0]
+}
+
+// insertRawText inserts an element whose contents the tokenizer reads in the
+// state s, until its end tag.
+func insertRawText(p *Parser, t *token, s textState) {
+	insertElement(p, t.node)
+	p.state = s
+	p.rawTag = t.name
+	p.originalMode = p.Mode
+	p.Mode = im_text
+}
+
+// The list of active formatting elements
+
+func pushFormatting(p *Parser, n *Node) {
+	// at most three alike after the last marker
+	count, first := 0, -1
+	for i := len(p.afe) - 1; i >= 0 && p.afe[i] != nil; i-- {
+		if e := p.afe[i]; e.Data() == n.Data() && sameAttributes(e, n) {
+			count++
+			first = i
+		}
+	}
+	if count >= 3 {
+		p.afe = append(p.afe[:first], p.afe[first+1:]...)
+	}
+	p.afe = append(p.afe, n)
+}
+
+func sameAttributes(a, b *Node) bool {
+	if len(a.Attr) != len(b.Attr) {
+		return false
+	}
+	for _, attr := range a.Attr {
+		if v, ok := attrValue(b, attr.Name); !ok || v != attr.Value {
+			return false
+		}
+	}
+	return true
+}
+
+func indexFormatting(p *Parser, n *Node) int {
+	for i := len(p.afe) - 1; i >= 0; i-- {
+		if p.afe[i] == n {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeFormatting(p *Parser, n *Node) {
+	if i := indexFormatting(p, n); i >= 0 {
+		p.afe = append(p.afe[:i], p.afe[i+1:]...)
+	}
+}
+
+// clearFormattingToMarker removes the entries after the last marker and the
+// marker.
+func clearFormattingToMarker(p *Parser) {
+	for len(p.afe) > 0 {
+		n := p.afe[len(p.afe)-1]
+		p.afe = p.afe[:len(p.afe)-1]
+		if n == nil {
+			return
+		}
+	}
+}
+
+// reconstructFormatting reopens the formatting elements closed by a block
+// that was in them, so that text after the block is formatted alike.
+func reconstructFormatting(p *Parser) {
+	if len(p.afe) == 0 {
+		return
+	}
+	i := len(p.afe) - 1
+	if n := p.afe[i]; n == nil || indexOpen(p, n) >= 0 {
+		return
+	}
+	for i > 0 {
+		if n := p.afe[i-1]; n == nil || indexOpen(p, n) >= 0 {
+			break
+		}
+		i--
+	}
+	for ; i < len(p.afe); i++ {
+		clone := shallowClone(p.afe[i])
+		insertElement(p, clone)
+		p.afe[i] = clone
+	}
+}
+
+// Section 11.2.5.4.7
+// adoptionAgency closes the formatting element name, reopening it inside
+// the blocks that were opened in it. It returns false if there is no such
+// formatting element and the end tag is to be handled like any other.
+func adoptionAgency(p *Parser, name string) bool {
+	if currentIs(p, name) && indexFormatting(p, p.curr) < 0 {
+		popNode(p)
+		return true
+	}
+	for outer := 0; outer < 8; outer++ {
+		var formatting *Node
+		for i := len(p.afe) - 1; i >= 0 && p.afe[i] != nil; i-- {
+			if p.afe[i].Data() == name {
+				formatting = p.afe[i]
+				break
+			}
+		}
+		if formatting == nil {
+			return false
+		}
+		fi := indexOpen(p, formatting)
+		if fi < 0 {
+			treeError(p, "adoption-agency-1.2")
+			removeFormatting(p, formatting)
+			return true
+		}
+		if inScope(p, baseScope, name) < 0 {
+			treeError(p, "adoption-agency-1.3")
+			return true
+		}
+		var furthest *Node
+		for _, n := range p.open[fi+1:] {
+			if specialElements[elementName(n)] {
+				furthest = n
+				break
+			}
+		}
+		if furthest == nil || fi == 0 {
+			// nothing to move out of it, or it is the root of a snippet
+			popTo(p, fi)
+			removeFormatting(p, formatting)
+			return true
+		}
+		common := p.open[fi-1]
+		bookmark := indexFormatting(p, formatting)
+		node, last := furthest, furthest
+		x := indexOpen(p, furthest)
+		for inner := 1; ; inner++ {
+			x--
+			node = p.open[x]
+			if node == formatting {
+				break
+			}
+			if ni := indexFormatting(p, node); inner > 3 && ni >= 0 {
+				removeFormatting(p, node)
+				if ni < bookmark {
+					bookmark--
+				}
+			}
+			ni := indexFormatting(p, node)
+			if ni < 0 {
+				removeOpen(p, node)
+				continue
+			}
+			clone := shallowClone(node)
+			p.afe[ni] = clone
+			p.open[x] = clone
+			node = clone
+			if last == furthest {
+				bookmark = ni + 1
+			}
+			insertChild(node, last, nil)
+			last = node
+		}
+		parent, ref := common, (*Node)(nil)
+		if oneOf(common.Data(), "table", "tbody", "tfoot", "thead", "tr") {
+			foster := p.foster
+			p.foster = true
+			parent, ref = insertionPlace(p, common)
+			p.foster = foster
+		}
+		insertChild(parent, last, ref)
+
+		clone := shallowClone(formatting)
+		for _, c := range furthest.Children {
+			c.Parent = clone
+		}
+		clone.Children, furthest.Children = furthest.Children, nil
+		insertChild(furthest, clone, nil)
+
+		if fi := indexFormatting(p, formatting); fi >= 0 && fi < bookmark {
+			bookmark--
+		}
+		removeFormatting(p, formatting)
+		p.afe = append(p.afe, nil)
+		copy(p.afe[bookmark+1:], p.afe[bookmark:])
+		p.afe[bookmark] = clone
+
+		removeOpen(p, formatting)
+		fb := indexOpen(p, furthest) + 1
+		p.open = append(p.open, nil)
+		copy(p.open[fb+1:], p.open[fb:])
+		p.open[fb] = clone
+		p.curr = p.open[len(p.open)-1]
+	}
+	return true
+}
+
+// resetInsertionMode picks the insertion mode from the open elements, as
+// after a table or select element closes. The root of a fragment stands in
+// for its context element.
+func resetInsertionMode(p *Parser) {
+	for i := len(p.open) - 1; i >= 0; i-- {
+		last := i == 0
+		n := p.open[i]
+		if last && p.Context != nil {
+			n = p.Context
+		}
+		switch elementName(n) {
+		case "select":
+			if !last {
+				for j := i - 1; j > 0; j-- {
+					if p.open[j].Data() == "table" {
+						p.Mode = im_inSelectInTable
+						return
+					}
+				}
+			}
+			p.Mode = im_inSelect
+			return
+		case "td", "th":
+			if !last {
+				p.Mode = im_inCell
+				return
+			}
+		case "tr":
+			p.Mode = im_inRow
+			return
+		case "tbody", "thead", "tfoot":
+			p.Mode = im_inTableBody
+			return
+		case "caption":
+			p.Mode = im_inCaption
+			return
+		case "colgroup":
+			p.Mode = im_inColumnGroup
+			return
+		case "table":
+			p.Mode = im_inTable
+			return
+		case "head":
+			if !last {
+				p.Mode = im_inHead
+				return
+			}
+		case "body":
+			p.Mode = im_inBody
+			return
+		case "frameset":
+			p.Mode = im_inFrameset
+			return
+		case "html":
+			if p.head == nil {
+				p.Mode = im_beforeHead
+			} else {
+				p.Mode = im_afterHead
+			}
+			return
+		}
+		if last {
+			p.Mode = im_inBody
+		}
+	}
+}
+
+// startFragment sets the parser up to read the contents of p.Context. They
+// go into an html element of their own, and are read in the tokenizer state
+// and insertion mode the contents of the context element would be.
+func startFragment(p *Parser) {
+	insertElement(p, newElement("html"))
+	if s, ok := textStates[elementName(p.Context)]; ok {
+		// with no start tag read, no end tag closes the text
+		p.state = s
+	}
+	resetInsertionMode(p)
+	for n := p.Context; n != nil; n = n.Parent {
+		if n.Type == ElementNode && n.Data() == "form" {
+			p.form = n
+			break
+		}
+	}
+}
+
+// treeError records a recoverable parse error at the token being processed.
+func treeError(p *Parser, code string) {
+	p.Errors = append(p.Errors, newParseError(p, p.t.pos, code, tokenString(p.t)))
+}
+
+// tokenString represents a tag token as it is in the source.
+func tokenString(t *token) string {
+	switch t.kind {
+	case StartTagToken:
+		return "<" + t.name + ">"
+	case EndTagToken:
+		return "</" + t.name + ">"
+	}
+	return ""
+}
+
+// strayEndTag notes an end tag with no element open to close. It stops the
+// parse unless the parser recovers from it by ignoring the tag.
+func strayEndTag(p *Parser, t *token) bool {
+	if p.Recover {
+		treeError(p, "unexpected-end-tag")
+		return true
+	}
+	if p.err == nil {
+		p.err = newParseError(p, t.pos, "unexpected-end-tag", tokenString(t))
+	}
+	return true
+}
+
+// The insertion modes
+
+// Section 11.2.5.4.1
+func initialIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		skipSpace(t)
+		if len(t.text) == 0 {
+			return true
+		}
+	case CommentToken:
+		insertComment(p, t.node, nil)
+		return true
+	case DoctypeToken:
+		if p.Document {
+			insertChild(p.Top, t.node, nil)
+		} else {
+			insertElement(p, t.node)
+		}
+		p.quirks = p.Document && quirksDoctype(p, t.node)
+		p.Mode = im_beforeHtml
+		return true
+	}
+	// a document without a doctype is rendered in quirks mode
+	if p.Document {
+		treeError(p, "expected-doctype")
+	}
+	p.quirks = p.Document
+	p.Mode = im_beforeHtml
+	return false
+}
+
+// quirksDoctype reports whether a doctype puts the document in quirks mode,
+// as the doctypes of old versions of html do.
+func quirksDoctype(p *Parser, n *Node) bool {
+	if p.forceQuirks || n.Data() != "html" {
+		return true
+	}
+	var public, system string
+	switch {
+	case n.Public:
+		public = strings.ToLower(string(n.Identifier))
+		system = strings.ToLower(string(n.SystemIdentifier))
+	case n.System:
+		system = strings.ToLower(string(n.Identifier))
+	}
+	if oneOf(public, "-//w3o//dtd w3 html strict 3.0//en//",
+		"-/w3c/dtd html 4.0 transitional/en", "html") ||
+		system == "http://www.ibm.com/data/dtd/v11/ibmxhtml1-transitional.dtd" {
+		return true
+	}
+	for _, prefix := range quirksPublicPrefixes {
+		if strings.HasPrefix(public, prefix) {
+			return true
+		}
+	}
+	// without a system identifier html 4.01 is rendered in quirks mode too
+	return !n.System &&
+		(strings.HasPrefix(public, "-//w3c//dtd html 4.01 frameset//") ||
+			strings.HasPrefix(public, "-//w3c//dtd html 4.01 transitional//"))
+}
+
+// Section 11.2.5.4.2
+func beforeHtmlIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case DoctypeToken:
+		return true
+	case CommentToken:
+		insertComment(p, t.node, nil)
+		return true
+	case TextToken:
+		skipSpace(t)
+		if len(t.text) == 0 {
+			return true
+		}
+	case StartTagToken:
+		if t.name == "html" {
+			insertElement(p, t.node)
+			p.Mode = im_beforeHead
+			return true
+		}
+	case EndTagToken:
+		if p.Document && !oneOf(t.name, "head", "body", "html", "br") {
+			return strayEndTag(p, t)
+		}
+	}
+	if !p.Document {
+		if t.kind == StartTagToken && len(p.open) == 0 && tableParts[t.name] {
+			// a snippet of a table is rooted at the part it starts with
+			insertElement(p, t.node)
+			resetInsertionMode(p)
+			return true
+		}
+		p.Mode = im_inBody
+		return false
+	}
+	insertElement(p, newElement("html"))
+	p.Mode = im_beforeHead
+	return false
+}
+
+// Section 11.2.5.4.3
+func beforeHeadIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		skipSpace(t)
+		if len(t.text) == 0 {
+			return true
+		}
+	case CommentToken:
+		insertComment(p, t.node, nil)
+		return true
+	case DoctypeToken:
+		return true
+	case StartTagToken:
+		switch t.name {
+		case "html":
+			return inBodyIM(p, t)
+		case "head":
+			p.head = insertElement(p, t.node)
+			p.Mode = im_inHead
+			return true
+		}
+	case EndTagToken:
+		if !oneOf(t.name, "head", "body", "html", "br") {
+			return strayEndTag(p, t)
+		}
+	}
+	if !p.Document {
+		p.Mode = im_afterHead
+		return false
+	}
+	p.head = insertElement(p, newElement("head"))
+	p.Mode = im_inHead
+	return false
+}
+
+// Section 11.2.5.4.4
+func inHeadIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		space, pos := skipSpace(t)
+		insertText(p, space, pos)
+		if len(t.text) == 0 {
+			return true
+		}
+	case CommentToken:
+		insertComment(p, t.node, nil)
+		return true
+	case DoctypeToken:
+		return true
+	case StartTagToken:
+		switch t.name {
+		case "html":
+			return inBodyIM(p, t)
+		case "base", "basefont", "bgsound", "command", "link", "meta":
+			insertElement(p, t.node)
+			popNode(p)
+			return true
+		case "title":
+			insertRawText(p, t, rcDataState)
+			return true
+		case "noscript", "noframes", "style":
+			insertRawText(p, t, rawTextState)
+			return true
+		case "script":
+			insertRawText(p, t, scriptDataState)
+			return true
+		case "head":
+			treeError(p, "two-heads-are-not-better-than-one")
+			return true
+		}
+	case EndTagToken:
+		switch t.name {
+		case "head":
+			if currentIs(p, "head") {
+				popNode(p)
+			}
+			p.Mode = im_afterHead
+			return true
+		case "body", "html", "br":
+		default:
+			return strayEndTag(p, t)
+		}
+	}
+	if currentIs(p, "head") {
+		popNode(p)
+	}
+	p.Mode = im_afterHead
+	return false
+}
+
+// Section 11.2.5.4.6
+func afterHeadIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		space, pos := skipSpace(t)
+		insertText(p, space, pos)
+		if len(t.text) == 0 {
+			return true
+		}
+	case CommentToken:
+		insertComment(p, t.node, nil)
+		return true
+	case DoctypeToken:
+		return true
+	case StartTagToken:
+		switch t.name {
+		case "html":
+			return inBodyIM(p, t)
+		case "body":
+			insertElement(p, t.node)
+			p.noFrameset = true
+			p.Mode = im_inBody
+			return true
+		case "frameset":
+			insertElement(p, t.node)
+			p.Mode = im_inFrameset
+			return true
+		case "base", "basefont", "bgsound", "link", "meta", "noframes",
+			"script", "style", "title":
+			treeError(p, "unexpected-start-tag-out-of-my-head")
+			if p.head == nil {
+				return inHeadIM(p, t)
+			}
+			pushOpen(p, p.head)
+			inHeadIM(p, t)
+			removeOpen(p, p.head)
+			return true
+		case "head":
+			treeError(p, "two-heads-are-not-better-than-one")
+			return true
+		}
+	case EndTagToken:
+		if !oneOf(t.name, "body", "html", "br") {
+			return strayEndTag(p, t)
+		}
+	}
+	p.Mode = im_inBody
+	if p.Document {
+		insertElement(p, newElement("body"))
+	}
+	return false
+}
+
+// Section 11.2.5.4.7
+func inBodyIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		reconstructFormatting(p)
+		insertText(p, t.text, t.pos)
+		if _, rest := splitSpace(t.text); len(rest) > 0 {
+			p.noFrameset = true
+		}
+	case CommentToken:
+		insertComment(p, t.node, nil)
+	case DoctypeToken:
+		treeError(p, "unexpected-doctype")
+	case StartTagToken:
+		inBodyStartTag(p, t)
+	case EndTagToken:
+		return inBodyEndTag(p, t)
+	}
+	return true
+}
+
+func inBodyStartTag(p *Parser, t *token) {
+	n, name := t.node, t.name
+	switch {
+	case name == "html":
+		treeError(p, "non-html-root")
+		if len(p.open) > 0 && p.open[0].Data() == "html" {
+			addMissingAttributes(p.open[0], n)
+		}
+	case oneOf(name, "base", "basefont", "bgsound", "command", "link",
+		"meta", "noframes", "script", "style", "title"):
+		inHeadIM(p, t)
+	case name == "body":
+		treeError(p, "unexpected-start-tag")
+		if len(p.open) > 1 && p.open[1].Data() == "body" {
+			p.noFrameset = true
+			addMissingAttributes(p.open[1], n)
+		}
+	case name == "frameset":
+		treeError(p, "unexpected-start-tag")
+		if p.noFrameset || len(p.open) < 2 || p.open[1].Data() != "body" {
+			return
+		}
+		body := p.open[1]
+		if body.Parent != nil {
+			removeChild(body.Parent, body)
+		}
+		popTo(p, 1)
+		insertElement(p, n)
+		p.Mode = im_inFrameset
+	case closesP[name]:
+		closePElement(p)
+		insertElement(p, n)
+	case isHeading(name):
+		closePElement(p)
+		if p.curr != nil && isHeading(p.curr.Data()) {
+			treeError(p, "unexpected-start-tag")
+			popNode(p)
+		}
+		insertElement(p, n)
+	case oneOf(name, "pre", "listing"):
+		closePElement(p)
+		insertElement(p, n)
+		p.skipNewline = true
+		p.noFrameset = true
+	case name == "form":
+		if p.form != nil {
+			treeError(p, "unexpected-start-tag")
+			return
+		}
+		closePElement(p)
+		p.form = insertElement(p, n)
+	case oneOf(name, "li", "dd", "dt"):
+		p.noFrameset = true
+		closes := []string{name}
+		if name != "li" {
+			closes = []string{"dd", "dt"}
+		}
+		for i := len(p.open) - 1; i >= 0; i-- {
+			node := elementName(p.open[i])
+			if oneOf(node, closes...) {
+				genImpliedEndTags(p, node)
+				popTo(p, i)
+				break
+			}
+			if specialElements[node] && !oneOf(node, "address", "div", "p") {
+				break
+			}
+		}
+		closePElement(p)
+		insertElement(p, n)
+	case name == "plaintext":
+		closePElement(p)
+		insertElement(p, n)
+		p.state = plainTextState
+	case name == "button":
+		if inScope(p, baseScope, "button") >= 0 {
+			treeError(p, "unexpected-start-tag-implies-end-tag")
+			genImpliedEndTags(p)
+			popUntil(p, baseScope, "button")
+		}
+		reconstructFormatting(p)
+		insertElement(p, n)
+		p.noFrameset = true
+	case name == "a":
+		for i := len(p.afe) - 1; i >= 0 && p.afe[i] != nil; i-- {
+			if a := p.afe[i]; a.Data() == "a" {
+				treeError(p, "unexpected-start-tag-implies-end-tag")
+				adoptionAgency(p, "a")
+				removeFormatting(p, a)
+				removeOpen(p, a)
+				break
+			}
+		}
+		reconstructFormatting(p)
+		pushFormatting(p, insertElement(p, n))
+	case name == "nobr":
+		reconstructFormatting(p)
+		if inScope(p, baseScope, "nobr") >= 0 {
+			treeError(p, "unexpected-start-tag-implies-end-tag")
+			adoptionAgency(p, "nobr")
+			reconstructFormatting(p)
+		}
+		pushFormatting(p, insertElement(p, n))
+	case formattingElements[name]:
+		reconstructFormatting(p)
+		pushFormatting(p, insertElement(p, n))
+	case oneOf(name, "applet", "marquee", "object"):
+		reconstructFormatting(p)
+		insertElement(p, n)
+		p.afe = append(p.afe, nil)
+		p.noFrameset = true
+	case name == "table":
+		if !p.quirks {
+			closePElement(p)
+		}
+		insertElement(p, n)
+		p.noFrameset = true
+		p.Mode = im_inTable
+	case oneOf(name, "area", "br", "embed", "img", "image", "keygen", "wbr"):
+		if name == "image" {
+			treeError(p, "unexpected-start-tag-treated-as")
+			n.SetData([]rune("img"))
+		}
+		reconstructFormatting(p)
+		insertElement(p, n)
+		popNode(p)
+		p.noFrameset = true
+	case name == "input":
+		reconstructFormatting(p)
+		insertElement(p, n)
+		popNode(p)
+		if v, _ := attrValue(n, "type"); !strings.EqualFold(v, "hidden") {
+			p.noFrameset = true
+		}
+	case oneOf(name, "param", "source", "track"):
+		insertElement(p, n)
+		popNode(p)
+	case name == "hr":
+		closePElement(p)
+		insertElement(p, n)
+		popNode(p)
+		p.noFrameset = true
+	case name == "textarea":
+		insertRawText(p, t, rcDataState)
+		p.skipNewline = true
+		p.noFrameset = true
+	case name == "xmp":
+		closePElement(p)
+		reconstructFormatting(p)
+		p.noFrameset = true
+		insertRawText(p, t, rawTextState)
+	case name == "iframe":
+		p.noFrameset = true
+		insertRawText(p, t, rawTextState)
+	case oneOf(name, "noembed", "noscript"):
+		insertRawText(p, t, rawTextState)
+	case name == "select":
+		reconstructFormatting(p)
+		insertElement(p, n)
+		p.noFrameset = true
+		switch p.Mode {
+		case im_inTable, im_inCaption, im_inTableBody, im_inRow, im_inCell:
+			p.Mode = im_inSelectInTable
+		default:
+			p.Mode = im_inSelect
+		}
+	case oneOf(name, "optgroup", "option"):
+		if currentIs(p, "option") {
+			popNode(p)
+		}
+		reconstructFormatting(p)
+		insertElement(p, n)
+	case oneOf(name, "rp", "rt"):
+		if inScope(p, baseScope, "ruby") >= 0 {
+			genImpliedEndTags(p)
+		}
+		insertElement(p, n)
+	case oneOf(name, "caption", "col", "colgroup", "frame", "head",
+		"tbody", "td", "tfoot", "th", "thead", "tr"):
+		treeError(p, "unexpected-start-tag-ignored")
+	case oneOf(name, "math", "svg"):
+		reconstructFormatting(p)
+		insertForeign(p, t, name)
+	default:
+		reconstructFormatting(p)
+		insertElement(p, n)
+		if t.selfClosing && voidElements[name] {
+			popNode(p)
+		}
+	}
+}
+
+func addMissingAttributes(n, from *Node) {
+	for _, a := range from.Attr {
+		if _, ok := attrValue(n, a.Name); !ok {
+			n.Attr = append(n.Attr, a)
+		}
+	}
+}
+
+func inBodyEndTag(p *Parser, t *token) bool {
+	name := t.name
+	switch {
+	case oneOf(name, "body", "html"):
+		if inScope(p, baseScope, "body") < 0 && !currentIsRoot(p, name) {
+			return strayEndTag(p, t)
+		}
+		p.Mode = im_afterBody
+		return name == "body"
+	case oneOf(name, "address", "article", "aside", "blockquote", "button",
+		"center", "details", "dialog", "dir", "div", "dl", "fieldset",
+		"figcaption", "figure", "footer", "header", "hgroup", "listing",
+		"main", "menu", "nav", "ol", "pre", "section", "summary", "ul"):
+		if inScope(p, baseScope, name) < 0 {
+			return strayEndTag(p, t)
+		}
+		genImpliedEndTags(p)
+		popUntil(p, baseScope, name)
+	case name == "form":
+		node := p.form
+		p.form = nil
+		i := indexOpen(p, node)
+		if node == nil || inScope(p, baseScope, "form") != i {
+			return strayEndTag(p, t)
+		}
+		genImpliedEndTags(p)
+		removeOpen(p, node)
+	case name == "p":
+		if inScope(p, buttonScope, "p") < 0 {
+			treeError(p, "unexpected-end-tag")
+			insertElement(p, newElement("p"))
+		}
+		closePElement(p)
+	case name == "li":
+		if inScope(p, listScope, "li") < 0 {
+			return strayEndTag(p, t)
+		}
+		genImpliedEndTags(p, "li")
+		popUntil(p, listScope, "li")
+	case oneOf(name, "dd", "dt"):
+		if inScope(p, baseScope, name) < 0 {
+			return strayEndTag(p, t)
+		}
+		genImpliedEndTags(p, name)
+		popUntil(p, baseScope, name)
+	case isHeading(name):
+		headings := []string{"h1", "h2", "h3", "h4", "h5", "h6"}
+		if inScope(p, baseScope, headings...) < 0 {
+			return strayEndTag(p, t)
+		}
+		genImpliedEndTags(p)
+		popUntil(p, baseScope, headings...)
+	case formattingElements[name]:
+		if !adoptionAgency(p, name) {
+			return anyOtherEndTag(p, t)
+		}
+	case oneOf(name, "applet", "marquee", "object"):
+		if inScope(p, baseScope, name) < 0 {
+			return strayEndTag(p, t)
+		}
+		genImpliedEndTags(p)
+		popUntil(p, baseScope, name)
+		clearFormattingToMarker(p)
+	case name == "br":
+		treeError(p, "unexpected-end-tag-treated-as")
+		inBodyStartTag(p, &token{kind: StartTagToken, name: "br", node: newElement("br")})
+	default:
+		return anyOtherEndTag(p, t)
+	}
+	return true
+}
+
+// currentIsRoot reports whether the root of a snippet is the element name.
+func currentIsRoot(p *Parser, name string) bool {
+	return !p.Document && len(p.open) > 0 && p.open[0].Data() == name
+}
+
+func anyOtherEndTag(p *Parser, t *token) bool {
+	for i := len(p.open) - 1; i >= 0; i-- {
+		n := elementName(p.open[i])
+		if n == t.name {
+			genImpliedEndTags(p, t.name)
+			popTo(p, i)
+			return true
+		}
+		if specialElements[n] {
+			return strayEndTag(p, t)
+		}
+	}
+	return strayEndTag(p, t)
+}
+
+// Section 11.2.5.4.8
+func textIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		insertText(p, t.text, t.pos)
+		return true
+	case eofToken:
+		treeError(p, "expected-named-closing-tag-but-got-eof")
+		popNode(p)
+		p.Mode = p.originalMode
+		return false
+	case EndTagToken:
+		popNode(p)
+		p.Mode = p.originalMode
+	}
+	return true
+}
+
+// Section 11.2.5.4.9
+func inTableIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		if currentIs(p, "table", "tbody", "tfoot", "thead", "tr") {
+			p.tableText = nil
+			p.originalMode = p.Mode
+			p.Mode = im_inTableText
+			return false
+		}
+	case CommentToken:
+		insertComment(p, t.node, nil)
+		return true
+	case DoctypeToken:
+		return true
+	case StartTagToken:
+		switch t.name {
+		case "caption":
+			clearStackBack(p, "table", "html")
+			p.afe = append(p.afe, nil)
+			insertElement(p, t.node)
+			p.Mode = im_inCaption
+			return true
+		case "colgroup":
+			clearStackBack(p, "table", "html")
+			insertElement(p, t.node)
+			p.Mode = im_inColumnGroup
+			return true
+		case "col":
+			clearStackBack(p, "table", "html")
+			insertElement(p, newElement("colgroup"))
+			p.Mode = im_inColumnGroup
+			return false
+		case "tbody", "tfoot", "thead":
+			clearStackBack(p, "table", "html")
+			insertElement(p, t.node)
+			p.Mode = im_inTableBody
+			return true
+		case "td", "th", "tr":
+			clearStackBack(p, "table", "html")
+			insertElement(p, newElement("tbody"))
+			p.Mode = im_inTableBody
+			return false
+		case "table":
+			treeError(p, "unexpected-start-tag-implies-end-tag")
+			if !popUntil(p, tableScope, "table") {
+				return true
+			}
+			resetInsertionMode(p)
+			return false
+		case "style", "script":
+			return inHeadIM(p, t)
+		case "input":
+			if v, _ := attrValue(t.node, "type"); strings.EqualFold(v, "hidden") {
+				treeError(p, "unexpected-hidden-input-in-table")
+				insertElement(p, t.node)
+				popNode(p)
+				return true
+			}
+		case "form":
+			treeError(p, "unexpected-form-in-table")
+			if p.form == nil {
+				p.form = insertElement(p, t.node)
+				popNode(p)
+			}
+			return true
+		}
+	case EndTagToken:
+		switch t.name {
+		case "table":
+			if !popUntil(p, tableScope, "table") {
+				return strayEndTag(p, t)
+			}
+			resetInsertionMode(p)
+			return true
+		case "body", "caption", "col", "colgroup", "html", "tbody", "td",
+			"tfoot", "th", "thead", "tr":
+			return strayEndTag(p, t)
+		}
+	case eofToken:
+		return inBodyIM(p, t)
+	}
+	treeError(p, "unexpected-token-in-table")
+	p.foster = true
+	defer func() { p.foster = false }()
+	return inBodyIM(p, t)
+}
+
+// Section 11.2.5.4.10
+func inTableTextIM(p *Parser, t *token) bool {
+	if t.kind == TextToken {
+		if len(p.tableText) == 0 {
+			p.tableTextPos = t.pos
+		}
+		p.tableText = append(p.tableText, t.text...)
+		return true
+	}
+	if _, rest := splitSpace(p.tableText); len(rest) > 0 || !allSpace(p.tableText) {
+		p.Errors = append(p.Errors,
+			newParseError(p, p.tableTextPos, "unexpected-character-in-table", ""))
+		p.foster = true
+		inBodyIM(p, &token{kind: TextToken, text: p.tableText, pos: p.tableTextPos})
+		p.foster = false
+	} else {
+		insertText(p, p.tableText, p.tableTextPos)
+	}
+	p.tableText = nil
+	p.Mode = p.originalMode
+	return false
+}
+
+func allSpace(text []rune) bool {
+	for _, c := range text {
+		if !isSpace(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Section 11.2.5.4.11
+func inCaptionIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case StartTagToken:
+		if oneOf(t.name, "caption", "col", "colgroup", "tbody", "td", "tfoot",
+			"th", "thead", "tr") {
+			if closeCaption(p) {
+				return false
+			}
+			return true
+		}
+	case EndTagToken:
+		switch t.name {
+		case "caption":
+			if !closeCaption(p) {
+				return strayEndTag(p, t)
+			}
+			return true
+		case "table":
+			if !closeCaption(p) {
+				return strayEndTag(p, t)
+			}
+			return false
+		case "body", "col", "colgroup", "html", "tbody", "td", "tfoot", "th",
+			"thead", "tr":
+			return strayEndTag(p, t)
+		}
+	}
+	return inBodyIM(p, t)
+}
+
+func closeCaption(p *Parser) bool {
+	if inScope(p, tableScope, "caption") < 0 {
+		return false
+	}
+	genImpliedEndTags(p)
+	popUntil(p, tableScope, "caption")
+	clearFormattingToMarker(p)
+	p.Mode = im_inTable
+	return true
+}
+
+// Section 11.2.5.4.12
+func inColumnGroupIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		space, pos := skipSpace(t)
+		insertText(p, space, pos)
+		if len(t.text) == 0 {
+			return true
+		}
+	case CommentToken:
+		insertComment(p, t.node, nil)
+		return true
+	case DoctypeToken:
+		return true
+	case StartTagToken:
+		switch t.name {
+		case "html":
+			return inBodyIM(p, t)
+		case "col":
+			insertElement(p, t.node)
+			popNode(p)
+			return true
+		}
+	case EndTagToken:
+		switch t.name {
+		case "colgroup":
+			if !currentIs(p, "colgroup") || len(p.open) == 1 {
+				return strayEndTag(p, t)
+			}
+			popNode(p)
+			p.Mode = im_inTable
+			return true
+		case "col":
+			return strayEndTag(p, t)
+		}
+	case eofToken:
+		return inBodyIM(p, t)
+	}
+	if !currentIs(p, "colgroup") || len(p.open) == 1 {
+		treeError(p, "unexpected-token-in-column-group")
+		return true
+	}
+	popNode(p)
+	p.Mode = im_inTable
+	return false
+}
+
+// Section 11.2.5.4.13
+func inTableBodyIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case StartTagToken:
+		switch t.name {
+		case "tr":
+			clearStackBack(p, "tbody", "tfoot", "thead", "html")
+			insertElement(p, t.node)
+			p.Mode = im_inRow
+			return true
+		case "th", "td":
+			treeError(p, "unexpected-cell-in-table-body")
+			clearStackBack(p, "tbody", "tfoot", "thead", "html")
+			insertElement(p, newElement("tr"))
+			p.Mode = im_inRow
+			return false
+		case "caption", "col", "colgroup", "tbody", "tfoot", "thead":
+			if closeTableBody(p) {
+				return false
+			}
+			return true
+		}
+	case EndTagToken:
+		switch t.name {
+		case "tbody", "tfoot", "thead":
+			if inScope(p, tableScope, t.name) < 0 {
+				return strayEndTag(p, t)
+			}
+			clearStackBack(p, "tbody", "tfoot", "thead", "html")
+			popNode(p)
+			p.Mode = im_inTable
+			return true
+		case "table":
+			if !closeTableBody(p) {
+				return strayEndTag(p, t)
+			}
+			return false
+		case "body", "caption", "col", "colgroup", "html", "td", "th", "tr":
+			return strayEndTag(p, t)
+		}
+	}
+	return inTableIM(p, t)
+}
+
+func closeTableBody(p *Parser) bool {
+	if inScope(p, tableScope, "tbody", "thead", "tfoot") < 0 {
+		return false
+	}
+	clearStackBack(p, "tbody", "tfoot", "thead", "html")
+	popNode(p)
+	p.Mode = im_inTable
+	return true
+}
+
+// Section 11.2.5.4.14
+func inRowIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case StartTagToken:
+		switch t.name {
+		case "th", "td":
+			clearStackBack(p, "tr", "html")
+			insertElement(p, t.node)
+			p.Mode = im_inCell
+			p.afe = append(p.afe, nil)
+			return true
+		case "caption", "col", "colgroup", "tbody", "tfoot", "thead", "tr":
+			if closeRow(p) {
+				return false
+			}
+			return true
+		}
+	case EndTagToken:
+		switch t.name {
+		case "tr":
+			if !closeRow(p) {
+				return strayEndTag(p, t)
+			}
+			return true
+		case "table":
+			if !closeRow(p) {
+				return strayEndTag(p, t)
+			}
+			return false
+		case "tbody", "tfoot", "thead":
+			if inScope(p, tableScope, t.name) < 0 {
+				return strayEndTag(p, t)
+			}
+			closeRow(p)
+			return false
+		case "body", "caption", "col", "colgroup", "html", "td", "th":
+			return strayEndTag(p, t)
+		}
+	}
+	return inTableIM(p, t)
+}
+
+func closeRow(p *Parser) bool {
+	if inScope(p, tableScope, "tr") < 0 {
+		return false
+	}
+	clearStackBack(p, "tr", "html")
+	popNode(p)
+	p.Mode = im_inTableBody
+	return true
+}
+
+// Section 11.2.5.4.15
+func inCellIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case StartTagToken:
+		if oneOf(t.name, "caption", "col", "colgroup", "tbody", "td", "tfoot",
+			"th", "thead", "tr") {
+			if inScope(p, tableScope, "td", "th") < 0 {
+				treeError(p, "unexpected-start-tag")
+				return true
+			}
+			closeCell(p)
+			return false
+		}
+	case EndTagToken:
+		switch t.name {
+		case "td", "th":
+			if inScope(p, tableScope, t.name) < 0 {
+				return strayEndTag(p, t)
+			}
+			genImpliedEndTags(p)
+			popUntil(p, tableScope, t.name)
+			clearFormattingToMarker(p)
+			p.Mode = im_inRow
+			return true
+		case "body", "caption", "col", "colgroup", "html":
+			return strayEndTag(p, t)
+		case "table", "tbody", "tfoot", "thead", "tr":
+			if inScope(p, tableScope, t.name) < 0 {
+				return strayEndTag(p, t)
+			}
+			closeCell(p)
+			return false
+		}
+	}
+	return inBodyIM(p, t)
+}
+
+func closeCell(p *Parser) {
+	genImpliedEndTags(p)
+	popUntil(p, tableScope, "td", "th")
+	clearFormattingToMarker(p)
+	p.Mode = im_inRow
+}
+
+// Section 11.2.5.4.16
+func inSelectIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		insertText(p, t.text, t.pos)
+	case CommentToken:
+		insertComment(p, t.node, nil)
+	case DoctypeToken:
+	case StartTagToken:
+		switch t.name {
+		case "html":
+			return inBodyIM(p, t)
+		case "option":
+			if currentIs(p, "option") {
+				popNode(p)
+			}
+			insertElement(p, t.node)
+		case "optgroup":
+			if currentIs(p, "option") {
+				popNode(p)
+			}
+			if currentIs(p, "optgroup") {
+				popNode(p)
+			}
+			insertElement(p, t.node)
+		case "select":
+			treeError(p, "unexpected-select-in-select")
+			closeSelect(p)
+		case "input", "keygen", "textarea":
+			treeError(p, "unexpected-input-in-select")
+			if closeSelect(p) {
+				return false
+			}
+		case "script":
+			return inHeadIM(p, t)
+		default:
+			treeError(p, "unexpected-start-tag-in-select")
+		}
+	case EndTagToken:
+		switch t.name {
+		case "optgroup":
+			if currentIs(p, "option") && len(p.open) > 1 &&
+				p.open[len(p.open)-2].Data() == "optgroup" {
+				popNode(p)
+			}
+			if !currentIs(p, "optgroup") {
+				return strayEndTag(p, t)
+			}
+			popNode(p)
+		case "option":
+			if !currentIs(p, "option") {
+				return strayEndTag(p, t)
+			}
+			popNode(p)
+		case "select":
+			if !closeSelect(p) {
+				return strayEndTag(p, t)
+			}
+		default:
+			return strayEndTag(p, t)
+		}
+	case eofToken:
+		return inBodyIM(p, t)
+	}
+	return true
+}
+
+func closeSelect(p *Parser) bool {
+	i := inSelectScope(p, "select")
+	if i < 0 {
+		return false
+	}
+	popTo(p, i)
+	resetInsertionMode(p)
+	return true
+}
+
+// Section 11.2.5.4.17
+func inSelectInTableIM(p *Parser, t *token) bool {
+	if (t.kind == StartTagToken || t.kind == EndTagToken) && oneOf(t.name,
+		"caption", "table", "tbody", "tfoot", "thead", "tr", "td", "th") {
+		treeError(p, "unexpected-table-element-in-select-in-table")
+		if t.kind == EndTagToken && inScope(p, tableScope, t.name) < 0 {
+			return true
+		}
+		closeSelect(p)
+		return false
+	}
+	return inSelectIM(p, t)
+}
+
+// Section 11.2.5.4.19
+func afterBodyIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		space, pos := skipSpace(t)
+		inBodyIM(p, &token{kind: TextToken, text: space, pos: pos})
+		if len(t.text) == 0 {
+			return true
+		}
+	case CommentToken:
+		insertComment(p, t.node, p.open[0])
+		return true
+	case DoctypeToken, eofToken:
+		return true
+	case StartTagToken:
+		if t.name == "html" {
+			return inBodyIM(p, t)
+		}
+	case EndTagToken:
+		if t.name == "html" {
+			if p.Context != nil {
+				// a fragment is all inside the html element
+				treeError(p, "unexpected-end-tag")
+				return true
+			}
+			p.Mode = im_afterAfterBody
+			return true
+		}
+	}
+	treeError(p, "unexpected-token-after-body")
+	p.Mode = im_inBody
+	return false
+}
+
+// Section 11.2.5.4.20
+func inFramesetIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		// only whitespace is kept
+		var space []rune
+		for _, c := range t.text {
+			if isSpace(c) {
+				space = append(space, c)
+			}
+		}
+		insertText(p, space, t.pos)
+	case CommentToken:
+		insertComment(p, t.node, nil)
+	case StartTagToken:
+		switch t.name {
+		case "html":
+			return inBodyIM(p, t)
+		case "frameset":
+			insertElement(p, t.node)
+		case "frame":
+			insertElement(p, t.node)
+			popNode(p)
+		case "noframes":
+			return inHeadIM(p, t)
+		}
+	case EndTagToken:
+		if t.name != "frameset" {
+			return strayEndTag(p, t)
+		}
+		if len(p.open) == 1 {
+			return strayEndTag(p, t)
+		}
+		popNode(p)
+		if p.Context == nil && !currentIs(p, "frameset") {
+			p.Mode = im_afterFrameset
+		}
+	}
+	return true
+}
+
+// Section 11.2.5.4.21
+func afterFramesetIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case TextToken:
+		var space []rune
+		for _, c := range t.text {
+			if isSpace(c) {
+				space = append(space, c)
+			}
+		}
+		insertText(p, space, t.pos)
+	case CommentToken:
+		insertComment(p, t.node, nil)
+	case StartTagToken:
+		switch t.name {
+		case "html":
+			return inBodyIM(p, t)
+		case "noframes":
+			return inHeadIM(p, t)
+		}
+	case EndTagToken:
+		if t.name != "html" {
+			return strayEndTag(p, t)
+		}
+		p.Mode = im_afterAfterFrameset
+	}
+	return true
+}
+
+// Section 11.2.5.4.22
+func afterAfterBodyIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case CommentToken:
+		insertComment(p, t.node, documentNode(p))
+		return true
+	case DoctypeToken, eofToken:
+		return inBodyIM(p, t)
+	case TextToken:
+		if allSpace(t.text) {
+			return inBodyIM(p, t)
+		}
+	case StartTagToken:
+		if t.name == "html" {
+			return inBodyIM(p, t)
+		}
+	}
+	treeError(p, "unexpected-token-after-after-body")
+	p.Mode = im_inBody
+	return false
+}
+
+// Section 11.2.5.4.23
+func afterAfterFramesetIM(p *Parser, t *token) bool {
+	switch t.kind {
+	case CommentToken:
+		insertComment(p, t.node, documentNode(p))
+	case DoctypeToken, eofToken:
+		return inBodyIM(p, t)
+	case TextToken:
+		var space []rune
+		for _, c := range t.text {
+			if isSpace(c) {
+				space = append(space, c)
+			}
+		}
+		inBodyIM(p, &token{kind: TextToken, text: space})
+	case StartTagToken:
+		switch t.name {
+		case "html":
+			return inBodyIM(p, t)
+		case "noframes":
+			return inHeadIM(p, t)
+		}
+	}
+	return true
+}
+
+// Foreign content
+
+// adjustedCurrentNode is the current node, or the context element while
+// the root of a fragment is the only open element.
+func adjustedCurrentNode(p *Parser) *Node {
+	if p.Context != nil && len(p.open) == 1 {
+		return p.Context
+	}
+	return p.curr
+}
+
+// isMathTextIntegrationPoint reports whether html start tags and text in n
+// are html content.
+func isMathTextIntegrationPoint(n *Node) bool {
+	return n.Namespace == "math" && oneOf(n.Data(), "mi", "mo", "mn", "ms", "mtext")
+}
+
+// isHTMLIntegrationPoint reports whether start tags and text in n are html
+// content.
+func isHTMLIntegrationPoint(n *Node) bool {
+	switch elementName(n) {
+	case "math annotation-xml":
+		enc, _ := attrValue(n, "encoding")
+		enc = strings.ToLower(enc)
+		return enc == "text/html" || enc == "application/xhtml+xml"
+	case "svg foreignObject", "svg desc", "svg title":
+		return true
+	}
+	return false
+}
+
+// Section 11.2.5
+// inForeignContent reports whether t is to be processed by the rules for
+// foreign content rather than those of the insertion mode.
+func inForeignContent(p *Parser, t *token) bool {
+	n := adjustedCurrentNode(p)
+	if n == nil || n.Type != ElementNode || n.Namespace == "" || t.kind == eofToken {
+		return false
+	}
+	html := t.kind == TextToken || t.kind == StartTagToken
+	switch {
+	case isMathTextIntegrationPoint(n):
+		return !html || oneOf(t.name, "mglyph", "malignmark")
+	case elementName(n) == "math annotation-xml":
+		return t.kind != StartTagToken || t.name != "svg"
+	case isHTMLIntegrationPoint(n):
+		return !html
+	}
+	return true
+}
+
+// insertForeign inserts the element of a start tag in the namespace ns,
+// fixing up the names the tokenizer lowercased and the attributes that are
+// in a namespace of their own.
+func insertForeign(p *Parser, t *token, ns string) {
+	n := t.node
+	n.Namespace = ns
+	switch ns {
+	case "svg":
+		if name, ok := svgTagNames[t.name]; ok {
+			n.SetData([]rune(name))
+		}
+		for _, a := range n.Attr {
+			if name, ok := svgAttributeNames[a.Name]; ok {
+				a.Name = name
+			}
+		}
+	case "math":
+		for _, a := range n.Attr {
+			if a.Name == "definitionurl" {
+				a.Name = "definitionURL"
+			}
+		}
+	}
+	for _, a := range n.Attr {
+		if f, ok := foreignAttributes[a.Name]; ok {
+			a.Namespace, a.Name = f[0], f[1]
+		}
+	}
+	insertElement(p, n)
+	if t.selfClosing {
+		popNode(p)
+	}
+}
+
+// breaksOut reports whether t is an html tag that ends the foreign content
+// it turns up in.
+func breaksOut(t *token) bool {
+	switch t.kind {
+	case StartTagToken:
+		if t.name == "font" {
+			for _, attr := range []string{"color", "face", "size"} {
+				if _, ok := attrValue(t.node, attr); ok {
+					return true
+				}
+			}
+			return false
+		}
+		return breakoutElements[t.name]
+	case EndTagToken:
+		return t.name == "br" || t.name == "p"
+	}
+	return false
+}
+
+// Section 11.2.5.5
+// foreignContentIM is the rules for tokens in svg and mathml elements. It
+// returns false if the token is to be processed as html content after all.
+func foreignContentIM(p *Parser, t *token) bool {
+	switch {
+	case t.kind == TextToken:
+		for i, c := range t.text {
+			switch {
+			case c == 0:
+				treeError(p, "unexpected-null-character")
+				t.text[i] = '\uFFFD'
+			case !isSpace(c):
+				p.noFrameset = true
+			}
+		}
+		insertText(p, t.text, t.pos)
+	case t.kind == CommentToken:
+		insertComment(p, t.node, nil)
+	case t.kind == DoctypeToken:
+		treeError(p, "unexpected-doctype")
+	case breaksOut(t):
+		treeError(p, "unexpected-html-element-in-foreign-content")
+		for len(p.open) > 1 && p.curr.Namespace != "" &&
+			!isMathTextIntegrationPoint(p.curr) && !isHTMLIntegrationPoint(p.curr) {
+			popNode(p)
+		}
+		return false
+	case t.kind == StartTagToken:
+		insertForeign(p, t, adjustedCurrentNode(p).Namespace)
+	case t.kind == EndTagToken:
+		return foreignEndTag(p, t)
+	}
+	return true
+}
+
+// foreignEndTag closes the foreign element an end tag matches, whatever
+// the case of its name. It returns false if an html element comes first,
+// for the insertion mode to handle the end tag.
+func foreignEndTag(p *Parser, t *token) bool {
+	if strings.ToLower(p.curr.Data()) != t.name {
+		treeError(p, "unexpected-end-tag")
+	}
+	for i := len(p.open) - 1; i >= 0; i-- {
+		if strings.ToLower(p.open[i].Data()) == t.name {
+			popTo(p, i)
+			return true
+		}
+		if i == 0 {
+			return strayEndTag(p, t)
+		}
+		if p.open[i-1].Namespace == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Copyright 2011 Jeremy Wall (jeremy@marzhillstudios.com)
+// Use of this source code is governed by the Artistic License 2.0.
+// That License is included in the LICENSE file.
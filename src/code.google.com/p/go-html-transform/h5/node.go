@@ -2,30 +2,51 @@ package h5
 
 import (
 	"fmt"
+	"strings"
 )
 
 // The type of a html5 nodes attributes
 type Attribute struct {
 	Name  string
 	Value string
+	// The namespace of an attribute of a foreign element, like xlink:href,
+	// is "xlink", "xml" or "xmlns". It is empty for every other attribute.
+	Namespace string
 	// TODO for gob this should be public field
 	quote rune
 }
 
-// Serialize an html5 attribute to a string
+var (
+	textEscaper = strings.NewReplacer(
+		"&", "&amp;", "\u00a0", "&nbsp;", "<", "&lt;", ">", "&gt;")
+	doubleQuoteEscaper = strings.NewReplacer(
+		"&", "&amp;", "\u00a0", "&nbsp;", `"`, "&quot;")
+	singleQuoteEscaper = strings.NewReplacer(
+		"&", "&amp;", "\u00a0", "&nbsp;", "'", "&#39;")
+)
+
+// Serialize an html5 attribute to a string. The value is quoted the way it
+// was in the source, double quotes if it wasn't, and escaped to match.
 func (a *Attribute) String() string {
-	// TODO handle different quoting styles.
-	c := '"'
-	if a.quote != 0 {
-		c = a.quote
+	name := a.qualifiedName()
+	if a.quote == '\'' {
+		return fmt.Sprintf("%s='%s'", name, singleQuoteEscaper.Replace(a.Value))
+	}
+	return fmt.Sprintf("%s=\"%s\"", name, doubleQuoteEscaper.Replace(a.Value))
+}
+
+// qualifiedName is the name of the attribute as it is written, like
+// "xlink:href".
+func (a *Attribute) qualifiedName() string {
+	if a.Namespace == "" || a.Namespace == "xmlns" && a.Name == "xmlns" {
+		return a.Name
 	}
-	return fmt.Sprintf(
-		"%s=%c%s%c", a.Name, c, a.Value, c)
+	return a.Namespace + ":" + a.Name
 }
 
 // Clone an html5 attribute
 func (a *Attribute) Clone() *Attribute {
-	return &Attribute{Name: a.Name, Value: a.Value}
+	return &Attribute{Name: a.Name, Value: a.Value, Namespace: a.Namespace}
 }
 
 // Represents the type of an html5 node
@@ -36,6 +57,8 @@ const (
 	ElementNode NodeType = iota
 	DoctypeNode NodeType = iota
 	CommentNode NodeType = iota
+	// The root of a whole document, see Parser.Document.
+	DocumentNode NodeType = iota
 )
 
 // The type of an html5 node
@@ -49,6 +72,12 @@ type Node struct {
 	Public     bool         // True if this is a PUBLIC doctype node
 	System     bool         // True if this is a SYSTEM doctype node
 	Identifier []rune       // The identifier if this is a doctype node
+	// The system identifier following the public one of a PUBLIC doctype
+	SystemIdentifier []rune
+	// The namespace of an element in foreign content, "svg" or "math". It
+	// is empty for html elements.
+	Namespace string
+	Pos       Position // Where the node starts in the source, zero if it isn't in it
 }
 
 // Sets a Nodes data. (eg: The Tagname for ElementNodes or text for TextNodes)
@@ -56,17 +85,6 @@ func (n *Node) SetData(rs []rune) {
 	n.data = rs
 }
 
-func attrString(attrs []*Attribute) string {
-	if attrs == nil {
-		return ""
-	}
-	s := ""
-	for _, a := range attrs {
-		s += fmt.Sprintf(" %s", a)
-	}
-	return s
-}
-
 func doctypeString(n *Node) string {
 	keyword := ""
 	identifier := string(n.Identifier)
@@ -81,47 +99,13 @@ func doctypeString(n *Node) string {
 	return fmt.Sprintf("<!DOCTYPE %s=\"%s\">", keyword, identifier)
 }
 
-func commentString(n *Node) string {
-	return fmt.Sprintf("<!--%s-->", n.Data())
-}
-
 // Serialize an html5 node to a string.
+// Text is escaped except inside raw text elements like script and style, and
+// void elements like br get no end tag.
 func (n *Node) String() string {
-	switch n.Type {
-	case TextNode:
-		return n.Data()
-	case ElementNode:
-		// TODO handle the strange self close tags
-		if n.Children == nil || len(n.Children) == 0 {
-			name := n.Data()
-			switch name {
-			case "base", "bgsound", "command", "link", "meta",
-				"area", "br", "embed", "img", "keygen", "wbr",
-				"param", "source", "track", "hr", "input", "image":
-				return fmt.Sprintf("<%s%s>", name, attrString(n.Attr))
-			default:
-				return fmt.Sprintf("<%s%s></%s>", name, attrString(n.Attr), name)
-			}
-			return "<" + n.Data() + attrString(n.Attr) + "/>"
-		} else {
-			s := "<" + n.Data() + attrString(n.Attr) + ">"
-			for _, n := range n.Children {
-				s += n.String()
-			}
-			s += "</" + n.Data() + ">"
-			return s
-		}
-	case DoctypeNode:
-		// TODO Doctype stringification
-		s := doctypeString(n)
-		for _, n := range n.Children {
-			s += n.String()
-		}
-		return s
-	case CommentNode:
-		return commentString(n)
-	}
-	return ""
+	var b strings.Builder
+	n.Render(&b)
+	return b.String()
 }
 
 // Walk a Node tree with a given function.
@@ -144,6 +128,9 @@ func cloneNode(n, p *Node) *Node {
 	clone.Public = n.Public
 	clone.System = n.System
 	clone.Identifier = n.Identifier
+	clone.SystemIdentifier = n.SystemIdentifier
+	clone.Namespace = n.Namespace
+	clone.Pos = n.Pos
 	copy(clone.data, n.data)
 	for i, a := range n.Attr {
 		clone.Attr[i] = a.Clone()
@@ -175,6 +162,11 @@ func Text(str string) *Node {
 	return &Node{data: []rune(str)}
 }
 
+// Construct an ElementNode
+func Element(name string, attrs ...*Attribute) *Node {
+	return &Node{Type: ElementNode, data: []rune(name), Attr: attrs}
+}
+
 // TODO Constructors for other html node types.
 
 // Copyright 2011 Jeremy Wall (jeremy@marzhillstudios.com)
@@ -0,0 +1,164 @@
+package h5
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Elements whose contents are kept as they are when rendering with an indent.
+var preformatted = map[string]bool{
+	"listing":  true,
+	"pre":      true,
+	"textarea": true,
+}
+
+// A renderer writes the html5 serialization of a node tree.
+type renderer struct {
+	w      *bufio.Writer
+	indent string // one level of indentation, empty to write the tree as is
+}
+
+// Render writes the html5 serialization of the node tree to w. The output
+// is buffered, so w needn't be.
+func (n *Node) Render(w io.Writer) error {
+	return render(w, n, "")
+}
+
+// RenderIndent writes the node tree to w like Render but with each element
+// on a line of its own, indented by one more indent than its parent.
+// Whitespace between elements is dropped and the text around them trimmed,
+// except inside pre, textarea and raw text elements whose contents are
+// written as they are.
+func (n *Node) RenderIndent(w io.Writer, indent string) error {
+	return render(w, n, indent)
+}
+
+func render(w io.Writer, n *Node, indent string) error {
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(w)
+	}
+	r := &renderer{w: bw, indent: indent}
+	r.node(n, n.Type == TextNode && n.Parent != nil &&
+		rawTextElements[elementName(n.Parent)], 0)
+	// bufio.Writer keeps the first write error and Flush returns it.
+	return bw.Flush()
+}
+
+func (r *renderer) node(n *Node, raw bool, depth int) {
+	switch n.Type {
+	case TextNode:
+		if raw {
+			r.w.WriteString(n.Data())
+		} else {
+			textEscaper.WriteString(r.w, n.Data())
+		}
+	case ElementNode:
+		name := n.Data()
+		r.w.WriteByte('<')
+		r.w.WriteString(name)
+		for _, a := range n.Attr {
+			r.w.WriteByte(' ')
+			r.attr(a)
+		}
+		r.w.WriteByte('>')
+		if voidElements[elementName(n)] {
+			return
+		}
+		r.children(n, rawTextElements[elementName(n)], depth)
+		r.w.WriteString("</")
+		r.w.WriteString(name)
+		r.w.WriteByte('>')
+	case DoctypeNode:
+		r.w.WriteString(doctypeString(n))
+		if r.indent == "" {
+			for _, c := range n.Children {
+				r.node(c, false, depth)
+			}
+			return
+		}
+		for _, c := range n.Children {
+			r.prettyNode(c, depth)
+		}
+	case DocumentNode:
+		for i, c := range n.Children {
+			if i > 0 && r.indent != "" {
+				r.newline(depth)
+			}
+			r.node(c, false, depth)
+		}
+	case CommentNode:
+		r.w.WriteString("<!--")
+		r.w.WriteString(n.Data())
+		r.w.WriteString("-->")
+	}
+}
+
+func (r *renderer) attr(a *Attribute) {
+	r.w.WriteString(a.qualifiedName())
+	if a.quote == '\'' {
+		r.w.WriteString("='")
+		singleQuoteEscaper.WriteString(r.w, a.Value)
+		r.w.WriteByte('\'')
+		return
+	}
+	r.w.WriteString("=\"")
+	doubleQuoteEscaper.WriteString(r.w, a.Value)
+	r.w.WriteByte('"')
+}
+
+// children writes the children of n, each on a line of its own if indenting
+// and n holds other elements.
+func (r *renderer) children(n *Node, raw bool, depth int) {
+	if r.indent != "" && preformatted[n.Data()] {
+		indent := r.indent
+		r.indent = ""
+		defer func() { r.indent = indent }()
+	}
+	if r.indent == "" || raw || !hasElementChild(n) {
+		for _, c := range n.Children {
+			r.node(c, raw, depth+1)
+		}
+		return
+	}
+	for _, c := range n.Children {
+		r.prettyNode(c, depth+1)
+	}
+	r.newline(depth)
+}
+
+// prettyNode writes n on a new line at depth, trimming text. It writes
+// nothing for whitespace.
+func (r *renderer) prettyNode(n *Node, depth int) {
+	if n.Type == TextNode {
+		text := strings.TrimSpace(n.Data())
+		if text != "" {
+			r.newline(depth)
+			textEscaper.WriteString(r.w, text)
+		}
+		return
+	}
+	r.newline(depth)
+	r.node(n, false, depth)
+}
+
+func (r *renderer) newline(depth int) {
+	r.w.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		r.w.WriteString(r.indent)
+	}
+}
+
+func hasElementChild(n *Node) bool {
+	for _, c := range n.Children {
+		if c.Type != TextNode {
+			return true
+		}
+	}
+	return false
+}
+
+// Copyright 2011 Jeremy Wall (jeremy@marzhillstudios.com)
+// Use of this source code is governed by the Artistic License 2.0.
+// That License is included in the LICENSE file.
@@ -0,0 +1,206 @@
+package h5
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// The type of an html5 token
+type TokenType int
+
+const (
+	StartTagToken TokenType = iota
+	EndTagToken
+	TextToken
+	CommentToken
+	DoctypeToken
+	eofToken // only the tree construction stage sees the end of the input
+)
+
+// Represent the token type as a string
+func (t TokenType) String() string {
+	switch t {
+	case StartTagToken:
+		return "StartTag"
+	case EndTagToken:
+		return "EndTag"
+	case TextToken:
+		return "Text"
+	case CommentToken:
+		return "Comment"
+	case DoctypeToken:
+		return "Doctype"
+	}
+	return "EOF"
+}
+
+// A place in the html5 source. Offset counts bytes from the start of the
+// source, Line and Column count from 1 and Column counts characters.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// An html5 token as read by a Tokenizer
+type Token struct {
+	Type TokenType
+	// The tag name of start and end tags, the text of text and comment
+	// tokens and the name of doctypes.
+	Data        string
+	Attr        []*Attribute // The attributes of a start tag
+	SelfClosing bool         // True if a start tag ended with "/>"
+	Public      bool         // True if this is a PUBLIC doctype
+	System      bool         // True if this is a SYSTEM doctype
+	Identifier  string       // The identifier if this is a doctype
+	// The system identifier following the public one of a PUBLIC doctype
+	SystemIdentifier string
+	Pos              Position // Where the token starts in the source
+}
+
+// A Tokenizer reads the tokens of an html5 stream one at a time without
+// building a tree, so it needs no more memory for a large document than for
+// a small one.
+//
+//	z := h5.NewTokenizer(rdr)
+//	for {
+//	   t, err := z.Next()
+//	   if err != nil {
+//	      break // io.EOF at the end of the stream
+//	   }
+//	   // do something with the token
+//	}
+//
+// The contents of elements like script, style, title and textarea are read
+// as text up to their end tag, the way the tree construction stage would.
+type Tokenizer struct {
+	p     *Parser
+	h     stateHandler
+	queue []*Token
+	err   error
+}
+
+// Construct a new h5 tokenizer from a string
+func NewTokenizerFromString(s string) *Tokenizer {
+	return NewTokenizer(strings.NewReader(s))
+}
+
+// Construct a new h5 tokenizer from a io.Reader
+func NewTokenizer(r io.Reader) *Tokenizer {
+	z := &Tokenizer{p: &Parser{In: bufio.NewReader(r)}}
+	z.p.emit = z.add
+	start(z.p)
+	z.h = dataStateHandlerSwitch(z.p)
+	return z
+}
+
+// Next returns the next token in the stream. It returns io.EOF after the
+// last one, or the error reading the stream.
+func (z *Tokenizer) Next() (*Token, error) {
+	for !z.ready() {
+		if z.err != nil {
+			return nil, z.err
+		}
+		z.step()
+	}
+	t := z.queue[0]
+	z.queue = z.queue[1:]
+	return t, nil
+}
+
+// ready reports whether the first queued token is complete. Text is held
+// back until the token after it is read, as a '<' that turns out not to
+// start a tag is more of it.
+func (z *Tokenizer) ready() bool {
+	switch len(z.queue) {
+	case 0:
+		return false
+	case 1:
+		return z.queue[0].Type != TextToken || z.err != nil
+	}
+	return true
+}
+
+// step runs the tokenizer until it is in its next state.
+func (z *Tokenizer) step() {
+	h, err := z.h(z.p)
+	switch {
+	case err != nil:
+		z.end(err)
+	case h == nil:
+		z.end(io.EOF)
+	default:
+		z.h = h
+	}
+}
+
+// Errors returns the parse errors found since it was last called. The
+// tokenizer recovers from them as the html5 spec says to.
+func (z *Tokenizer) Errors() []*ParseError {
+	errs := z.p.Errors
+	z.p.Errors = nil
+	return errs
+}
+
+// end emits what is left of the stream and stops the tokenizer.
+func (z *Tokenizer) end(err error) {
+	if err == io.EOF {
+		endOfFile(z.p)
+	}
+	z.h = nil
+	z.err = err
+}
+
+// add queues a token as Next returns it and switches the tokenizer to the
+// state the contents of the element it starts are read in.
+func (z *Tokenizer) add(t *token) {
+	tok := &Token{Type: t.kind, Pos: t.pos}
+	switch t.kind {
+	case eofToken:
+		return
+	case StartTagToken:
+		tok.Data = t.name
+		tok.Attr = t.node.Attr
+		tok.SelfClosing = t.selfClosing
+		if s, ok := textStates[t.name]; ok {
+			z.p.state, z.p.rawTag = s, t.name
+		}
+	case EndTagToken:
+		tok.Data = t.name
+	case TextToken:
+		tok.Data = string(t.text)
+		if k := len(z.queue); k > 0 && z.queue[k-1].Type == TextToken {
+			z.queue[k-1].Data += tok.Data
+			return
+		}
+	case CommentToken:
+		tok.Data = t.node.Data()
+	case DoctypeToken:
+		tok.Data = t.node.Data()
+		tok.Public = t.node.Public
+		tok.System = t.node.System
+		tok.Identifier = string(t.node.Identifier)
+		tok.SystemIdentifier = string(t.node.SystemIdentifier)
+	}
+	z.queue = append(z.queue, tok)
+}
+
+// The tokenizer states the contents of elements are read in when there is
+// no tree construction stage to switch to them.
+var textStates = map[string]textState{
+	"title":     rcDataState,
+	"textarea":  rcDataState,
+	"style":     rawTextState,
+	"xmp":       rawTextState,
+	"iframe":    rawTextState,
+	"noembed":   rawTextState,
+	"noframes":  rawTextState,
+	"noscript":  rawTextState,
+	"script":    scriptDataState,
+	"plaintext": plainTextState,
+}
+
+// Copyright 2011 Jeremy Wall (jeremy@marzhillstudios.com)
+// Use of this source code is governed by the Artistic License 2.0.
+// That License is included in the LICENSE file.
@@ -1,66 +1,300 @@
 package h5
 
 var (
-	// Scopes
+	// Scopes. Foreign elements are keyed by their namespace and name, see
+	// elementName.
 	allScope  = map[string]bool{}
 	baseScope = map[string]bool{
-		"applet":         true,
-		"caption":        true,
-		"html":           true,
-		"table":          true,
-		"td":             true,
-		"th":             true,
-		"marquee":        true,
-		"object":         true,
-		"mi":             true,
-		"mo":             true,
-		"mn":             true,
-		"ms":             true,
-		"mtext":          true,
-		"annotation-xml": true,
-		"foreignObject":  true,
-		"desc":           true,
-		"title":          true,
+		"applet":              true,
+		"caption":             true,
+		"html":                true,
+		"table":               true,
+		"td":                  true,
+		"th":                  true,
+		"marquee":             true,
+		"object":              true,
+		"math mi":             true,
+		"math mo":             true,
+		"math mn":             true,
+		"math ms":             true,
+		"math mtext":          true,
+		"math annotation-xml": true,
+		"svg foreignObject":   true,
+		"svg desc":            true,
+		"svg title":           true,
 	}
 	buttonScope = map[string]bool{
-		"applet":         true,
-		"caption":        true,
-		"html":           true,
-		"table":          true,
-		"td":             true,
-		"th":             true,
-		"marquee":        true,
-		"object":         true,
-		"mi":             true,
-		"mo":             true,
-		"mn":             true,
-		"ms":             true,
-		"mtext":          true,
-		"annotation-xml": true,
-		"foreignObject":  true,
-		"desc":           true,
-		"title":          true,
-		"button":         true,
+		"applet":              true,
+		"caption":             true,
+		"html":                true,
+		"table":               true,
+		"td":                  true,
+		"th":                  true,
+		"marquee":             true,
+		"object":              true,
+		"math mi":             true,
+		"math mo":             true,
+		"math mn":             true,
+		"math ms":             true,
+		"math mtext":          true,
+		"math annotation-xml": true,
+		"svg foreignObject":   true,
+		"svg desc":            true,
+		"svg title":           true,
+		"button":              true,
 	}
 	listScope = map[string]bool{
-		"applet":         true,
-		"caption":        true,
-		"html":           true,
-		"table":          true,
-		"td":             true,
-		"th":             true,
-		"marquee":        true,
-		"object":         true,
-		"mi":             true,
-		"mo":             true,
-		"mn":             true,
-		"ms":             true,
-		"mtext":          true,
-		"annotation-xml": true,
-		"foreignObject":  true,
-		"desc":           true,
-		"title":          true,
-		"ol":             true,
-		"ul":             true,
+		"applet":              true,
+		"caption":             true,
+		"html":                true,
+		"table":               true,
+		"td":                  true,
+		"th":                  true,
+		"marquee":             true,
+		"object":              true,
+		"math mi":             true,
+		"math mo":             true,
+		"math mn":             true,
+		"math ms":             true,
+		"math mtext":          true,
+		"math annotation-xml": true,
+		"svg foreignObject":   true,
+		"svg desc":            true,
+		"svg title":           true,
+		"ol":                  true,
+		"ul":                  true,
+	}
+	tableScope = map[string]bool{
+		"html":  true,
+		"table": true,
+	}
+
+	// Elements whose text is serialized without escaping.
+	rawTextElements = map[string]bool{
+		"iframe":    true,
+		"noembed":   true,
+		"noframes":  true,
+		"noscript":  true,
+		"plaintext": true,
+		"script":    true,
+		"style":     true,
+		"xmp":       true,
+	}
+	// Elements that have no contents and no end tag.
+	voidElements = map[string]bool{
+		"area":     true,
+		"base":     true,
+		"basefont": true,
+		"bgsound":  true,
+		"br":       true,
+		"col":      true,
+		"command":  true,
+		"embed":    true,
+		"frame":    true,
+		"hr":       true,
+		"image":    true,
+		"img":      true,
+		"input":    true,
+		"keygen":   true,
+		"link":     true,
+		"meta":     true,
+		"param":    true,
+		"source":   true,
+		"track":    true,
+		"wbr":      true,
+	}
+
+	// The tag names of svg elements the tokenizer lowercased.
+	svgTagNames = map[string]string{
+		"altglyph":            "altGlyph",
+		"altglyphdef":         "altGlyphDef",
+		"altglyphitem":        "altGlyphItem",
+		"animatecolor":        "animateColor",
+		"animatemotion":       "animateMotion",
+		"animatetransform":    "animateTransform",
+		"clippath":            "clipPath",
+		"feblend":             "feBlend",
+		"fecolormatrix":       "feColorMatrix",
+		"fecomponenttransfer": "feComponentTransfer",
+		"fecomposite":         "feComposite",
+		"feconvolvematrix":    "feConvolveMatrix",
+		"fediffuselighting":   "feDiffuseLighting",
+		"fedisplacementmap":   "feDisplacementMap",
+		"fedistantlight":      "feDistantLight",
+		"fedropshadow":        "feDropShadow",
+		"feflood":             "feFlood",
+		"fefunca":             "feFuncA",
+		"fefuncb":             "feFuncB",
+		"fefuncg":             "feFuncG",
+		"fefuncr":             "feFuncR",
+		"fegaussianblur":      "feGaussianBlur",
+		"feimage":             "feImage",
+		"femerge":             "feMerge",
+		"femergenode":         "feMergeNode",
+		"femorphology":        "feMorphology",
+		"feoffset":            "feOffset",
+		"fepointlight":        "fePointLight",
+		"fespecularlighting":  "feSpecularLighting",
+		"fespotlight":         "feSpotLight",
+		"fetile":              "feTile",
+		"feturbulence":        "feTurbulence",
+		"foreignobject":       "foreignObject",
+		"glyphref":            "glyphRef",
+		"lineargradient":      "linearGradient",
+		"radialgradient":      "radialGradient",
+		"textpath":            "textPath",
+	}
+	// The attribute names of svg elements the tokenizer lowercased.
+	svgAttributeNames = map[string]string{
+		"attributename":             "attributeName",
+		"attributetype":             "attributeType",
+		"basefrequency":             "baseFrequency",
+		"baseprofile":               "baseProfile",
+		"calcmode":                  "calcMode",
+		"clippathunits":             "clipPathUnits",
+		"contentscripttype":         "contentScriptType",
+		"contentstyletype":          "contentStyleType",
+		"diffuseconstant":           "diffuseConstant",
+		"edgemode":                  "edgeMode",
+		"externalresourcesrequired": "externalResourcesRequired",
+		"filterres":                 "filterRes",
+		"filterunits":               "filterUnits",
+		"glyphref":                  "glyphRef",
+		"gradienttransform":         "gradientTransform",
+		"gradientunits":             "gradientUnits",
+		"kernelmatrix":              "kernelMatrix",
+		"kernelunitlength":          "kernelUnitLength",
+		"keypoints":                 "keyPoints",
+		"keysplines":                "keySplines",
+		"keytimes":                  "keyTimes",
+		"lengthadjust":              "lengthAdjust",
+		"limitingconeangle":         "limitingConeAngle",
+		"markerheight":              "markerHeight",
+		"markerunits":               "markerUnits",
+		"markerwidth":               "markerWidth",
+		"maskcontentunits":          "maskContentUnits",
+		"maskunits":                 "maskUnits",
+		"numoctaves":                "numOctaves",
+		"pathlength":                "pathLength",
+		"patterncontentunits":       "patternContentUnits",
+		"patterntransform":          "patternTransform",
+		"patternunits":              "patternUnits",
+		"pointsatx":                 "pointsAtX",
+		"pointsaty":                 "pointsAtY",
+		"pointsatz":                 "pointsAtZ",
+		"preservealpha":             "preserveAlpha",
+		"preserveaspectratio":       "preserveAspectRatio",
+		"primitiveunits":            "primitiveUnits",
+		"refx":                      "refX",
+		"refy":                      "refY",
+		"repeatcount":               "repeatCount",
+		"repeatdur":                 "repeatDur",
+		"requiredextensions":        "requiredExtensions",
+		"requiredfeatures":          "requiredFeatures",
+		"specularconstant":          "specularConstant",
+		"specularexponent":          "specularExponent",
+		"spreadmethod":              "spreadMethod",
+		"startoffset":               "startOffset",
+		"stddeviation":              "stdDeviation",
+		"stitchtiles":               "stitchTiles",
+		"surfacescale":              "surfaceScale",
+		"systemlanguage":            "systemLanguage",
+		"tablevalues":               "tableValues",
+		"targetx":                   "targetX",
+		"targety":                   "targetY",
+		"textlength":                "textLength",
+		"viewbox":                   "viewBox",
+		"viewtarget":                "viewTarget",
+		"xchannelselector":          "xChannelSelector",
+		"ychannelselector":          "yChannelSelector",
+		"zoomandpan":                "zoomAndPan",
+	}
+	// The attributes of foreign elements that are in a namespace of their
+	// own, by the name the tokenizer read. The values are the namespace and
+	// the name in it.
+	foreignAttributes = map[string][2]string{
+		"xlink:actuate": {"xlink", "actuate"},
+		"xlink:arcrole": {"xlink", "arcrole"},
+		"xlink:href":    {"xlink", "href"},
+		"xlink:role":    {"xlink", "role"},
+		"xlink:show":    {"xlink", "show"},
+		"xlink:title":   {"xlink", "title"},
+		"xlink:type":    {"xlink", "type"},
+		"xml:lang":      {"xml", "lang"},
+		"xml:space":     {"xml", "space"},
+		"xmlns":         {"xmlns", "xmlns"},
+		"xmlns:xlink":   {"xmlns", "xlink"},
+	}
+	// Start tags that end the svg or mathml element they are in.
+	breakoutElements = map[string]bool{
+		"b": true, "big": true, "blockquote": true, "body": true, "br": true,
+		"center": true, "code": true, "dd": true, "div": true, "dl": true,
+		"dt": true, "em": true, "embed": true, "h1": true, "h2": true,
+		"h3": true, "h4": true, "h5": true, "h6": true, "head": true,
+		"hr": true, "i": true, "img": true, "li": true, "listing": true,
+		"menu": true, "meta": true, "nobr": true, "ol": true, "p": true,
+		"pre": true, "ruby": true, "s": true, "small": true, "span": true,
+		"strong": true, "strike": true, "sub": true, "sup": true,
+		"table": true, "tt": true, "u": true, "ul": true, "var": true,
+	}
+
+	// The starts of the public identifiers of doctypes that put a document
+	// in quirks mode, lowercased.
+	quirksPublicPrefixes = []string{
+		"+//silmaril//dtd html pro v0r11 19970101//",
+		"-//as//dtd html 3.0 aswedit + extensions//",
+		"-//advasoft ltd//dtd html 3.0 aswedit + extensions//",
+		"-//ietf//dtd html 2.0 level 1//",
+		"-//ietf//dtd html 2.0 level 2//",
+		"-//ietf//dtd html 2.0 strict level 1//",
+		"-//ietf//dtd html 2.0 strict level 2//",
+		"-//ietf//dtd html 2.0 strict//",
+		"-//ietf//dtd html 2.0//",
+		"-//ietf//dtd html 2.1e//",
+		"-//ietf//dtd html 3.0//",
+		"-//ietf//dtd html 3.2 final//",
+		"-//ietf//dtd html 3.2//",
+		"-//ietf//dtd html 3//",
+		"-//ietf//dtd html level 0//",
+		"-//ietf//dtd html level 1//",
+		"-//ietf//dtd html level 2//",
+		"-//ietf//dtd html level 3//",
+		"-//ietf//dtd html strict level 0//",
+		"-//ietf//dtd html strict level 1//",
+		"-//ietf//dtd html strict level 2//",
+		"-//ietf//dtd html strict level 3//",
+		"-//ietf//dtd html strict//",
+		"-//ietf//dtd html//",
+		"-//metrius//dtd metrius presentational//",
+		"-//microsoft//dtd internet explorer 2.0 html strict//",
+		"-//microsoft//dtd internet explorer 2.0 html//",
+		"-//microsoft//dtd internet explorer 2.0 tables//",
+		"-//microsoft//dtd internet explorer 3.0 html strict//",
+		"-//microsoft//dtd internet explorer 3.0 html//",
+		"-//microsoft//dtd internet explorer 3.0 tables//",
+		"-//netscape comm. corp.//dtd html//",
+		"-//netscape comm. corp.//dtd strict html//",
+		"-//o'reilly and associates//dtd html 2.0//",
+		"-//o'reilly and associates//dtd html extended 1.0//",
+		"-//o'reilly and associates//dtd html extended relaxed 1.0//",
+		"-//sq//dtd html 2.0 hotmetal + extensions//",
+		"-//softquad software//dtd hotmetal pro 6.0::19990601::extensions to html 4.0//",
+		"-//softquad//dtd hotmetal pro 4.0::19971010::extensions to html 4.0//",
+		"-//spyglass//dtd html 2.0 extended//",
+		"-//sun microsystems corp.//dtd hotjava html//",
+		"-//sun microsystems corp.//dtd hotjava strict html//",
+		"-//w3c//dtd html 3 1995-03-24//",
+		"-//w3c//dtd html 3.2 draft//",
+		"-//w3c//dtd html 3.2 final//",
+		"-//w3c//dtd html 3.2//",
+		"-//w3c//dtd html 3.2s draft//",
+		"-//w3c//dtd html 4.0 frameset//",
+		"-//w3c//dtd html 4.0 transitional//",
+		"-//w3c//dtd html experimental 19960712//",
+		"-//w3c//dtd html experimental 970421//",
+		"-//w3c//dtd w3 html//",
+		"-//w3o//dtd w3 html 3.0//",
+		"-//webtechs//dtd mozilla html 2.0//",
+		"-//webtechs//dtd mozilla html//",
 	}
 )
@@ -19,6 +19,7 @@ package transform
 // TODO(jwall): Documentation...
 import (
 	. "code.google.com/p/go-html-transform/h5"
+	"io"
 	"log"
 )
 
@@ -45,6 +46,11 @@ func (t *Transformer) String() string {
 	return t.doc.String()
 }
 
+// The Render method writes the document under transformation to w.
+func (t *Transformer) Render(w io.Writer) error {
+	return t.doc.Render(w)
+}
+
 func (t *Transformer) Clone() *Transformer {
 	return NewTransform(t.Doc())
 }
@@ -103,6 +109,18 @@ func AppendChildren(cs ...*Node) TransformFunc {
 	}
 }
 
+// AppendHTML creates a TransformFunc that appends the nodes str parses to as
+// the contents of the node it operates on.
+func AppendHTML(str string) TransformFunc {
+	return func(n *Node) {
+		cs := parseFragment(n, str)
+		for _, c := range cs {
+			c.Parent = n
+		}
+		AppendChildren(cs...)(n)
+	}
+}
+
 // PrependChildren creates a TransformFunc that prepends the Children passed in.
 func PrependChildren(cs ...*Node) TransformFunc {
 	return func(n *Node) {
@@ -151,6 +169,22 @@ func Replace(ns ...*Node) TransformFunc {
 	}
 }
 
+// ReplaceHTML creates a TransformFunc that replaces the node it operates on
+// with the nodes str parses to as the contents of its parent.
+func ReplaceHTML(str string) TransformFunc {
+	return func(n *Node) {
+		parent := n.Parent
+		if parent == nil {
+			parent = n
+		}
+		ns := parseFragment(parent, str)
+		for _, c := range ns {
+			c.Parent = parent
+		}
+		Replace(ns...)(n)
+	}
+}
+
 // ModifyAttrb creates a TransformFunc that modifies the attributes
 // of the node it operates on.
 func ModifyAttrib(key string, val string) TransformFunc {
@@ -6,6 +6,7 @@
 package transform
 
 import (
+	"bytes"
 	. "code.google.com/p/go-html-transform/h5"
 	"testing"
 )
@@ -49,6 +50,16 @@ func TestAppendChildren(t *testing.T) {
 	assertEqual(t, node.Children[1], child2)
 }
 
+func TestAppendHTML(t *testing.T) {
+	doc, _ := NewDoc("<table><tr><td>a</td></tr></table>")
+	tr := doc.Children[0].Children[0]
+	AppendHTML("<td>b</td>")(tr)
+	assertEqual(t, len(tr.Children), 2)
+	assertEqual(t, tr.Children[1].Parent, tr)
+	assertEqual(t, doc.String(),
+		"<table><tbody><tr><td>a</td><td>b</td></tr></tbody></table>")
+}
+
 func TestRemoveChildren(t *testing.T) {
 	doc, _ := NewDoc("<div id=\"foo\">foo</div>")
 	node := doc.Children[0]
@@ -114,6 +125,13 @@ func TestReplaceSpliceOnRootNode(t *testing.T) {
 	assertEqual(t, doc.Children[0].Children[0].Data(), "foo")
 }
 
+func TestReplaceHTML(t *testing.T) {
+	doc, _ := NewDoc("<ul><li>a</li><li id=\"b\">b</li></ul>")
+	ReplaceHTML("<li>c<li>d")(doc.Children[1])
+	assertEqual(t, doc.String(), "<ul><li>a</li><li>c</li><li>d</li></ul>")
+	assertEqual(t, doc.Children[2].Parent, doc)
+}
+
 func TestModifyAttrib(t *testing.T) {
 	node, _ := NewDoc("<div id=\"foo\">foo</div><")
 	assertEqual(t, node.Attr[0].Value, "foo")
@@ -231,6 +249,27 @@ func TestTransformSubtransforms(t *testing.T) {
 
 }
 
+func TestTransformStringEscapes(t *testing.T) {
+	doc, _ := NewDoc("<div><span class=\"name\">foo</span></div>")
+	tf := NewTransform(doc)
+	tf.Apply(ReplaceChildren(Text("<b onclick=\"evil()\">&</b>")), "span")
+	tf.Apply(ModifyAttrib("class", "\" onmouseover=\"evil()"), "span")
+	assertEqual(t, tf.String(),
+		"<div><span class=\"&quot; onmouseover=&quot;evil()\">"+
+			"&lt;b onclick=\"evil()\"&gt;&amp;&lt;/b&gt;</span></div>")
+}
+
+func TestTransformRender(t *testing.T) {
+	doc, _ := NewDoc("<div><span>foo</span></div>")
+	tf := NewTransform(doc)
+	tf.Apply(ReplaceChildren(Text("bar")), "span")
+	var b bytes.Buffer
+	err := tf.Render(&b)
+	assertEqual(t, err, nil)
+	assertEqual(t, b.String(), "<div><span>bar</span></div>")
+	assertEqual(t, b.String(), tf.String())
+}
+
 // TODO(jwall): benchmarking tests
 func BenchmarkTransformApply(b *testing.B) {
 	for i := 0; i < b.N; i++ {
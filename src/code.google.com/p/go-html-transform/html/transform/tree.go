@@ -17,6 +17,22 @@ func NewDocFromReader(rdr io.Reader) (*Node, error) {
 	return p.Top, err
 }
 
+// NewFragment parses str as the contents of an element named context, the
+// way setting innerHTML does, so "<td>x</td>" in a "tr" stays a td. Like
+// innerHTML it can't fail, malformed html is fixed up as a browser would.
+func NewFragment(context, str string) []*Node {
+	return parseFragment(Element(context), str)
+}
+
+// parseFragment parses str as the contents of the element context.
+func parseFragment(context *Node, str string) []*Node {
+	p := NewParserFromString(str)
+	p.Context = context
+	p.Recover = true
+	p.Parse()
+	return p.Fragment()
+}
+
 // Copyright 2010 Jeremy Wall (jeremy@marzhillstudios.com)
 // Use of this source code is governed by the Artistic License 2.0.
 // That License is included in the LICENSE file.
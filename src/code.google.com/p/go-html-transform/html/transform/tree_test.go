@@ -39,3 +39,14 @@ func TestNewDoc(t *testing.T) {
 	assertEqual(t, len(node.Children), 1)
 	assertEqual(t, node.Children[0].Type, TextNode)
 }
+
+func TestNewFragment(t *testing.T) {
+	ns := NewFragment("tr", "<td>x</td><td>y")
+	assertEqual(t, len(ns), 2)
+	assertEqual(t, ns[0].String(), "<td>x</td>")
+	assertEqual(t, ns[1].String(), "<td>y</td>")
+	assertEqual(t, ns[0].Parent, (*Node)(nil))
+	ns = NewFragment("ul", "<li>a<li>b")
+	assertEqual(t, len(ns), 2)
+	assertEqual(t, ns[1].String(), "<li>b</li>")
+}
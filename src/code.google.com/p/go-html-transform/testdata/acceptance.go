@@ -1,89 +1,463 @@
 package main
 
 import (
-	"bytes"
 	"code.google.com/p/go-html-transform/h5"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
+	"strings"
 )
 
 var (
 	verbose  = flag.Bool("verbose", false, "Verbosity for test output")
 	testSpec = StringEnum("test_spec", map[string]struct{}{"dat": struct{}{},
+		"test": struct{}{},
 		"file": struct{}{},
 		"all":  struct{}{},
 	}, "all", "Type of test to run")
 )
 
+// A test case from an html5lib tree-construction .dat file.
+type datCase struct {
+	line     int // where the case starts in its file
+	data     string
+	document []string // the expected tree, one entry per node
+	isTree   bool     // false for the cases of other suites, like encoding
+	fragment string   // the context element of a fragment case
+	encoding string   // the expected encoding of an encoding case
+}
+
+// readDatCases splits a .dat file into its test cases. A case is a #data
+// section followed by #errors, an optional #document-fragment and #document,
+// or by #encoding in the encoding suite.
+func readDatCases(data []byte) []*datCase {
+	var cases []*datCase
+	var c *datCase
+	section := ""
+	var lines []string
+	finish := func() {
+		if c == nil {
+			return
+		}
+		switch section {
+		case "#data":
+			c.data = strings.Join(lines, "\n")
+		case "#document-fragment":
+			c.fragment = strings.Join(lines, "")
+		case "#document":
+			// the blank line between cases isn't part of the tree
+			if n := len(lines); n > 0 && lines[n-1] == "" {
+				lines = lines[:n-1]
+			}
+			c.document = treeEntries(lines)
+			c.isTree = true
+		case "#encoding":
+			c.encoding = strings.Join(lines, "")
+		}
+		lines = nil
+	}
+	for i, l := range strings.Split(string(data), "\n") {
+		switch l {
+		case "#data":
+			finish()
+			c = &datCase{line: i + 1}
+			cases = append(cases, c)
+			section = l
+			continue
+		case "#errors", "#document-fragment", "#document", "#script-on",
+			"#script-off", "#encoding":
+			finish()
+			section = l
+			continue
+		}
+		lines = append(lines, l)
+	}
+	finish()
+	return cases
+}
+
+// treeEntries joins the lines of an html5lib tree dump into one entry per
+// node. Text and comments can run over several lines.
+func treeEntries(lines []string) []string {
+	var entries []string
+	for _, l := range lines {
+		if strings.HasPrefix(l, "| ") || len(entries) == 0 {
+			entries = append(entries, strings.TrimPrefix(l, "| "))
+		} else {
+			entries[len(entries)-1] += "\n" + l
+		}
+	}
+	return entries
+}
+
+// dumpTree writes n in the html5lib tree dump format, one entry per node.
+func dumpTree(n *h5.Node, depth int, entries []string) []string {
+	indent := strings.Repeat("  ", depth)
+	switch n.Type {
+	case h5.DocumentNode:
+		for _, c := range n.Children {
+			entries = dumpTree(c, depth, entries)
+		}
+		return entries
+	case h5.DoctypeNode:
+		var public, system string
+		switch {
+		case n.Public:
+			public = string(n.Identifier)
+			system = string(n.SystemIdentifier)
+		case n.System:
+			system = string(n.Identifier)
+		}
+		if n.Public || n.System {
+			entries = append(entries, fmt.Sprintf("%s<!DOCTYPE %s \"%s\" \"%s\">",
+				indent, n.Data(), public, system))
+		} else {
+			entries = append(entries, fmt.Sprintf("%s<!DOCTYPE %s>", indent, n.Data()))
+		}
+	case h5.CommentNode:
+		entries = append(entries, fmt.Sprintf("%s<!-- %s -->", indent, n.Data()))
+	case h5.TextNode:
+		entries = append(entries, fmt.Sprintf("%s\"%s\"", indent, n.Data()))
+	case h5.ElementNode:
+		name := n.Data()
+		if n.Namespace != "" {
+			name = n.Namespace + " " + name
+		}
+		entries = append(entries, fmt.Sprintf("%s<%s>", indent, name))
+		attrs := make([]string, 0, len(n.Attr))
+		for _, a := range n.Attr {
+			name := a.Name
+			if a.Namespace != "" {
+				name = a.Namespace + " " + name
+			}
+			attrs = append(attrs, fmt.Sprintf("%s  %s=\"%s\"", indent, name, a.Value))
+		}
+		sort.Strings(attrs)
+		entries = append(entries, attrs...)
+	}
+	for _, c := range n.Children {
+		entries = dumpTree(c, depth+1, entries)
+	}
+	return entries
+}
+
+// A tally of the cases in a .dat file.
+type datResult struct {
+	passed, failed, skipped int
+}
+
 func runDatTests(ps []string) int {
 	var counter int
+	var total, encoding datResult
 	for _, p := range ps {
 		if *verbose {
 			fmt.Println("Running tests in file: ", p)
 		}
-		f, err := os.Open(p)
-		if err != nil {
-			fmt.Println("ERROR opening file: ", err)
-		}
-		data, err := ioutil.ReadAll(f)
+		data, err := ioutil.ReadFile(p)
 		if err != nil {
 			fmt.Println("ERROR reading file: ", err)
+			counter++
+			continue
+		}
+		var r datResult
+		for _, c := range readDatCases(data) {
+			if c.encoding != "" {
+				runEncodingCase(p, c, &r)
+				continue
+			}
+			switch {
+			case !c.isTree:
+				continue
+			case runDatCase(p, c):
+				r.passed++
+			default:
+				r.failed++
+			}
+		}
+		if r == (datResult{}) {
+			continue
 		}
-		cases := bytes.Split(data, []byte("\n\n"))
-		for _, c := range cases {
-			counter += runDatCase(c)
+		fmt.Printf("%s: %d passed, %d failed, %d skipped\n",
+			p, r.passed, r.failed, r.skipped)
+		t := &total
+		if strings.Contains(p, "encoding") {
+			t = &encoding
 		}
+		t.passed += r.passed
+		t.failed += r.failed
+		t.skipped += r.skipped
+		counter += r.failed
 	}
+	fmt.Printf("Tree construction: %d passed, %d failed, %d skipped\n",
+		total.passed, total.failed, total.skipped)
+	fmt.Printf("Encoding: %d passed, %d failed, %d skipped\n",
+		encoding.passed, encoding.failed, encoding.skipped)
 	return counter
 }
 
-func runDatCase(c []byte) int {
-	var counter int
+// runEncodingCase checks the encoding h5 finds the #data of a case is in
+// against its #encoding. Encodings h5 doesn't support are skipped.
+func runEncodingCase(file string, c *datCase, r *datResult) {
+	want := h5.EncodingName(c.encoding)
+	if want == "" {
+		r.skipped++
+		return
+	}
+	got, _ := h5.DetermineEncoding([]byte(c.data), "")
+	if got != want {
+		fmt.Printf("%s:%d: FAIL %q: encoding is %s, want %s\n",
+			file, c.line, c.data, got, want)
+		r.failed++
+		return
+	}
+	if *verbose {
+		fmt.Printf("%s:%d: SUCCESS!!!\n", file, c.line)
+	}
+	r.passed++
+}
+
+// runDatCase parses the #data of a case as a document, or as the contents of
+// its #document-fragment, and compares the tree with the #document node by
+// node. It reports whether they match.
+func runDatCase(file string, c *datCase) (ok bool) {
 	defer func() {
 		if e := recover(); e != nil {
-			fmt.Println("ERROR while running test case:", e)
-			counter++
+			fmt.Printf("%s:%d: ERROR while running test case: %v\n", file, c.line, e)
+			ok = false
 		}
 	}()
-	parts := bytes.Split(c, []byte("#"))
-	if len(parts) != 4 {
-		counter++
-	}
-	if len(parts) != 4 && *verbose {
-		fmt.Printf("Malformed test case: %d, %q\n", len(parts), string(c))
-		return counter
-	}
-	fmt.Println("Running test case:", string(c))
-	testData := make(map[string]string)
-	for _, p := range parts[1:] {
-		t := bytes.Split(p, []byte("\n"))
-		testData[string(t[0])] = string(t[1])
+	p := h5.NewParserFromString(c.data)
+	p.Document = true
+	p.Recover = true
+	if c.fragment != "" {
+		p.Context = h5.Element(c.fragment)
+		if f := strings.SplitN(c.fragment, " ", 2); len(f) == 2 {
+			// an svg or mathml context, like "svg path"
+			p.Context = h5.Element(f[1])
+			p.Context.Namespace = f[0]
+		}
 	}
-	p := h5.NewParserFromString(string(testData["data"]))
 	err := p.Parse()
 	if err != nil {
-		fmt.Println("Test case:", string(c))
-		fmt.Println("ERROR parsing: ", err)
-		counter++
+		fmt.Printf("%s:%d: ERROR parsing %q: %s\n", file, c.line, c.data, err)
+		return false
+	}
+	var got []string
+	if c.fragment != "" {
+		for _, n := range p.Fragment() {
+			got = dumpTree(n, 0, got)
+		}
 	} else {
+		got = dumpTree(p.Tree(), 0, nil)
+	}
+	for i := 0; i < len(got) || i < len(c.document); i++ {
+		var g, w string
+		if i < len(got) {
+			g = got[i]
+		}
+		if i < len(c.document) {
+			w = c.document[i]
+		}
+		if g == w {
+			continue
+		}
+		fmt.Printf("%s:%d: FAIL %q: node %d is %q, want %q\n",
+			file, c.line, c.data, i, g, w)
 		if *verbose {
-			fmt.Println("SUCCESS!!!")
+			fmt.Printf("got:\n| %s\nwant:\n| %s\n",
+				strings.Join(got, "\n| "), strings.Join(c.document, "\n| "))
 		}
+		return false
 	}
-	return counter
+	if *verbose {
+		fmt.Printf("%s:%d: SUCCESS!!!\n", file, c.line)
+	}
+	return true
+}
+
+// A test case from an html5lib tokenizer .test file.
+type testCase struct {
+	Description   string
+	Input         string
+	Output        []interface{}
+	InitialStates []string
+	DoubleEscaped bool
 }
 
 func runTestTests(ps []string) int {
 	var counter int
-	//for _, p := range ps {
-	//}
+	var total datResult
+	for _, p := range ps {
+		if filepath.Base(filepath.Dir(p)) != "tokenizer" {
+			continue // the validator suite tests something else
+		}
+		if *verbose {
+			fmt.Println("Running tests in file: ", p)
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			fmt.Println("ERROR reading file: ", err)
+			counter++
+			continue
+		}
+		var suite struct{ Tests []*testCase }
+		if err := json.Unmarshal(data, &suite); err != nil {
+			fmt.Println("ERROR reading file: ", err)
+			counter++
+			continue
+		}
+		var r datResult
+		for _, c := range suite.Tests {
+			switch {
+			case c.DoubleEscaped, !startsInData(c):
+				// TODO(jwall): cases starting in other states
+				r.skipped++
+			case runTestCase(p, c):
+				r.passed++
+			default:
+				r.failed++
+			}
+		}
+		if r == (datResult{}) {
+			continue
+		}
+		fmt.Printf("%s: %d passed, %d failed, %d skipped\n",
+			p, r.passed, r.failed, r.skipped)
+		total.passed += r.passed
+		total.failed += r.failed
+		total.skipped += r.skipped
+		counter += r.failed
+	}
+	fmt.Printf("Tokenizer: %d passed, %d failed, %d skipped\n",
+		total.passed, total.failed, total.skipped)
 	return counter
 }
 
+func startsInData(c *testCase) bool {
+	if c.InitialStates == nil {
+		return true
+	}
+	for _, s := range c.InitialStates {
+		if s == "Data state" {
+			return true
+		}
+	}
+	return false
+}
+
+// runTestCase tokenizes the input of a case and compares the tokens with the
+// output. Parse errors aren't compared and neither is the force-quirks flag
+// of doctypes. It reports whether they match.
+func runTestCase(file string, c *testCase) (ok bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			fmt.Printf("%s: ERROR while running test case %q: %v\n",
+				file, c.Description, e)
+			ok = false
+		}
+	}()
+	var got []interface{}
+	z := h5.NewTokenizerFromString(c.Input)
+	for {
+		t, err := z.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("%s: ERROR tokenizing %q: %s\n", file, c.Input, err)
+			return false
+		}
+		got = append(got, tokenEntry(t))
+	}
+	got = mergeCharacters(got)
+	want := mergeCharacters(c.Output)
+	if reflect.DeepEqual(got, want) {
+		if *verbose {
+			fmt.Printf("%s: %q SUCCESS!!!\n", file, c.Description)
+		}
+		return true
+	}
+	fmt.Printf("%s: FAIL %q: %q\n", file, c.Description, c.Input)
+	if *verbose {
+		fmt.Printf("got:  %v\nwant: %v\n", got, want)
+	}
+	return false
+}
+
+// tokenEntry represents a token the way the .test files do.
+func tokenEntry(t *h5.Token) []interface{} {
+	switch t.Type {
+	case h5.StartTagToken:
+		attrs := map[string]interface{}{}
+		for _, a := range t.Attr {
+			if _, ok := attrs[a.Name]; !ok {
+				attrs[a.Name] = a.Value
+			}
+		}
+		if t.SelfClosing {
+			return []interface{}{"StartTag", t.Data, attrs, true}
+		}
+		return []interface{}{"StartTag", t.Data, attrs}
+	case h5.EndTagToken:
+		return []interface{}{"EndTag", t.Data}
+	case h5.CommentToken:
+		return []interface{}{"Comment", t.Data}
+	case h5.DoctypeToken:
+		var name, public, system interface{}
+		if t.Data != "" {
+			name = t.Data
+		}
+		if t.Public {
+			public = t.Identifier
+		}
+		switch {
+		case t.Public && t.System:
+			system = t.SystemIdentifier
+		case t.System:
+			system = t.Identifier
+		}
+		return []interface{}{"DOCTYPE", name, public, system}
+	}
+	return []interface{}{"Character", t.Data}
+}
+
+// mergeCharacters drops the parse errors from a list of tokens and the
+// force-quirks flag from its doctypes, and joins adjacent characters.
+func mergeCharacters(ts []interface{}) []interface{} {
+	var merged []interface{}
+	for _, t := range ts {
+		e, ok := t.([]interface{})
+		if !ok {
+			continue // "ParseError"
+		}
+		switch e[0] {
+		case "DOCTYPE":
+			e = e[:4]
+		case "StartTag":
+			if len(e) == 4 && e[3] == false {
+				e = e[:3]
+			}
+		case "Character":
+			if k := len(merged); k > 0 {
+				if last := merged[k-1].([]interface{}); last[0] == "Character" {
+					merged[k-1] = []interface{}{"Character",
+						last[1].(string) + e[1].(string)}
+					continue
+				}
+			}
+		}
+		merged = append(merged, e)
+	}
+	return merged
+}
+
 func runHtmlTests(ps []string) int {
 	var counter int
 	// TODO(jwall): with timings?
@@ -95,9 +469,17 @@ func runHtmlTests(ps []string) int {
 		if err != nil {
 			fmt.Println("ERROR opening file: ", err)
 			counter++
+			continue
 		}
 		parse := h5.NewParser(f)
+		parse.Recover = true
 		err = parse.Parse()
+		f.Close()
+		for _, e := range parse.Errors {
+			if e.Code == "internal-error" && err == nil {
+				err = e
+			}
+		}
 		if err != nil {
 			if !*verbose {
 				fmt.Println("Attempting to parse file: ", p)
@@ -106,7 +488,7 @@ func runHtmlTests(ps []string) int {
 			counter++
 		} else {
 			if *verbose {
-				fmt.Println("SUCCESS!!!")
+				fmt.Printf("SUCCESS!!! with %d warnings\n", len(parse.Errors))
 			}
 		}
 	}
@@ -147,14 +529,14 @@ func main() {
 	if err != nil {
 		fmt.Println("ERROR while grepping", err)
 	}
-	specType := testSpec.String()
+	specType := testSpec.(*stringEnum).val
 	if specType == "all" || specType == "dat" {
 		counter += runDatTests(spec[datRe])
 	}
 	if specType == "all" || specType == "test" {
 		counter += runTestTests(spec[testRe])
 	}
-	if specType == "all" || specType == "dat" {
+	if specType == "all" || specType == "file" {
 		counter += runHtmlTests(spec[htmlRe])
 	}
 	if counter > 0 {
@@ -16,7 +16,7 @@ func (e *stringEnum) Set(v string) error {
 		e.val = v
 		return nil
 	}
-	return fmt.Errorf("Value %q not a valid enum value")
+	return fmt.Errorf("Value %q not a valid enum value", v)
 }
 
 func (e *stringEnum) String() string {
@@ -0,0 +1,136 @@
+/*
+Package alert checks price-alert rules against the results of a getev run.
+
+Rules are compared against a Snapshot of the current run and, for rules
+that look at change over time, the Snapshot saved by the previous run.
+
+	prev, _ := alert.LoadSnapshot("getev-state.json")
+	curr := alert.NewSnapshot(cards, ev.Pack(cards))
+	alerts := alert.Check(cfg.Rules, prev, curr)
+*/
+package alert
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The kinds of alert rules.
+const (
+	PriceAbove = "price-above" // a card's price is above Threshold
+	PriceBelow = "price-below" // a card's price is below Threshold
+	EVRise     = "ev-rise"     // the set EV rose more than Threshold percent
+	EVDrop     = "ev-drop"     // the set EV fell more than Threshold percent
+	PriceRatio = "price-ratio" // a card's price grew by a factor of Threshold
+)
+
+// Rule is a single alert rule. Card is only used by the per card rules, and
+// an empty Card on a PriceRatio rule matches every card in the set.
+type Rule struct {
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`
+	Card      string  `json:"card"`
+	Threshold float64 `json:"threshold"`
+}
+
+// Alert is a rule that fired.
+type Alert struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s: %s", a.Rule, a.Message)
+}
+
+// Validate reports whether the rule is well formed.
+func (r Rule) Validate() error {
+	switch r.Kind {
+	case PriceAbove, PriceBelow:
+		if r.Card == "" {
+			return fmt.Errorf("rule %q: %s needs a card", r.name(), r.Kind)
+		}
+	case EVRise, EVDrop:
+	case PriceRatio:
+		if r.Threshold <= 0 {
+			return fmt.Errorf("rule %q: %s needs a positive threshold",
+				r.name(), r.Kind)
+		}
+	default:
+		return fmt.Errorf("rule %q: unknown kind %q", r.name(), r.Kind)
+	}
+	return nil
+}
+
+func (r Rule) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	if r.Card != "" {
+		return fmt.Sprintf("%s %s %g", r.Card, r.Kind, r.Threshold)
+	}
+	return fmt.Sprintf("%s %g", r.Kind, r.Threshold)
+}
+
+// Check evaluates the rules against the current snapshot. prev is the
+// snapshot from the last run and may be nil, in which case rules that compare
+// runs never fire.
+func Check(rules []Rule, prev, curr *Snapshot) []Alert {
+	var alerts []Alert
+	for _, r := range rules {
+		alerts = append(alerts, r.check(prev, curr)...)
+	}
+	return alerts
+}
+
+func (r Rule) check(prev, curr *Snapshot) []Alert {
+	fire := func(format string, args ...interface{}) []Alert {
+		return []Alert{{Rule: r.name(), Message: fmt.Sprintf(format, args...)}}
+	}
+	switch r.Kind {
+	case PriceAbove:
+		if price, ok := curr.price(r.Card); ok && price > r.Threshold {
+			return fire("%s is $%.2f, above $%.2f", r.Card, price, r.Threshold)
+		}
+	case PriceBelow:
+		if price, ok := curr.price(r.Card); ok && price < r.Threshold {
+			return fire("%s is $%.2f, below $%.2f", r.Card, price, r.Threshold)
+		}
+	case EVRise:
+		if change, ok := evChange(prev, curr); ok && change > r.Threshold {
+			return fire("set EV rose %.1f%% to $%.2f", change, curr.EV)
+		}
+	case EVDrop:
+		if change, ok := evChange(prev, curr); ok && -change > r.Threshold {
+			return fire("set EV fell %.1f%% to $%.2f", -change, curr.EV)
+		}
+	case PriceRatio:
+		if prev == nil {
+			return nil
+		}
+		var alerts []Alert
+		for _, name := range curr.names() {
+			price := curr.Prices[name]
+			if r.Card != "" && !strings.EqualFold(r.Card, name) {
+				continue
+			}
+			old, ok := prev.price(name)
+			if !ok || old <= 0 || price < old*r.Threshold {
+				continue
+			}
+			alerts = append(alerts, fire("%s went from $%.2f to $%.2f",
+				name, old, price)...)
+		}
+		return alerts
+	}
+	return nil
+}
+
+// evChange returns the percentage change in set EV between two runs. Runs
+// with cards that could not be priced are not compared.
+func evChange(prev, curr *Snapshot) (float64, bool) {
+	if prev == nil || prev.EV <= 0 || prev.Partial || curr.Partial {
+		return 0, false
+	}
+	return (curr.EV - prev.EV) / prev.EV * 100, true
+}
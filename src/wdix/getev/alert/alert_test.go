@@ -0,0 +1,107 @@
+package alert
+
+import (
+	"path/filepath"
+	"testing"
+	"wdix/getev/pricefetch"
+)
+
+func snapshot(ev float64, prices map[string]float64) *Snapshot {
+	return &Snapshot{EV: ev, Prices: prices}
+}
+
+func TestCheck(t *testing.T) {
+	prev := snapshot(10, map[string]float64{
+		"Abrupt Decay":    10,
+		"Jace, Architect": 3,
+		"Pack Rat":        1,
+	})
+	curr := snapshot(12, map[string]float64{
+		"Abrupt Decay":    21,
+		"Jace, Architect": 3.5,
+		"Pack Rat":        0.5,
+	})
+	tests := []struct {
+		rule Rule
+		prev *Snapshot
+		want int
+	}{
+		{Rule{Kind: PriceAbove, Card: "abrupt decay", Threshold: 20}, prev, 1},
+		{Rule{Kind: PriceAbove, Card: "Pack Rat", Threshold: 20}, prev, 0},
+		{Rule{Kind: PriceAbove, Card: "Missing", Threshold: 0}, prev, 0},
+		{Rule{Kind: PriceBelow, Card: "Pack Rat", Threshold: 1}, prev, 1},
+		{Rule{Kind: EVRise, Threshold: 10}, prev, 1},
+		{Rule{Kind: EVRise, Threshold: 25}, prev, 0},
+		{Rule{Kind: EVRise, Threshold: 10}, nil, 0},
+		{Rule{Kind: EVDrop, Threshold: 10}, prev, 0},
+		{Rule{Kind: PriceRatio, Threshold: 2}, prev, 1},
+		{Rule{Kind: PriceRatio, Card: "Pack Rat", Threshold: 2}, prev, 0},
+		{Rule{Kind: PriceRatio, Threshold: 1.1}, prev, 2},
+		{Rule{Kind: PriceRatio, Threshold: 2}, nil, 0},
+	}
+	for _, test := range tests {
+		alerts := Check([]Rule{test.rule}, test.prev, curr)
+		if len(alerts) != test.want {
+			t.Errorf("%s: got %d alerts %v, want %d",
+				test.rule.name(), len(alerts), alerts, test.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := []Rule{
+		{Kind: PriceAbove, Threshold: 20},
+		{Kind: PriceRatio},
+		{Kind: "price-sideways"},
+	}
+	for _, r := range bad {
+		if err := r.Validate(); err == nil {
+			t.Errorf("%s: expected a validation error", r.name())
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	prev, err := LoadSnapshot(path)
+	if prev != nil || err != nil {
+		t.Fatalf("missing snapshot: got %v, %v", prev, err)
+	}
+	cards := []pricefetch.Card{{Name: "Pack Rat", Price: 1.5}}
+	if err := NewSnapshot(cards, 4.25).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.EV != 4.25 || s.Prices["Pack Rat"] != 1.5 {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestUnpricedCards(t *testing.T) {
+	prev := snapshot(10, map[string]float64{"Abrupt Decay": 10, "Pack Rat": 1})
+	cards := []pricefetch.Card{
+		{Name: "Abrupt Decay", Unpriced: true},
+		{Name: "Pack Rat", Price: 1.5},
+	}
+	// the failed lookup brings the EV down, counting Abrupt Decay as free
+	curr := NewSnapshot(cards, 5)
+	if _, ok := curr.Prices["Abrupt Decay"]; ok || !curr.Partial {
+		t.Fatalf("got %+v, want Abrupt Decay left out", curr)
+	}
+	rules := []Rule{
+		{Kind: PriceBelow, Card: "Abrupt Decay", Threshold: 5},
+		{Kind: EVDrop, Threshold: 10},
+		{Kind: PriceRatio, Card: "Abrupt Decay", Threshold: 1.1},
+	}
+	if alerts := Check(rules, prev, curr); len(alerts) != 0 {
+		t.Errorf("got %v, want no alerts", alerts)
+	}
+	// nor is the partial run's EV compared against by the next one
+	next := snapshot(10, map[string]float64{"Abrupt Decay": 10, "Pack Rat": 1})
+	if alerts := Check([]Rule{{Kind: EVRise, Threshold: 10}}, curr, next); len(alerts) != 0 {
+		t.Errorf("next run: got %v, want no alerts", alerts)
+	}
+}
@@ -0,0 +1,103 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+)
+
+// Config is the alert configuration file. For example:
+//
+//	{
+//		"rules": [
+//			{"kind": "price-above", "card": "Abrupt Decay", "threshold": 20},
+//			{"kind": "ev-rise", "threshold": 10},
+//			{"kind": "price-ratio", "threshold": 2}
+//		],
+//		"notifiers": [
+//			{"type": "stdout"},
+//			{"type": "webhook", "url": "http://localhost:8080/hook"}
+//		]
+//	}
+type Config struct {
+	Rules     []Rule           `json:"rules"`
+	Notifiers []NotifierConfig `json:"notifiers"`
+}
+
+// NotifierConfig describes a single notifier. Which fields are used depends on
+// Type, one of "stdout", "webhook" or "smtp".
+type NotifierConfig struct {
+	Type     string   `json:"type"`
+	URL      string   `json:"url"`
+	Addr     string   `json:"addr"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+}
+
+// LoadConfig reads and validates the alert configuration at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, r := range c.Rules {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	if _, err := c.Build(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Build constructs the configured notifiers. With no notifiers configured
+// alerts go to stdout.
+func (c *Config) Build() ([]Notifier, error) {
+	if len(c.Notifiers) == 0 {
+		return []Notifier{&StdoutNotifier{}}, nil
+	}
+	var notifiers []Notifier
+	for _, nc := range c.Notifiers {
+		n, err := nc.build()
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
+}
+
+func (nc NotifierConfig) build() (Notifier, error) {
+	switch nc.Type {
+	case "stdout":
+		return &StdoutNotifier{}, nil
+	case "webhook":
+		if nc.URL == "" {
+			return nil, fmt.Errorf("webhook notifier needs a url")
+		}
+		return &WebhookNotifier{URL: nc.URL}, nil
+	case "smtp":
+		if nc.Addr == "" || nc.From == "" || len(nc.To) == 0 {
+			return nil, fmt.Errorf("smtp notifier needs addr, from and to")
+		}
+		n := &SMTPNotifier{Addr: nc.Addr, From: nc.From, To: nc.To}
+		if nc.Username != "" {
+			host, _, err := net.SplitHostPort(nc.Addr)
+			if err != nil {
+				return nil, fmt.Errorf("smtp notifier: %s", err)
+			}
+			n.Auth = smtp.PlainAuth("", nc.Username, nc.Password, host)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", nc.Type)
+}
@@ -0,0 +1,101 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// A Notifier delivers the alerts raised by a run.
+type Notifier interface {
+	Notify(alerts []Alert) error
+}
+
+// StdoutNotifier writes one line per alert to W, or to os.Stdout if W is nil.
+type StdoutNotifier struct {
+	W io.Writer
+}
+
+func (n *StdoutNotifier) Notify(alerts []Alert) error {
+	w := n.W
+	if w == nil {
+		w = os.Stdout
+	}
+	for _, a := range alerts {
+		if _, err := fmt.Fprintln(w, "ALERT", a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WebhookNotifier POSTs the alerts as a JSON document to URL.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+type webhookPayload struct {
+	Alerts []Alert `json:"alerts"`
+}
+
+func (n *WebhookNotifier) Notify(alerts []Alert) error {
+	body, err := json.Marshal(webhookPayload{Alerts: alerts})
+	if err != nil {
+		return err
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s returned %s", n.URL, res.Status)
+	}
+	return nil
+}
+
+// SMTPNotifier mails the alerts through the SMTP server at Addr. Auth may be
+// nil for servers that accept unauthenticated mail.
+type SMTPNotifier struct {
+	Addr string
+	From string
+	To   []string
+	Auth smtp.Auth
+}
+
+func (n *SMTPNotifier) Notify(alerts []Alert) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: getev: %d price alert(s)\r\n", len(alerts))
+	fmt.Fprintf(&msg, "\r\n")
+	for _, a := range alerts {
+		fmt.Fprintf(&msg, "%s\r\n", a)
+	}
+	return smtp.SendMail(n.Addr, n.Auth, n.From, n.To, msg.Bytes())
+}
+
+// NotifyAll sends the alerts through every notifier, returning the first
+// error but still trying the rest.
+func NotifyAll(notifiers []Notifier, alerts []Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	var first error
+	for _, n := range notifiers {
+		if err := n.Notify(alerts); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,153 @@
+package alert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testAlerts = []Alert{
+	{Rule: "decay", Message: "Abrupt Decay is $21.00, above $20.00"},
+}
+
+func TestStdoutNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	n := &StdoutNotifier{W: &buf}
+	if err := n.Notify(testAlerts); err != nil {
+		t.Fatal(err)
+	}
+	want := "ALERT decay: Abrupt Decay is $21.00, above $20.00\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("content type: %q", r.Header.Get("Content-Type"))
+			}
+			if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+				t.Error(err)
+			}
+		}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{URL: srv.URL}
+	if err := n.Notify(testAlerts); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Alerts) != 1 || got.Alerts[0] != testAlerts[0] {
+		t.Errorf("got %v", got.Alerts)
+	}
+}
+
+func TestWebhookNotifierStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nope", http.StatusInternalServerError)
+		}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{URL: srv.URL}
+	if err := n.Notify(testAlerts); err == nil {
+		t.Error("expected an error for a 500 response")
+	}
+}
+
+// smtpStub accepts a single message and hands its body back on msgs.
+func smtpStub(t *testing.T) (addr string, msgs chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs = make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost stub")
+		var data bytes.Buffer
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					msgs <- data.String()
+					reply("250 ok")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return l.Addr().String(), msgs
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, msgs := smtpStub(t)
+	n := &SMTPNotifier{
+		Addr: addr,
+		From: "getev@example.com",
+		To:   []string{"team@example.com"},
+	}
+	if err := n.Notify(testAlerts); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-msgs
+	if !strings.Contains(msg, "Subject: getev: 1 price alert(s)") {
+		t.Errorf("missing subject in %q", msg)
+	}
+	if !strings.Contains(msg, testAlerts[0].String()) {
+		t.Errorf("missing alert in %q", msg)
+	}
+}
+
+func TestConfigBuild(t *testing.T) {
+	c := &Config{Notifiers: []NotifierConfig{
+		{Type: "stdout"},
+		{Type: "webhook", URL: "http://localhost/hook"},
+		{Type: "smtp", Addr: "localhost:25", From: "a@b", To: []string{"c@d"},
+			Username: "u", Password: "p"},
+	}}
+	ns, err := c.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 3 {
+		t.Errorf("got %d notifiers", len(ns))
+	}
+	c = &Config{Notifiers: []NotifierConfig{{Type: "webhook"}}}
+	if _, err := c.Build(); err == nil {
+		t.Error("expected an error for a webhook without a url")
+	}
+}
@@ -0,0 +1,88 @@
+package alert
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"wdix/getev/pricefetch"
+)
+
+// Snapshot records the prices and set EV seen by a single run.
+type Snapshot struct {
+	Time   time.Time          `json:"time"`
+	EV     float64            `json:"ev"`
+	Prices map[string]float64 `json:"prices"`
+	// Partial is set when some cards could not be priced. They are left out
+	// of Prices, and EV, which counted them as free, is not compared.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// NewSnapshot builds a Snapshot from the cards fetched in this run. Cards
+// that could not be priced are left out.
+func NewSnapshot(cards []pricefetch.Card, ev float64) *Snapshot {
+	s := &Snapshot{
+		Time:   time.Now(),
+		EV:     ev,
+		Prices: make(map[string]float64, len(cards)),
+	}
+	for _, card := range cards {
+		if card.Unpriced {
+			s.Partial = true
+			continue
+		}
+		s.Prices[card.Name] = card.Price
+	}
+	return s
+}
+
+// LoadSnapshot reads the snapshot saved at path. A missing file is not an
+// error and returns a nil Snapshot, as happens on the first run.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := new(Snapshot)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save writes the snapshot to path so that the next run can compare against
+// it.
+func (s *Snapshot) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// price looks up a card ignoring case, since rule files are written by hand.
+func (s *Snapshot) price(name string) (float64, bool) {
+	if price, ok := s.Prices[name]; ok {
+		return price, true
+	}
+	for n, price := range s.Prices {
+		if strings.EqualFold(n, name) {
+			return price, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Snapshot) names() []string {
+	names := make([]string, 0, len(s.Prices))
+	for name := range s.Prices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/moovweb/gokogiri/html"
+	"os"
+	"strings"
+	"wdix/getev/config"
+	"wdix/getev/fetch"
+)
+
+// runCheck fetches the canary cards of each source, and the checklists of
+// their sets, and reports any selector that no longer finds what it should:
+// getev check [-source name]. It returns false if a check failed.
+func runCheck(cfg *config.Config, args []string) bool {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	only := fs.String("source", "", "check only the named source")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Println("usage: getev check [-source name]")
+		os.Exit(2)
+	}
+
+	// A page from the cache says nothing about the site as it is now.
+	fetch.Default.Cache = nil
+
+	var sources []config.Source
+	for _, s := range cfg.Sources {
+		if *only == "" || strings.EqualFold(s.Name, *only) {
+			sources = append(sources, s)
+		}
+	}
+	if len(sources) == 0 {
+		fmt.Printf("FAIL  no source named %q\n", *only)
+		return false
+	}
+
+	ok := true
+	report := func(name string, err error) {
+		if err != nil {
+			fmt.Printf("FAIL  %s: %s\n", name, err)
+			ok = false
+			return
+		}
+		fmt.Printf("ok    %s\n", name)
+	}
+
+	// The canaries of every source, by set.
+	canaries := make(map[string][]string)
+	var codes []string
+	for _, s := range sources {
+		for _, c := range s.Canaries {
+			code := strings.ToUpper(c.Set)
+			if _, seen := canaries[code]; !seen {
+				codes = append(codes, code)
+			}
+			canaries[code] = append(canaries[code], c.Card)
+		}
+	}
+	for _, code := range codes {
+		set, err := cfg.FindSet(code)
+		if err != nil {
+			report("gatherer "+code, err)
+			continue
+		}
+		report("gatherer "+set.Code, checkChecklist(checklistUrl(set), canaries[code]))
+	}
+
+	for _, s := range sources {
+		if len(s.Canaries) == 0 {
+			fmt.Printf("skip  %s: no canaries\n", s.Name)
+			continue
+		}
+		src, err := s.Build(fetch.Default)
+		if err != nil {
+			report(s.Name, err)
+			continue
+		}
+		for _, c := range s.Canaries {
+			name := fmt.Sprintf("%s %s %s", s.Name, strings.ToUpper(c.Set), c.Card)
+			r, err := src.Check(c.Set, c.Card)
+			if err == nil {
+				err = checkPrice(r.Price, c)
+			}
+			if err == nil && r.Listings >= 0 {
+				name += fmt.Sprintf(" $%.2f, %d listings", r.Price, r.Listings)
+			} else if err == nil {
+				name += fmt.Sprintf(" $%.2f", r.Price)
+			}
+			report(name, err)
+		}
+	}
+	return ok
+}
+
+// checkPrice checks that a canary's price is plausible.
+func checkPrice(price float64, c config.Canary) error {
+	if price < c.Min || c.Max > 0 && price > c.Max {
+		bound := "no limit"
+		if c.Max > 0 {
+			bound = fmt.Sprintf("$%.2f", c.Max)
+		}
+		return fmt.Errorf("price $%.2f is outside $%.2f to %s", price, c.Min, bound)
+	}
+	return nil
+}
+
+// checkChecklist fetches the checklist at pageURL and checks that each of
+// the checklist selectors matches, and that the canary cards are listed.
+func checkChecklist(pageURL string, canaries []string) error {
+	page, err := fetch.Default.Get(pageURL)
+	if err != nil {
+		return err
+	}
+	doc, err := html.Parse(page, html.DefaultEncodingBytes, nil, html.DefaultParseOption, html.DefaultEncodingBytes)
+	if err != nil {
+		return err
+	}
+	defer doc.Free()
+
+	rows, err := doc.Root().Search(rowXPath)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("row selector %s matched nothing on %s", rowXPath, pageURL)
+	}
+	for _, xpath := range []string{nameXPath, rarityXPath, linkXPath} {
+		found := false
+		for _, row := range rows {
+			if nodes, err := row.Search(xpath); err == nil && len(nodes) > 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("selector %s matched nothing in %d rows on %s",
+				xpath, len(rows), pageURL)
+		}
+	}
+
+	entries, err := parseChecklist(page, pageURL)
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool)
+	for _, e := range entries {
+		listed[e.Name] = true
+	}
+	for _, name := range canaries {
+		if !listed[name] {
+			return fmt.Errorf("canary %q is not among the %d cards on %s",
+				name, len(entries), pageURL)
+		}
+	}
+	return nil
+}
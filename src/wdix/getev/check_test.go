@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"wdix/getev/config"
+)
+
+func TestCheckChecklist(t *testing.T) {
+	base := useFixtures(t)
+	tests := []struct {
+		page     string
+		canaries []string
+		want     string // in the error, empty for none
+	}{
+		{"/gatherer/checklist.html", []string{"Angel of Serenity", "Forest"}, ""},
+		{"/gatherer/checklist.html", []string{"Sphinx's Revelation"}, `canary "Sphinx's Revelation" is not among the 4 cards`},
+		{"/gatherer/no-results.html", nil, "row selector //tr[@class='cardItem'] matched nothing"},
+		{"/gatherer/redesigned.html", nil, "selector ./td[@class='name'] matched nothing in 1 rows"},
+		{"/gatherer/missing.html", nil, "404"},
+	}
+	for _, test := range tests {
+		err := checkChecklist(base+test.page, test.canaries)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: %s", test.page, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%s: got %v, want an error containing %q", test.page, err, test.want)
+		}
+	}
+}
+
+func TestCheckPrice(t *testing.T) {
+	tests := []struct {
+		price float64
+		c     config.Canary
+		ok    bool
+	}{
+		{10, config.Canary{Min: 1}, true},
+		{0, config.Canary{Min: 1}, false},
+		{10, config.Canary{Min: 0.01, Max: 5}, false},
+		{5, config.Canary{Min: 0.01, Max: 5}, true},
+	}
+	for _, test := range tests {
+		if err := checkPrice(test.price, test.c); (err == nil) != test.ok {
+			t.Errorf("$%v in %+v: got %v", test.price, test.c, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Package checkpoint records priced cards as they arrive, so that a run that
+fails or is interrupted can be resumed without fetching them again.
+
+A checkpoint file holds one JSON entry per line. Lines are only ever
+appended, so a run killed halfway through loses at most the line it was
+writing, which Load skips.
+*/
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"wdix/getev/pricefetch"
+)
+
+// Entry is a card priced from a source at a time.
+type Entry struct {
+	Time   time.Time       `json:"time"`
+	Source string          `json:"source"`
+	Card   pricefetch.Card `json:"card"`
+}
+
+// Writer appends entries to a checkpoint file. Its methods may be called
+// from several goroutines, and on a nil Writer, which records nothing.
+type Writer struct {
+	mu     sync.Mutex
+	f      *os.File
+	source string
+}
+
+// Create opens the checkpoint file at path for recording cards priced from
+// source, creating it if needed.
+func Create(path, source string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{f: f, source: source}, nil
+}
+
+// Record appends the card to the file.
+func (w *Writer) Record(card pricefetch.Card) error {
+	if w == nil {
+		return nil
+	}
+	data, err := json.Marshal(Entry{Time: time.Now(), Source: w.source, Card: card})
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.f.Write(append(data, '\n'))
+	return err
+}
+
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	return w.f.Close()
+}
+
+// Load reads the cards of set priced from source no earlier than since, by
+// name. Where a card was priced more than once the latest price wins. A
+// missing file is not an error and returns no cards.
+func Load(path, set, source string, since time.Time) (map[string]pricefetch.Card, error) {
+	cards := make(map[string]pricefetch.Card)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cards, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	latest := make(map[string]time.Time)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A line cut short by an interrupted run.
+			continue
+		}
+		if !strings.EqualFold(e.Card.Set, set) || !strings.EqualFold(e.Source, source) {
+			continue
+		}
+		if e.Time.Before(since) || e.Time.Before(latest[e.Card.Name]) {
+			continue
+		}
+		latest[e.Card.Name] = e.Time
+		cards[e.Card.Name] = e.Card
+	}
+	return cards, scanner.Err()
+}
@@ -0,0 +1,69 @@
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wdix/getev/pricefetch"
+)
+
+func TestRecordAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	w, err := Create(path, "tcgplayer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards := []pricefetch.Card{
+		{Name: "Angel of Serenity", Set: "RTR", Rarity: "M", Price: 10},
+		{Name: "Dramatic Rescue", Set: "RTR", Rarity: "C", Price: 0.15},
+		{Name: "Angel of Serenity", Set: "RTR", Rarity: "M", Price: 12},
+		{Name: "Boros Reckoner", Set: "GTC", Rarity: "R", Price: 8},
+	}
+	for _, c := range cards {
+		if err := w.Record(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	// An interrupted run leaves half a line behind.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"time":"2013-`)
+	f.Close()
+
+	got, err := Load(path, "rtr", "tcgplayer", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d cards, want 2: %+v", len(got), got)
+	}
+	if c := got["Angel of Serenity"]; c.Price != 12 || c.Rarity != "M" {
+		t.Errorf("Angel of Serenity: got %+v, want the later price", c)
+	}
+
+	if got, _ := Load(path, "RTR", "other", time.Time{}); len(got) != 0 {
+		t.Errorf("other source: got %+v", got)
+	}
+	if got, _ := Load(path, "RTR", "tcgplayer", time.Now().Add(time.Hour)); len(got) != 0 {
+		t.Errorf("stale entries: got %+v", got)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	got, err := Load(filepath.Join(t.TempDir(), "none.jsonl"), "RTR", "tcgplayer", time.Time{})
+	if err != nil || len(got) != 0 {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
+
+func TestNilWriter(t *testing.T) {
+	var w *Writer
+	if err := w.Record(pricefetch.Card{Name: "Forest"}); err != nil {
+		t.Error(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"wdix/getev/collection"
+	"wdix/getev/config"
+	"wdix/getev/pricing"
+)
+
+// runCollection values the collection in the CSV file named on the command
+// line: getev collection [-top n] [-rules pricing.json] inventory.csv
+func runCollection(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("collection", flag.ExitOnError)
+	top := fs.Int("top", 10, "number of top holdings to list")
+	concurrency := fs.Int("concurrency", cfg.Concurrency, "number of cards to price at once")
+	rulesPath := fs.String("rules", "", "condition and language pricing rules file")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("usage: getev collection [-top n] [-rules pricing.json] inventory.csv")
+		os.Exit(2)
+	}
+
+	rules := pricing.DefaultRules()
+	if *rulesPath != "" {
+		var err error
+		if rules, err = pricing.LoadRules(*rulesPath); err != nil {
+			slog.Error("loading pricing rules", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		slog.Error("opening collection", "err", err)
+		os.Exit(1)
+	}
+	lines, err := collection.Import(f)
+	f.Close()
+	if err != nil {
+		slog.Error("importing collection", "err", err)
+		os.Exit(1)
+	}
+
+	v := collection.Value(lines, collection.FetchPricer(rules), *concurrency)
+	fmt.Printf("Total value: $%.2f (%d lines, %d unpriced)\n",
+		v.Total, len(lines), len(v.Unpriced))
+	fmt.Println("Top holdings:")
+	for _, h := range v.Top(*top) {
+		fmt.Printf("  $%8.2f  %s @ $%.2f\n", h.Value, h.Line, h.Price)
+	}
+	if len(v.Unpriced) > 0 {
+		fmt.Println("Could not price:")
+		for _, u := range v.Unpriced {
+			fmt.Printf("  row %d: %s: %s\n", u.Row, u.Line, u.Err)
+		}
+	}
+}
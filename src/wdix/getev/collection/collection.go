@@ -0,0 +1,216 @@
+/*
+Package collection imports a card collection from CSV and values it.
+
+The CSV needs a header row. The name and quantity columns are required, set,
+foil, condition and language are optional:
+
+	name,set,quantity,foil,condition,language
+	Abrupt Decay,RTR,4,no,NM,EN
+	Pack Rat,RTR,1,yes,LP,JA
+
+Foil lines are not priced, as the price sources only quote non-foil copies.
+They are listed as unpriced instead of being counted in the total.
+*/
+package collection
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Line is a single row of an imported collection.
+type Line struct {
+	Row       int // the row in the CSV file, for error messages
+	Name      string
+	Set       string
+	Quantity  int
+	Foil      bool
+	Condition string
+	Language  string
+}
+
+func (l Line) String() string {
+	s := fmt.Sprintf("%dx %s", l.Quantity, l.Name)
+	if l.Set != "" {
+		s += fmt.Sprintf(" (%s)", l.Set)
+	}
+	if l.Foil {
+		s += " foil"
+	}
+	if l.Condition != "" {
+		s += " " + l.Condition
+	}
+	if l.Language != "" {
+		s += " " + l.Language
+	}
+	return s
+}
+
+// Import reads a collection from CSV.
+func Import(r io.Reader) ([]Line, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("collection: empty file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	for i, h := range header {
+		index[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, c := range []string{"name", "quantity"} {
+		if _, ok := index[c]; !ok {
+			return nil, fmt.Errorf("collection: missing %q column", c)
+		}
+	}
+
+	var lines []Line
+	for row := 2; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			i, ok := index[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		l := Line{
+			Row:       row,
+			Name:      field("name"),
+			Set:       field("set"),
+			Condition: strings.ToUpper(field("condition")),
+			Language:  strings.ToUpper(field("language")),
+		}
+		if l.Name == "" {
+			return nil, fmt.Errorf("collection: row %d: missing name", row)
+		}
+		l.Quantity, err = strconv.Atoi(field("quantity"))
+		if err != nil || l.Quantity < 0 {
+			return nil, fmt.Errorf("collection: row %d: bad quantity %q",
+				row, field("quantity"))
+		}
+		l.Foil, err = parseFoil(field("foil"))
+		if err != nil {
+			return nil, fmt.Errorf("collection: row %d: %s", row, err)
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+func parseFoil(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "", "no", "n", "false", "0", "nonfoil", "non-foil":
+		return false, nil
+	case "yes", "y", "true", "1", "foil":
+		return true, nil
+	}
+	return false, fmt.Errorf("bad foil value %q", s)
+}
+
+// A Pricer prices a single copy of the card on a line.
+type Pricer func(l Line) (float64, error)
+
+// Holding is a priced line.
+type Holding struct {
+	Line
+	Price float64 // the price of a single copy
+	Value float64 // Price times Quantity
+}
+
+// Unpriced is a line that could not be priced and why.
+type Unpriced struct {
+	Line
+	Err error
+}
+
+// Valuation is the result of pricing a collection.
+type Valuation struct {
+	Total    float64
+	Holdings []Holding // most valuable first
+	Unpriced []Unpriced
+}
+
+// Top returns the n most valuable holdings.
+func (v *Valuation) Top(n int) []Holding {
+	if n > len(v.Holdings) {
+		n = len(v.Holdings)
+	}
+	return v.Holdings[:n]
+}
+
+// Value prices every line of the collection, running at most concurrency
+// lookups at once.
+func Value(lines []Line, price Pricer, concurrency int) *Valuation {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	type result struct {
+		i     int
+		price float64
+		err   error
+	}
+	work := make(chan int)
+	results := make(chan result)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			for i := range work {
+				p, err := price(lines[i])
+				results <- result{i, p, err}
+			}
+		}()
+	}
+	go func() {
+		for i := range lines {
+			work <- i
+		}
+		close(work)
+	}()
+
+	v := new(Valuation)
+	for range lines {
+		r := <-results
+		l := lines[r.i]
+		if r.err != nil {
+			v.Unpriced = append(v.Unpriced, Unpriced{l, r.err})
+			continue
+		}
+		h := Holding{Line: l, Price: r.price, Value: r.price * float64(l.Quantity)}
+		v.Holdings = append(v.Holdings, h)
+		v.Total += h.Value
+	}
+	sort.Sort(byValue(v.Holdings))
+	sort.Sort(byRow(v.Unpriced))
+	return v
+}
+
+type byValue []Holding
+
+func (s byValue) Len() int      { return len(s) }
+func (s byValue) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byValue) Less(i, j int) bool {
+	if s[i].Value != s[j].Value {
+		return s[i].Value > s[j].Value
+	}
+	return s[i].Row < s[j].Row
+}
+
+type byRow []Unpriced
+
+func (s byRow) Len() int           { return len(s) }
+func (s byRow) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byRow) Less(i, j int) bool { return s[i].Row < s[j].Row }
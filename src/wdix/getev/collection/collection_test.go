@@ -0,0 +1,86 @@
+package collection
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const inventory = `Name,Set,Quantity,Foil,Condition,Language
+Abrupt Decay,RTR,4,no,nm,
+Pack Rat,RTR,1,yes,LP,ja
+Unknown Card,RTR,2,,,
+Pack Rat,Return to Ravnica,3,no,NM,EN
+`
+
+func TestImport(t *testing.T) {
+	lines, err := Import(strings.NewReader(inventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines", len(lines))
+	}
+	want := Line{Row: 3, Name: "Pack Rat", Set: "RTR", Quantity: 1, Foil: true,
+		Condition: "LP", Language: "JA"}
+	if lines[1] != want {
+		t.Errorf("got %+v, want %+v", lines[1], want)
+	}
+	if lines[0].Condition != "NM" {
+		t.Errorf("condition not normalized: %q", lines[0].Condition)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	bad := []string{
+		"",
+		"name,set\nPack Rat,RTR\n",
+		"name,quantity\nPack Rat,many\n",
+		"name,quantity,foil\nPack Rat,1,shiny\n",
+		"name,quantity\n,1\n",
+	}
+	for _, b := range bad {
+		if _, err := Import(strings.NewReader(b)); err == nil {
+			t.Errorf("%q: expected an error", b)
+		}
+	}
+}
+
+func TestValue(t *testing.T) {
+	lines, err := Import(strings.NewReader(inventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := map[string]float64{"Abrupt Decay": 10, "Pack Rat": 2}
+	pricer := func(l Line) (float64, error) {
+		if p, ok := prices[l.Name]; ok {
+			return p, nil
+		}
+		return 0, errors.New("no price")
+	}
+	v := Value(lines, pricer, 2)
+	if v.Total != 48 {
+		t.Errorf("total: got %v, want 48", v.Total)
+	}
+	if len(v.Holdings) != 3 || v.Holdings[0].Name != "Abrupt Decay" {
+		t.Errorf("holdings: %+v", v.Holdings)
+	}
+	if top := v.Top(10); len(top) != 3 {
+		t.Errorf("top: %+v", top)
+	}
+	if len(v.Unpriced) != 1 || v.Unpriced[0].Row != 4 {
+		t.Errorf("unpriced: %+v", v.Unpriced)
+	}
+}
+
+func TestFetchPricerFoil(t *testing.T) {
+	lines, err := Import(strings.NewReader(inventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the foil line is priced, so nothing is fetched
+	v := Value(lines[1:2], FetchPricer(nil), 1)
+	if v.Total != 0 || len(v.Unpriced) != 1 || v.Unpriced[0].Err != ErrFoil {
+		t.Errorf("got %+v", v)
+	}
+}
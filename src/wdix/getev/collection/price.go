@@ -0,0 +1,49 @@
+package collection
+
+import (
+	"errors"
+	"sync"
+	"wdix/getev/pricefetch"
+	"wdix/getev/pricing"
+)
+
+// ErrFoil is the error for foil lines. The price sources only quote non-foil
+// copies, and pricing a foil as one would undervalue it.
+var ErrFoil = errors.New("no foil prices")
+
+// FetchPricer returns a Pricer that looks cards up with pricefetch and adjusts
+// the price for the line's condition and language with rules. Each card is
+// only fetched once, however many lines it appears on. Foil lines are left
+// unpriced with ErrFoil.
+func FetchPricer(rules *pricing.Rules) Pricer {
+	type entry struct {
+		done  chan struct{}
+		quote pricefetch.Quote
+		err   error
+	}
+	var mu sync.Mutex
+	seen := make(map[string]*entry)
+	return func(l Line) (float64, error) {
+		if l.Foil {
+			return 0, ErrFoil
+		}
+		key := pricefetch.SetSlug(l.Set) + "/" + l.Name
+		mu.Lock()
+		e, ok := seen[key]
+		if !ok {
+			e = &entry{done: make(chan struct{})}
+			seen[key] = e
+		}
+		mu.Unlock()
+		if ok {
+			<-e.done
+		} else {
+			e.quote, e.err = pricefetch.FetchQuote(l.Set, l.Name)
+			close(e.done)
+		}
+		if e.err != nil {
+			return 0, e.err
+		}
+		return rules.Price(e.quote, l.Condition, l.Language)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"wdix/getev/config"
+	"wdix/getev/ev"
+	"wdix/getev/pricefetch"
+)
+
+// The number of cards named for each set in a comparison, and the number
+// whose share of the set's value is its concentration.
+const (
+	compareTop       = 3
+	concentrationTop = 5
+)
+
+// setSummary is a set's row in the comparison of several sets.
+type setSummary struct {
+	Set    string            `json:"set"`
+	Code   string            `json:"code"`
+	PackEV float64           `json:"packEV"`
+	BoxEV  float64           `json:"boxEV"`
+	Top    []pricefetch.Card `json:"top"`
+	// The share of the set's value held by its most valuable cards.
+	Concentration float64 `json:"concentration"`
+}
+
+// boxPacks returns the number of packs in the largest sealed product, the
+// box that the comparison values.
+func boxPacks(products []pricefetch.SealedProduct) int {
+	packs := pricefetch.BoosterBox.Packs
+	if len(products) > 0 {
+		packs = 0
+	}
+	for _, p := range products {
+		if p.Packs > packs {
+			packs = p.Packs
+		}
+	}
+	return packs
+}
+
+func summarize(r *report, packs int) setSummary {
+	cards := append([]pricefetch.Card(nil), r.Cards...)
+	sort.Sort(byPrice(cards))
+	if len(cards) > compareTop {
+		cards = cards[:compareTop]
+	}
+	return setSummary{
+		Set:           r.Set,
+		Code:          r.Code,
+		PackEV:        r.PackEV,
+		BoxEV:         r.PackEV * float64(packs),
+		Top:           cards,
+		Concentration: ev.Concentration(r.Cards, concentrationTop),
+	}
+}
+
+// writeComparison writes the report of each set followed by a table
+// comparing them.
+func writeComparison(w io.Writer, reports []*report, cfg *config.Config) error {
+	packs := boxPacks(cfg.EV.Products())
+	var summaries []setSummary
+	for _, r := range reports {
+		summaries = append(summaries, summarize(r, packs))
+	}
+
+	if cfg.Output.Format == config.JSON {
+		var trimmed []*report
+		for _, r := range reports {
+			trimmed = append(trimmed, r.trimmed(cfg.Output.Top))
+		}
+		data, err := json.MarshalIndent(struct {
+			Sets       []*report    `json:"sets"`
+			Comparison []setSummary `json:"comparison"`
+		}{trimmed, summaries}, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	for _, r := range reports {
+		if err := r.write(w, cfg.Output); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%-5s %9s %10s %8s  %s\n", "Set", "Pack EV", "Box EV", "Top 5", "Top cards")
+	for _, s := range summaries {
+		var top []string
+		for _, c := range s.Top {
+			top = append(top, fmt.Sprintf("%s $%.2f", c.Name, c.Price))
+		}
+		_, err := fmt.Fprintf(w, "%-5s %9s %10s %7.0f%%  %s\n", s.Code,
+			fmt.Sprintf("$%.2f", s.PackEV), fmt.Sprintf("$%.2f", s.BoxEV),
+			100*s.Concentration, strings.Join(top, ", "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"wdix/getev/config"
+	"wdix/getev/pricefetch"
+)
+
+func testReports() []*report {
+	return []*report{
+		{Set: "Return to Ravnica", Code: "RTR", PackEV: 3, Cards: []pricefetch.Card{
+			{Name: "Sphinx's Revelation", Price: 20},
+			{Name: "Abrupt Decay", Price: 15},
+			{Name: "Forest", Price: 0},
+			{Name: "Dramatic Rescue", Price: 0.15},
+		}},
+		{Set: "Gatecrash", Code: "GTC", PackEV: 2.5, Cards: []pricefetch.Card{
+			{Name: "Boros Reckoner", Price: 8},
+		}},
+	}
+}
+
+func TestWriteComparisonText(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := config.Default()
+	cfg.Output.Top = 1
+	if err := writeComparison(&buf, testReports(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"RTR       $3.00    $108.00     100%  Sphinx's Revelation $20.00, Abrupt Decay $15.00, Dramatic Rescue $0.15",
+		"GTC       $2.50     $90.00     100%  Boros Reckoner $8.00",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	// The report above the table is trimmed, the comparison is not.
+	if strings.Contains(out, "  $   15.00  Abrupt Decay") {
+		t.Errorf("report not trimmed to the top card:\n%s", out)
+	}
+}
+
+func TestWriteKeepsCards(t *testing.T) {
+	reports := testReports()
+	cfg := config.Default()
+	cfg.Output.Top = 1
+	for _, format := range []string{config.Text, config.JSON} {
+		cfg.Output.Format = format
+		if err := writeComparison(new(bytes.Buffer), reports, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if err := reports[0].write(new(bytes.Buffer), cfg.Output); err != nil {
+			t.Fatal(err)
+		}
+		// alerts are checked against every card after the reports are written
+		if len(reports[0].Cards) != 4 {
+			t.Errorf("%s: cards trimmed to %v", format, reports[0].Cards)
+		}
+	}
+}
+
+func TestWriteComparisonJSON(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := config.Default()
+	cfg.Output.Format = config.JSON
+	if err := writeComparison(&buf, testReports(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Sets       []report
+		Comparison []setSummary
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Sets) != 2 || len(got.Comparison) != 2 {
+		t.Fatalf("got %+v", got)
+	}
+	if c := got.Comparison[0]; c.Code != "RTR" || c.BoxEV != 108 || len(c.Top) != 3 || c.Top[0].Name != "Sphinx's Revelation" {
+		t.Errorf("RTR: got %+v", c)
+	}
+}
+
+func TestStatePathFor(t *testing.T) {
+	if got := statePathFor("RTR", 2); got != "getev-state-rtr.json" {
+		t.Errorf("RTR: got %q", got)
+	}
+	if got := statePathFor("GTC", 2); got != "getev-state-gtc.json" {
+		t.Errorf("GTC: got %q", got)
+	}
+	// a single set keeps the file runs used before sets could be batched
+	if got := statePathFor("RTR", 1); got != "getev-state.json" {
+		t.Errorf("RTR alone: got %q", got)
+	}
+}
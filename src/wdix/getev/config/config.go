@@ -0,0 +1,480 @@
+/*
+Package config loads the getev configuration file.
+
+The file is JSON. Anything it leaves out keeps the built in default, so a
+config only needs the settings it changes:
+
+	{
+		"sets": [
+			{"code": "GTC", "name": "Gatecrash", "slug": "gatecrash"}
+		],
+		"set": "GTC",
+		"http": {"timeout": "10s", "userAgent": "getev", "retries": 3},
+		"concurrency": 4,
+		"output": {"format": "json"}
+	}
+*/
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"wdix/getev/ev"
+	"wdix/getev/fetch"
+	"wdix/getev/pricefetch"
+)
+
+// Config is the whole getev configuration.
+type Config struct {
+	Sets        []Set      `json:"sets"`
+	Sources     []Source   `json:"sources"`
+	Set         string     `json:"set"`    // the codes of the sets to price, as "RTR,GTC"
+	Source      string     `json:"source"` // the name of the source to price with
+	HTTP        HTTP       `json:"http"`
+	Concurrency int        `json:"concurrency"` // cards fetched at once
+	Enrich      bool       `json:"enrich"`      // fetch Gatherer details too
+	EV          EV         `json:"ev"`
+	Output      Output     `json:"output"`
+	Log         Log        `json:"log"`
+	Checkpoint  Checkpoint `json:"checkpoint"`
+	// Where to serve Prometheus metrics at /metrics, empty for nowhere.
+	MetricsAddr string `json:"metricsAddr"`
+}
+
+// Set is a card set.
+type Set struct {
+	Code string `json:"code"` // "RTR"
+	Name string `json:"name"` // "Return to Ravnica", as Gatherer names it
+	Slug string `json:"slug"` // "return-to-ravnica", as price sources name it
+	// The Gatherer checklist url, built from Name if empty.
+	Checklist string `json:"checklist"`
+}
+
+// Source is a price source. Its urls may use {set}, {card} and {product},
+// and its selectors are regular expressions with named groups.
+type Source struct {
+	Name              string `json:"name"`
+	CardURL           string `json:"cardUrl"`
+	SealedURL         string `json:"sealedUrl"`
+	PriceSelector     string `json:"priceSelector"`
+	ConditionSelector string `json:"conditionSelector"`
+	// Cards that getev check prices to tell whether the selectors still work.
+	Canaries []Canary `json:"canaries"`
+	// Sent with each request to the source.
+	Headers map[string]string `json:"headers,omitempty"`
+	Cookies map[string]string `json:"cookies,omitempty"`
+}
+
+// Canary is a card whose price should stay between Min and Max. A zero Max
+// leaves the price unbounded above.
+type Canary struct {
+	Set  string  `json:"set"`
+	Card string  `json:"card"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+}
+
+// HTTP holds the HTTP client settings.
+type HTTP struct {
+	Timeout   Duration `json:"timeout"`
+	UserAgent string   `json:"userAgent"`
+	Retries   int      `json:"retries"`
+	Backoff   Duration `json:"backoff"`
+	// Fetched pages are kept in CacheDir for CacheMaxAge, or a day if it is
+	// zero. With no CacheDir pages are only kept for the run.
+	CacheDir    string   `json:"cacheDir"`
+	CacheMaxAge Duration `json:"cacheMaxAge"`
+	// Whether to follow each host's robots.txt, and the least time to leave
+	// between requests to a host. A longer Crawl-delay in robots.txt wins.
+	Robots      bool     `json:"robots"`
+	MinInterval Duration `json:"minInterval"`
+	// Proxy urls to send requests through in turn. With none, the proxy in
+	// the HTTP_PROXY and HTTPS_PROXY environment variables is used.
+	Proxies []string `json:"proxies,omitempty"`
+	// A PEM file of extra certificate authorities to trust, as for a proxy
+	// that inspects TLS.
+	CABundle string `json:"caBundle,omitempty"`
+	// Whether to convert fetched html pages to utf-8 from the encoding
+	// they are in, worked out the way a browser does.
+	Decode bool `json:"decode"`
+}
+
+// EV holds the parameters of the EV calculation.
+type EV struct {
+	PackSize int       `json:"packSize"` // cards in a booster pack
+	Sealed   []Product `json:"sealed"`   // sealed products to compare against
+	// Pack layouts by set code, in place of the built in ones. Sets with no
+	// layout, or whose checklist has no rarities, use PackSize cards drawn
+	// uniformly.
+	Layouts map[string]*ev.Layout `json:"layouts,omitempty"`
+	// The number of packs to open at random as a check on the EV, 0 for none.
+	Simulate int `json:"simulate"`
+}
+
+// Product is a sealed product.
+type Product struct {
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Packs int    `json:"packs"`
+}
+
+// Output holds the output preferences.
+type Output struct {
+	Format string `json:"format"` // "text" or "json"
+	Top    int    `json:"top"`    // how many cards to list, 0 for all
+}
+
+// Checkpoint says where priced cards are recorded as they arrive, and how
+// old they may be for a resumed run to use them.
+type Checkpoint struct {
+	Path   string   `json:"path"` // empty to not record
+	MaxAge Duration `json:"maxAge"`
+}
+
+// Log holds the logging settings. Logs go to stderr.
+type Log struct {
+	Level  string `json:"level"`  // "debug", "info", "warn" or "error"
+	Format string `json:"format"` // "text" or "json"
+}
+
+// Duration is a time.Duration written as a string such as "30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations are strings such as \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// The output formats.
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// Default returns the built in configuration.
+func Default() *Config {
+	tcg := pricefetch.TCGplayer
+	return &Config{
+		Sets: []Set{
+			{Code: "RTR", Name: "Return to Ravnica", Slug: "return-to-ravnica"},
+			{Code: "GTC", Name: "Gatecrash", Slug: "gatecrash"},
+			{Code: "DGM", Name: "Dragon's Maze", Slug: "dragons-maze"},
+		},
+		Sources: []Source{{
+			Name:              tcg.Name,
+			CardURL:           tcg.CardURL,
+			SealedURL:         tcg.SealedURL,
+			PriceSelector:     tcg.Price.String(),
+			ConditionSelector: tcg.Conditions.String(),
+			Canaries: []Canary{
+				{Set: "RTR", Card: "Sphinx's Revelation", Min: 1},
+				{Set: "RTR", Card: "Dramatic Rescue", Min: 0.01, Max: 5},
+				{Set: "GTC", Card: "Boros Reckoner", Min: 0.5},
+			},
+		}},
+		Set:    "RTR",
+		Source: tcg.Name,
+		HTTP: HTTP{
+			Timeout:     Duration(30 * time.Second),
+			UserAgent:   "getev/1.0",
+			Retries:     2,
+			Backoff:     Duration(time.Second),
+			Robots:      true,
+			MinInterval: Duration(500 * time.Millisecond),
+		},
+		Concurrency: 8,
+		EV: EV{
+			PackSize: 15,
+			Sealed: []Product{
+				{Name: "Booster Pack", Slug: "booster-pack", Packs: 1},
+				{Name: "Booster Box", Slug: "booster-box", Packs: 36},
+				{Name: "Fat Pack", Slug: "fat-pack", Packs: 8},
+			},
+		},
+		Output: Output{Format: Text},
+		Log:    Log{Level: "info", Format: Text},
+		Checkpoint: Checkpoint{
+			Path:   "getev-checkpoint.jsonl",
+			MaxAge: Duration(24 * time.Hour),
+		},
+	}
+}
+
+// Load reads the config file at path on top of the defaults and validates
+// the result.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := Default()
+	// Lists in the file replace the default lists rather than merging into
+	// them, so clear them first.
+	var lists struct {
+		Sets    json.RawMessage `json:"sets"`
+		Sources json.RawMessage `json:"sources"`
+		Set     *string         `json:"set"`
+		Source  *string         `json:"source"`
+		EV      struct {
+			Sealed json.RawMessage `json:"sealed"`
+		} `json:"ev"`
+	}
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if lists.Sets != nil {
+		c.Sets = nil
+	}
+	if lists.Sources != nil {
+		c.Sources = nil
+	}
+	if lists.EV.Sealed != nil {
+		c.EV.Sealed = nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	// A file with its own lists but no selection uses the first of each.
+	if lists.Sets != nil && lists.Set == nil && len(c.Sets) > 0 {
+		c.Set = c.Sets[0].Code
+	}
+	if lists.Sources != nil && lists.Source == nil && len(c.Sources) > 0 {
+		c.Source = c.Sources[0].Name
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks the config for mistakes, returning an error that names the
+// offending setting.
+func (c *Config) Validate() error {
+	if len(c.Sets) == 0 {
+		return fmt.Errorf("sets: at least one set is needed")
+	}
+	codes := make(map[string]bool)
+	for i, s := range c.Sets {
+		switch {
+		case s.Code == "":
+			return fmt.Errorf("sets[%d]: missing code", i)
+		case s.Name == "" && s.Checklist == "":
+			return fmt.Errorf("sets[%d] (%s): needs a name or a checklist url", i, s.Code)
+		case codes[strings.ToUpper(s.Code)]:
+			return fmt.Errorf("sets[%d]: duplicate code %q", i, s.Code)
+		}
+		codes[strings.ToUpper(s.Code)] = true
+	}
+	if _, err := c.SelectedSets(); err != nil {
+		return fmt.Errorf("set: %s", err)
+	}
+
+	if len(c.Sources) == 0 {
+		return fmt.Errorf("sources: at least one source is needed")
+	}
+	for i, s := range c.Sources {
+		if s.Name == "" {
+			return fmt.Errorf("sources[%d]: missing name", i)
+		}
+		if !strings.Contains(s.CardURL, "{card}") {
+			return fmt.Errorf("sources[%d] (%s): cardUrl must contain {card}", i, s.Name)
+		}
+		if _, err := s.compile(); err != nil {
+			return fmt.Errorf("sources[%d] (%s): %s", i, s.Name, err)
+		}
+		for j, k := range s.Canaries {
+			switch {
+			case k.Card == "":
+				return fmt.Errorf("sources[%d].canaries[%d]: missing card", i, j)
+			case k.Min < 0 || k.Max < 0:
+				return fmt.Errorf("sources[%d].canaries[%d] (%s): prices must not be negative", i, j, k.Card)
+			case k.Max > 0 && k.Max < k.Min:
+				return fmt.Errorf("sources[%d].canaries[%d] (%s): max is below min", i, j, k.Card)
+			}
+		}
+	}
+	if _, err := c.FindSource(c.Source); err != nil {
+		return fmt.Errorf("source: %s", err)
+	}
+
+	if c.HTTP.Timeout < 0 {
+		return fmt.Errorf("http.timeout: must not be negative")
+	}
+	if c.HTTP.Retries < 0 {
+		return fmt.Errorf("http.retries: must not be negative")
+	}
+	if c.HTTP.CacheMaxAge < 0 {
+		return fmt.Errorf("http.cacheMaxAge: must not be negative")
+	}
+	if c.HTTP.MinInterval < 0 {
+		return fmt.Errorf("http.minInterval: must not be negative")
+	}
+	if strings.TrimSpace(c.HTTP.UserAgent) == "" {
+		return fmt.Errorf("http.userAgent: must identify getev")
+	}
+	for i, p := range c.HTTP.Proxies {
+		if u, err := url.Parse(p); err != nil || u.Host == "" {
+			return fmt.Errorf("http.proxies[%d]: %q is not a url", i, p)
+		}
+	}
+	if c.Concurrency < 1 {
+		return fmt.Errorf("concurrency: must be at least 1, got %d", c.Concurrency)
+	}
+	if c.EV.PackSize < 1 {
+		return fmt.Errorf("ev.packSize: must be at least 1, got %d", c.EV.PackSize)
+	}
+	for code, l := range c.EV.Layouts {
+		if l == nil {
+			return fmt.Errorf("ev.layouts[%s]: missing layout", code)
+		}
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("ev.layouts[%s]: %s", code, err)
+		}
+	}
+	if c.EV.Simulate < 0 {
+		return fmt.Errorf("ev.simulate: must not be negative")
+	}
+	for i, p := range c.EV.Sealed {
+		if p.Name == "" || p.Slug == "" {
+			return fmt.Errorf("ev.sealed[%d]: needs a name and a slug", i)
+		}
+		if p.Packs < 1 {
+			return fmt.Errorf("ev.sealed[%d] (%s): packs must be at least 1", i, p.Name)
+		}
+	}
+	switch c.Output.Format {
+	case Text, JSON:
+	default:
+		return fmt.Errorf("output.format: must be %q or %q, got %q",
+			Text, JSON, c.Output.Format)
+	}
+	if c.Output.Top < 0 {
+		return fmt.Errorf("output.top: must not be negative")
+	}
+	if c.Checkpoint.MaxAge < 0 {
+		return fmt.Errorf("checkpoint.maxAge: must not be negative")
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log.level: must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	switch c.Log.Format {
+	case Text, JSON:
+	default:
+		return fmt.Errorf("log.format: must be %q or %q, got %q", Text, JSON, c.Log.Format)
+	}
+	return nil
+}
+
+// FindSet returns the set with the given code.
+func (c *Config) FindSet(code string) (*Set, error) {
+	for i := range c.Sets {
+		if strings.EqualFold(c.Sets[i].Code, code) {
+			return &c.Sets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no set with code %q", code)
+}
+
+// SelectedSets returns the sets named in Set, in order.
+func (c *Config) SelectedSets() ([]*Set, error) {
+	var sets []*Set
+	seen := make(map[*Set]bool)
+	for _, code := range strings.Split(c.Set, ",") {
+		set, err := c.FindSet(strings.TrimSpace(code))
+		if err != nil {
+			return nil, err
+		}
+		if !seen[set] {
+			seen[set] = true
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+// FindSource returns the source with the given name.
+func (c *Config) FindSource(name string) (*Source, error) {
+	for i := range c.Sources {
+		if strings.EqualFold(c.Sources[i].Name, name) {
+			return &c.Sources[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no source named %q", name)
+}
+
+// Build compiles the source's selectors. The source fetches with base, plus
+// its own headers and cookies.
+func (s *Source) Build(base *fetch.Fetcher) (*pricefetch.Source, error) {
+	src, err := s.compile()
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Headers) == 0 && len(s.Cookies) == 0 {
+		src.Fetcher = base
+		return src, nil
+	}
+	header := make(http.Header)
+	for k, v := range s.Headers {
+		header.Set(k, v)
+	}
+	var names []string
+	for name := range s.Cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var cookies []*http.Cookie
+	for _, name := range names {
+		cookies = append(cookies, &http.Cookie{Name: name, Value: s.Cookies[name]})
+	}
+	src.Fetcher = base.With(header, cookies)
+	return src, nil
+}
+
+// compile compiles the source's selectors into a source with no fetcher.
+func (s *Source) compile() (*pricefetch.Source, error) {
+	return pricefetch.NewSource(s.Name, s.CardURL, s.SealedURL,
+		s.PriceSelector, s.ConditionSelector)
+}
+
+// Layout returns the pack layout of the set with the given code, or nil if
+// there is none.
+func (e *EV) Layout(code string) *ev.Layout {
+	for c, l := range e.Layouts {
+		if strings.EqualFold(c, code) {
+			return l
+		}
+	}
+	return ev.Layouts[strings.ToUpper(code)]
+}
+
+// Products returns the sealed products to price.
+func (e *EV) Products() []pricefetch.SealedProduct {
+	var ps []pricefetch.SealedProduct
+	for _, p := range e.Sealed {
+		ps = append(ps, pricefetch.SealedProduct{Name: p.Name, Slug: p.Slug, Packs: p.Packs})
+	}
+	return ps
+}
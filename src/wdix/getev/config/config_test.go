@@ -0,0 +1,170 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wdix/getev/fetch"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "getev.json")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `{
+		"sets": [{"code": "GTC", "name": "Gatecrash"}],
+		"http": {"timeout": "10s", "userAgent": "getev-test", "decode": true},
+		"concurrency": 4,
+		"output": {"format": "json", "top": 20}
+	}`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Sets) != 1 || c.Set != "GTC" {
+		t.Errorf("sets: %+v, selected %q", c.Sets, c.Set)
+	}
+	if time.Duration(c.HTTP.Timeout) != 10*time.Second || c.HTTP.UserAgent != "getev-test" {
+		t.Errorf("http: %+v", c.HTTP)
+	}
+	if !c.HTTP.Decode || Default().HTTP.Decode {
+		t.Errorf("decode: got %v", c.HTTP.Decode)
+	}
+	if c.HTTP.Retries != Default().HTTP.Retries {
+		t.Errorf("retries lost their default: %d", c.HTTP.Retries)
+	}
+	if c.Concurrency != 4 || c.Output.Format != JSON || c.Output.Top != 20 {
+		t.Errorf("got %+v", c)
+	}
+	if len(c.Sources) != 1 || c.Source != "tcgplayer" {
+		t.Errorf("sources: %+v", c.Sources)
+	}
+}
+
+func TestLoadSourceHeaders(t *testing.T) {
+	path := writeConfig(t, `{
+		"sources": [{
+			"name": "tcgplayer",
+			"cardUrl": "http://store.tcgplayer.com/magic/{set}/{card}",
+			"priceSelector": "(?P<price>[0-9.]+)",
+			"headers": {"Referer": "http://store.tcgplayer.com/"},
+			"cookies": {"session": "abc"}
+		}]
+	}`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Sources[0]; s.Headers["Referer"] == "" || s.Cookies["session"] != "abc" {
+		t.Errorf("source: %+v", s)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		config, want string
+	}{
+		{`{"concurrency": 0}`, "concurrency: must be at least 1"},
+		{`{"set": "XYZ"}`, `set: no set with code "XYZ"`},
+		{`{"sets": [{"name": "Gatecrash"}]}`, "sets[0]: missing code"},
+		{`{"http": {"timeout": 10}}`, "durations are strings"},
+		{`{"output": {"format": "xml"}}`, "output.format"},
+		{`{"log": {"level": "verbose"}}`, "log.level"},
+		{`{"http": {"proxies": ["proxy.example:3128"]}}`, "http.proxies[0]"},
+		{`{"concurency": 4}`, `unknown field "concurency"`},
+		{`{"sources": [{"name": "x", "cardUrl": "http://x/{card}",
+			"priceSelector": "<td>(.*)</td>"}]}`,
+			"sources[0] (x): price selector"},
+		{`{"sources": [{"name": "x", "cardUrl": "http://x/",
+			"priceSelector": "(?P<price>.*)"}]}`,
+			"cardUrl must contain {card}"},
+		{`{"ev": {"sealed": [{"name": "Box", "slug": "box"}]}}`,
+			"ev.sealed[0] (Box): packs must be at least 1"},
+		{`{"sources": [{"name": "x", "cardUrl": "http://x/{card}",
+			"priceSelector": "(?P<price>.*)",
+			"canaries": [{"set": "RTR", "card": "Forest", "min": 2, "max": 1}]}]}`,
+			"sources[0].canaries[0] (Forest): max is below min"},
+		{`{"ev": {"layouts": {"RTR": {"name": "x", "slots": [
+			{"name": "rare", "count": 1, "pools": [{"sheet": "rares"}]}]}}}}`,
+			`ev.layouts[RTR]: layout x: slot rare: no sheet named "rares"`},
+	}
+	for _, test := range tests {
+		_, err := Load(writeConfig(t, test.config))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error containing %q",
+				test.config, err, test.want)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	path := writeConfig(t, `{"ev": {"layouts": {"gtc": {"name": "gtc", "slots": [
+		{"name": "rare", "count": 1, "pools": [{"rarity": "R"}]}]}}}}`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := c.EV.Layout("GTC"); l == nil || l.Name != "gtc" {
+		t.Errorf("GTC: got %+v, want the configured layout", l)
+	}
+	if l := c.EV.Layout("DGM"); l == nil || l.Name != "dgm" {
+		t.Errorf("DGM: got %+v, want the built in layout", l)
+	}
+	if l := c.EV.Layout("XYZ"); l != nil {
+		t.Errorf("XYZ: got %+v, want none", l)
+	}
+}
+
+func TestSelectedSets(t *testing.T) {
+	c := Default()
+	c.Set = "rtr, DGM,RTR"
+	sets, err := c.SelectedSets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 2 || sets[0].Code != "RTR" || sets[1].Code != "DGM" {
+		t.Errorf("got %+v", sets)
+	}
+	c.Set = "RTR,,GTC"
+	if _, err := c.SelectedSets(); err == nil {
+		t.Error("expected an error for an empty code")
+	}
+}
+
+func TestSourceBuild(t *testing.T) {
+	s := Default().Sources[0]
+	base := &fetch.Fetcher{UserAgent: "getev-test"}
+	src, err := s.Build(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Fetcher != base {
+		t.Error("a source with no headers should use the base fetcher")
+	}
+
+	s.Headers = map[string]string{"referer": "http://store.tcgplayer.com/"}
+	s.Cookies = map[string]string{"b": "2", "a": "1"}
+	if src, err = s.Build(base); err != nil {
+		t.Fatal(err)
+	}
+	f := src.Fetcher
+	if f.Header.Get("Referer") != "http://store.tcgplayer.com/" || f.UserAgent != "getev-test" {
+		t.Errorf("got %+v", f)
+	}
+	if len(f.Cookies) != 2 || f.Cookies[0].Name != "a" {
+		t.Errorf("cookies: got %v", f.Cookies)
+	}
+}
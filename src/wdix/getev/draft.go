@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"wdix/getev/config"
+	"wdix/getev/ev"
+	"wdix/getev/metrics"
+	"wdix/getev/pricefetch"
+)
+
+// runDraft values a draft's packs for each player and for the pod:
+// getev draft [-players n] GTC-GTC-RTR, or getev draft -file draft.json
+func runDraft(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("draft", flag.ExitOnError)
+	players := fs.Int("players", 0, "players in the pod, overrides the draft file")
+	file := fs.String("file", "", "draft file listing the players and the packs")
+	fs.Parse(args)
+	if (*file == "" && fs.NArg() != 1) || (*file != "" && fs.NArg() != 0) {
+		fmt.Println("usage: getev draft [-players n] GTC-GTC-RTR | -file draft.json")
+		os.Exit(2)
+	}
+
+	var d *ev.Draft
+	var err error
+	if *file != "" {
+		d, err = ev.LoadDraft(*file)
+	} else {
+		d, err = ev.ParseDraft(fs.Arg(0))
+	}
+	if err == nil && *players != 0 {
+		d.Players = *players
+		err = d.Validate()
+	}
+	if err != nil {
+		slog.Error("reading draft", "err", err)
+		os.Exit(1)
+	}
+
+	// Each set is priced once however many of its packs are opened.
+	packEV := make(map[string]float64)
+	for _, code := range d.Sets() {
+		set, err := cfg.FindSet(code)
+		if err != nil {
+			slog.Error("reading draft", "err", err)
+			os.Exit(1)
+		}
+		// a draft only needs the packs, not sealed product prices
+		packEV[code] = pricePacks(cfg, pricefetch.Default, set).PackEV
+	}
+	v, err := d.Value(packEV)
+	if err != nil {
+		slog.Error("valuing draft", "err", err)
+		os.Exit(1)
+	}
+	if err := writeDraft(os.Stdout, d, v, cfg.Output); err != nil {
+		slog.Error("writing draft", "err", err)
+	}
+	metrics.Default.WriteSummary(os.Stderr)
+}
+
+func writeDraft(w io.Writer, d *ev.Draft, v ev.DraftValue, out config.Output) error {
+	if out.Format == config.JSON {
+		data, err := json.MarshalIndent(v, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	fmt.Fprintf(w, "%d players\n", d.Players)
+	fmt.Fprintf(w, "%-5s %-4s %9s %6s %10s\n", "Pack", "Set", "EV", "Share", "Pod")
+	for _, p := range v.Positions {
+		fmt.Fprintf(w, "%-5d %-4s %9s %5.0f%% %10s\n", p.Pack, p.Set,
+			fmt.Sprintf("$%.2f", p.EV), 100*p.Share, fmt.Sprintf("$%.2f", p.PodEV))
+	}
+	_, err := fmt.Fprintf(w, "Per player: $%.2f\nPer pod: $%.2f\n", v.Player, v.Pod)
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"wdix/getev/config"
+	"wdix/getev/ev"
+	"wdix/getev/fetch/fetchtest"
+	"wdix/getev/pricefetch"
+)
+
+func TestWriteDraft(t *testing.T) {
+	d := &ev.Draft{Players: 8, Packs: []string{"GTC", "GTC", "RTR"}}
+	v, err := d.Value(map[string]float64{"GTC": 2, "RTR": 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeDraft(&buf, d, v, config.Output{Format: config.Text}); err != nil {
+		t.Fatal(err)
+	}
+	want := `8 players
+Pack  Set         EV  Share        Pod
+1     GTC      $2.00    25%     $16.00
+2     GTC      $2.00    25%     $16.00
+3     RTR      $4.00    50%     $32.00
+Per player: $8.00
+Per pod: $64.00
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestPricePacksSkipsSealed(t *testing.T) {
+	base := useFixtures(t)
+	var sealedRequests int32
+	sealedSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sealedRequests, 1)
+		http.NotFound(w, r)
+	}))
+	defer sealedSrv.Close()
+	cardSrv := fetchtest.NewServer("pricefetch/testdata/tcgplayer")
+	defer cardSrv.Close()
+	src := *pricefetch.TCGplayer
+	src.CardURL = cardSrv.URL + "/magic/{set}/{card}"
+	src.SealedURL = sealedSrv.URL + "/sealed/{set}/{product}"
+	src.Fetcher = fetchtest.NewFetcher(cardSrv)
+
+	cfg := config.Default()
+	cfg.Checkpoint.Path = ""
+	set := &config.Set{Code: "RTR", Checklist: base + "/gatherer/checklist.html"}
+	if r := pricePacks(cfg, &src, set); len(r.Cards) == 0 || r.Sealed != nil {
+		t.Errorf("got %+v", r)
+	}
+	if n := atomic.LoadInt32(&sealedRequests); n != 0 {
+		t.Errorf("pricing packs made %d sealed requests", n)
+	}
+	priceSet(cfg, &src, set)
+	if n := atomic.LoadInt32(&sealedRequests); n != int32(len(cfg.EV.Sealed)) {
+		t.Errorf("pricing the set made %d sealed requests, want %d", n, len(cfg.EV.Sealed))
+	}
+}
+
+func TestPricePacksReprints(t *testing.T) {
+	base := useFixtures(t)
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	src := *pricefetch.TCGplayer
+	src.CardURL = srv.URL + "/magic/{set}/{card}"
+	src.SealedURL = ""
+
+	cfg := config.Default()
+	cfg.Checkpoint.Path = ""
+	set := &config.Set{Code: "DGM", Checklist: base + "/gatherer/checklist.html"}
+	r := pricePacks(cfg, &src, set)
+	for _, path := range []string{
+		"/magic/" + pricefetch.SetSlug("RTR") + "/Steam Vents",
+		"/magic/" + pricefetch.SetSlug("GTC") + "/Boros Guildgate",
+	} {
+		if !requested[path] {
+			t.Errorf("%s was not requested", path)
+		}
+	}
+	// the reprints are only used for the pack's value
+	for _, c := range r.Cards {
+		if c.Name == "Steam Vents" {
+			t.Errorf("reprint in the report: %+v", c)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/moovweb/gokogiri/html"
+	"github.com/moovweb/gokogiri/xml"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"wdix/getev/fetch"
+	"wdix/getev/metrics"
+	"wdix/getev/pricefetch"
+)
+
+// The parts of a Gatherer detail page that enrichment reads.
+const (
+	typeXPath   = "//div[contains(@id,'typeRow')]/div[@class='value']"
+	textXPath   = "//div[contains(@id,'textRow')]/div[@class='value']/div[@class='cardtextbox']"
+	artistXPath = "//div[contains(@id,'artistRow')]/div[@class='value']"
+	imageXPath  = "//img[contains(@id,'cardImage')]"
+)
+
+// enrichCards fills in the Info of each card from the Gatherer detail page
+// linked from its checklist row, fetching at most concurrency pages at a time.
+// Cards whose page could not be read are reported and left as they are.
+func enrichCards(cards []pricefetch.Card, entries []checklistEntry, concurrency int) {
+	detail := make(map[string]string)
+	for _, entry := range entries {
+		if entry.DetailURL != "" {
+			detail[entry.Name] = entry.DetailURL
+		}
+	}
+
+	done := make(chan bool)
+	slots := make(chan struct{}, concurrency)
+	for i := range cards {
+		go func(card *pricefetch.Card) {
+			defer func() { done <- true }()
+			pageURL, ok := detail[card.Name]
+			if !ok {
+				return
+			}
+			slots <- struct{}{}
+			info, err := fetchCardDetails(pageURL)
+			<-slots
+			if err != nil {
+				slog.Warn("no details", "card", card.Name, "url", pageURL, "err", err)
+				metrics.Default.Inc(metrics.EnrichFailed)
+				return
+			}
+			metrics.Default.Inc(metrics.CardsEnriched)
+			card.Info = info
+		}(&cards[i])
+	}
+	for range cards {
+		<-done
+	}
+}
+
+// fetchCardDetails fetches and parses a Gatherer card detail page.
+func fetchCardDetails(pageURL string) (*pricefetch.CardInfo, error) {
+	response, err := fetch.Default.Get(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	return parseCardDetails(response, pageURL)
+}
+
+// parseCardDetails reads a card's details from the page fetched from pageURL.
+func parseCardDetails(page []byte, pageURL string) (*pricefetch.CardInfo, error) {
+	doc, err := html.Parse(page, html.DefaultEncodingBytes, nil, html.DefaultParseOption, html.DefaultEncodingBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Free()
+	root := doc.Root().FirstChild()
+
+	info := &pricefetch.CardInfo{MultiverseID: multiverseID(pageURL)}
+	info.Type = firstContent(root, typeXPath)
+	info.Artist = firstContent(root, artistXPath)
+
+	boxes, err := root.Search(textXPath)
+	if err != nil {
+		return nil, err
+	}
+	var text []string
+	for _, box := range boxes {
+		text = append(text, strings.TrimSpace(box.Content()))
+	}
+	info.Text = strings.Join(text, "\n")
+
+	if images, err := root.Search(imageXPath); err == nil && len(images) > 0 {
+		info.ImageURL = resolveUrl(pageURL, images[0].Attr("src"))
+	}
+	return info, nil
+}
+
+// firstContent returns the trimmed text of the first node matching xpath.
+func firstContent(node xml.Node, xpath string) string {
+	nodes, err := node.Search(xpath)
+	if err != nil || len(nodes) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(nodes[0].Content())
+}
+
+// multiverseID returns the multiverseid parameter of a Gatherer url, or 0.
+func multiverseID(pageURL string) int {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return 0
+	}
+	id, _ := strconv.Atoi(u.Query().Get("multiverseid"))
+	return id
+}
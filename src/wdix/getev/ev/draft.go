@@ -0,0 +1,116 @@
+package ev
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// The number of players in a draft pod when none is given.
+const PodSize = 8
+
+// Draft is a booster draft: each player opens one pack of each set in Packs,
+// in order.
+type Draft struct {
+	Players int      `json:"players"`
+	Packs   []string `json:"packs"` // set codes, as ["GTC", "GTC", "RTR"]
+}
+
+// ParseDraft reads a draft from its pack list, as "GTC-GTC-RTR" or
+// "GTC,GTC,RTR", for a full pod.
+func ParseDraft(spec string) (*Draft, error) {
+	d := &Draft{Players: PodSize}
+	for _, code := range strings.FieldsFunc(spec, func(r rune) bool { return r == '-' || r == ',' }) {
+		d.Packs = append(d.Packs, strings.ToUpper(strings.TrimSpace(code)))
+	}
+	return d, d.Validate()
+}
+
+// LoadDraft reads a draft from a JSON file. Players defaults to a full pod.
+func LoadDraft(path string) (*Draft, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Draft{Players: PodSize}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for i, code := range d.Packs {
+		d.Packs[i] = strings.ToUpper(strings.TrimSpace(code))
+	}
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return d, nil
+}
+
+func (d *Draft) Validate() error {
+	if d.Players < 1 {
+		return fmt.Errorf("draft: players must be at least 1, got %d", d.Players)
+	}
+	if len(d.Packs) == 0 {
+		return fmt.Errorf("draft: no packs")
+	}
+	for i, code := range d.Packs {
+		if code == "" {
+			return fmt.Errorf("draft: pack %d has no set", i+1)
+		}
+	}
+	return nil
+}
+
+// Sets returns the distinct sets opened in the draft, in order.
+func (d *Draft) Sets() []string {
+	var sets []string
+	seen := make(map[string]bool)
+	for _, code := range d.Packs {
+		if !seen[code] {
+			seen[code] = true
+			sets = append(sets, code)
+		}
+	}
+	return sets
+}
+
+// Position is what one round of the draft is worth.
+type Position struct {
+	Pack  int     `json:"pack"` // counting from 1
+	Set   string  `json:"set"`
+	EV    float64 `json:"ev"`    // of a single pack
+	Share float64 `json:"share"` // of the player's total, from 0 to 1
+	PodEV float64 `json:"podEV"` // of the packs every player opens
+}
+
+// DraftValue is the EV of a draft for each player and for the pod.
+type DraftValue struct {
+	Positions []Position `json:"positions"`
+	Player    float64    `json:"player"`
+	Pod       float64    `json:"pod"`
+}
+
+// Value works out the draft's EV from the pack EV of each set.
+func (d *Draft) Value(packEV map[string]float64) (DraftValue, error) {
+	var v DraftValue
+	for i, code := range d.Packs {
+		pack, ok := packEV[code]
+		if !ok {
+			return DraftValue{}, fmt.Errorf("draft: no pack EV for %s", code)
+		}
+		v.Positions = append(v.Positions, Position{
+			Pack:  i + 1,
+			Set:   code,
+			EV:    pack,
+			PodEV: pack * float64(d.Players),
+		})
+		v.Player += pack
+	}
+	for i := range v.Positions {
+		if v.Player > 0 {
+			v.Positions[i].Share = v.Positions[i].EV / v.Player
+		}
+	}
+	v.Pod = v.Player * float64(d.Players)
+	return v, nil
+}
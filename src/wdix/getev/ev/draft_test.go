@@ -0,0 +1,71 @@
+package ev
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDraft(t *testing.T) {
+	tests := []struct {
+		spec  string
+		packs []string
+		ok    bool
+	}{
+		{"GTC-GTC-RTR", []string{"GTC", "GTC", "RTR"}, true},
+		{"rtr, rtr, rtr", []string{"RTR", "RTR", "RTR"}, true},
+		{"DGM", []string{"DGM"}, true},
+		{"", nil, false},
+	}
+	for _, test := range tests {
+		d, err := ParseDraft(test.spec)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v", test.spec, err)
+			continue
+		}
+		if test.ok && (!reflect.DeepEqual(d.Packs, test.packs) || d.Players != PodSize) {
+			t.Errorf("%q: got %+v", test.spec, d)
+		}
+	}
+}
+
+func TestLoadDraft(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "draft.json")
+	ioutil.WriteFile(path, []byte(`{"players": 6, "packs": ["dgm", "gtc", "rtr"]}`), 0644)
+	d, err := LoadDraft(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Players != 6 || !reflect.DeepEqual(d.Sets(), []string{"DGM", "GTC", "RTR"}) {
+		t.Errorf("got %+v", d)
+	}
+
+	ioutil.WriteFile(path, []byte(`{"players": 0, "packs": ["RTR"]}`), 0644)
+	if _, err := LoadDraft(path); err == nil {
+		t.Error("expected an error for no players")
+	}
+}
+
+func TestDraftValue(t *testing.T) {
+	d := &Draft{Players: 8, Packs: []string{"GTC", "GTC", "RTR"}}
+	v, err := d.Value(map[string]float64{"GTC": 2, "RTR": 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Player != 8 || v.Pod != 64 {
+		t.Errorf("got player %v, pod %v, want 8 and 64", v.Player, v.Pod)
+	}
+	want := []Position{
+		{Pack: 1, Set: "GTC", EV: 2, Share: 0.25, PodEV: 16},
+		{Pack: 2, Set: "GTC", EV: 2, Share: 0.25, PodEV: 16},
+		{Pack: 3, Set: "RTR", EV: 4, Share: 0.5, PodEV: 32},
+	}
+	if !reflect.DeepEqual(v.Positions, want) {
+		t.Errorf("got %+v", v.Positions)
+	}
+
+	if _, err := d.Value(map[string]float64{"GTC": 2}); err == nil {
+		t.Error("expected an error for a set with no EV")
+	}
+}
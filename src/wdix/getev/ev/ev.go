@@ -0,0 +1,88 @@
+package ev
+
+import (
+	"sort"
+	"wdix/getev/pricefetch"
+)
+
+// The number of cards in a booster pack.
+const PackSize = 15
+
+// Average returns the mean price of the cards.
+func Average(cards []pricefetch.Card) float64 {
+	if len(cards) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, card := range cards {
+		total += card.Price
+	}
+	return total / float64(len(cards))
+}
+
+// Pack returns the expected value of opening a single booster pack of
+// packSize cards, treating every card in the set as equally likely to show up
+// in any slot.
+func Pack(cards []pricefetch.Card, packSize int) float64 {
+	return Average(cards) * float64(packSize)
+}
+
+// Concentration returns the share of the cards' total value held by the n
+// most valuable of them, from 0 to 1. A set whose EV rests on a few cards is
+// a riskier box to open than one whose value is spread out.
+func Concentration(cards []pricefetch.Card, n int) float64 {
+	prices := make([]float64, 0, len(cards))
+	total := 0.0
+	for _, card := range cards {
+		prices = append(prices, card.Price)
+		total += card.Price
+	}
+	if total == 0 {
+		return 0
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	if n > len(prices) {
+		n = len(prices)
+	}
+	top := 0.0
+	for _, p := range prices[:n] {
+		top += p
+	}
+	return top / total
+}
+
+// Verdicts for a sealed product.
+const (
+	Open       = "open"
+	KeepSealed = "keep sealed"
+)
+
+// Sealed is the market price of a sealed product holding Packs boosters.
+type Sealed struct {
+	Product string
+	Packs   int
+	Price   float64
+}
+
+// Comparison weighs a sealed product's market price against the value of the
+// packs inside it.
+type Comparison struct {
+	Sealed
+	Opened  float64 // the EV of opening every pack
+	Verdict string
+}
+
+// Compare decides, for each product, whether opening it is worth more than
+// selling it sealed.
+func Compare(products []Sealed, packEV float64) []Comparison {
+	var cs []Comparison
+	for _, p := range products {
+		c := Comparison{Sealed: p, Opened: packEV * float64(p.Packs)}
+		c.Verdict = KeepSealed
+		if c.Opened > c.Price {
+			c.Verdict = Open
+		}
+		cs = append(cs, c)
+	}
+	return cs
+}
@@ -0,0 +1,53 @@
+package ev
+
+import (
+	"testing"
+	"wdix/getev/pricefetch"
+)
+
+func TestPack(t *testing.T) {
+	cards := []pricefetch.Card{{Price: 1}, {Price: 2}, {Price: 3}}
+	if got := Pack(cards, PackSize); got != 30 {
+		t.Errorf("got %v, want 30", got)
+	}
+	if got := Pack(nil, PackSize); got != 0 {
+		t.Errorf("empty set: got %v, want 0", got)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	sealed := []Sealed{
+		{Product: "Booster Pack", Packs: 1, Price: 4},
+		{Product: "Booster Box", Packs: 36, Price: 90},
+	}
+	cs := Compare(sealed, 3)
+	if len(cs) != 2 {
+		t.Fatalf("got %d comparisons", len(cs))
+	}
+	if cs[0].Opened != 3 || cs[0].Verdict != KeepSealed {
+		t.Errorf("pack: %+v", cs[0])
+	}
+	if cs[1].Opened != 108 || cs[1].Verdict != Open {
+		t.Errorf("box: %+v", cs[1])
+	}
+}
+
+func TestConcentration(t *testing.T) {
+	cards := []pricefetch.Card{{Price: 1}, {Price: 6}, {Price: 1}, {Price: 2}}
+	tests := []struct {
+		n    int
+		want float64
+	}{
+		{1, 0.6},
+		{2, 0.8},
+		{10, 1},
+	}
+	for _, test := range tests {
+		if got := Concentration(cards, test.n); got != test.want {
+			t.Errorf("top %d: got %v, want %v", test.n, got, test.want)
+		}
+	}
+	if got := Concentration(nil, 5); got != 0 {
+		t.Errorf("no cards: got %v", got)
+	}
+}
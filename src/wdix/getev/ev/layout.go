@@ -0,0 +1,278 @@
+package ev
+
+import (
+	"fmt"
+	"math/rand"
+	"wdix/getev/pricefetch"
+)
+
+// Rarities as Gatherer's checklist gives them.
+const (
+	Common    = "C"
+	Uncommon  = "U"
+	Rare      = "R"
+	Mythic    = "M"
+	BasicLand = "L"
+)
+
+// Layout describes what goes into a booster pack, slot by slot.
+type Layout struct {
+	Name  string `json:"name"`
+	Slots []Slot `json:"slots"`
+	// Print sheets by name. A card printed several times on a sheet is
+	// listed that many times.
+	Sheets map[string][]string `json:"sheets,omitempty"`
+	// Cards on the sheets that are not on the set's checklist, by name, to
+	// the code of the set they are priced from.
+	Reprints map[string]string `json:"reprints,omitempty"`
+}
+
+// Slot is Count cards drawn alike, each from one of Pools chosen by weight.
+type Slot struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Pools []Pool `json:"pools"`
+}
+
+// Pool is a group of cards a slot draws from. Its cards are those of the
+// sheet if Sheet is set, else those of the rarity if Rarity is set, else the
+// cards named in Cards.
+type Pool struct {
+	Rarity string  `json:"rarity,omitempty"`
+	Sheet  string  `json:"sheet,omitempty"`
+	Weight float64 `json:"weight,omitempty"` // against the slot's other pools, 0 counts as 1
+	// Weights of single cards within the pool, the rest weigh 1.
+	Cards map[string]float64 `json:"cards,omitempty"`
+}
+
+// RTRBlock is the Return to Ravnica and Gatecrash pack: ten commons, three
+// uncommons, a rare that is a mythic about one time in eight, and a basic land.
+var RTRBlock = &Layout{
+	Name: "rtr",
+	Slots: []Slot{
+		{Name: "common", Count: 10, Pools: []Pool{{Rarity: Common}}},
+		{Name: "uncommon", Count: 3, Pools: []Pool{{Rarity: Uncommon}}},
+		{Name: "rare", Count: 1, Pools: []Pool{{Rarity: Rare, Weight: 7}, {Rarity: Mythic, Weight: 1}}},
+		{Name: "land", Count: 1, Pools: []Pool{{Rarity: BasicLand}}},
+	},
+}
+
+// DragonsMaze replaces the basic land with a guildgate, or about one time in
+// twenty with a shockland. Neither is on the Dragon's Maze checklist, so they
+// are priced from Return to Ravnica and Gatecrash, where they were printed.
+var DragonsMaze = &Layout{
+	Name: "dgm",
+	Slots: []Slot{
+		RTRBlock.Slots[0],
+		RTRBlock.Slots[1],
+		RTRBlock.Slots[2],
+		{Name: "land", Count: 1, Pools: []Pool{
+			{Sheet: "guildgates", Weight: 19},
+			{Sheet: "shocklands", Weight: 1},
+		}},
+	},
+	Sheets: map[string][]string{
+		"guildgates": {
+			"Azorius Guildgate", "Boros Guildgate", "Dimir Guildgate",
+			"Golgari Guildgate", "Gruul Guildgate", "Izzet Guildgate",
+			"Orzhov Guildgate", "Rakdos Guildgate", "Selesnya Guildgate",
+			"Simic Guildgate",
+		},
+		"shocklands": {
+			"Hallowed Fountain", "Watery Grave", "Blood Crypt",
+			"Stomping Ground", "Temple Garden", "Godless Shrine",
+			"Overgrown Tomb", "Sacred Foundry", "Breeding Pool",
+			"Steam Vents",
+		},
+	},
+	Reprints: map[string]string{
+		"Azorius Guildgate": "RTR", "Dimir Guildgate": "RTR",
+		"Golgari Guildgate": "RTR", "Izzet Guildgate": "RTR",
+		"Rakdos Guildgate": "RTR", "Selesnya Guildgate": "RTR",
+		"Boros Guildgate": "GTC", "Gruul Guildgate": "GTC",
+		"Orzhov Guildgate": "GTC", "Simic Guildgate": "GTC",
+
+		"Blood Crypt": "RTR", "Hallowed Fountain": "RTR",
+		"Overgrown Tomb": "RTR", "Steam Vents": "RTR",
+		"Temple Garden": "RTR",
+		"Breeding Pool": "GTC", "Godless Shrine": "GTC",
+		"Sacred Foundry": "GTC", "Stomping Ground": "GTC",
+		"Watery Grave": "GTC",
+	},
+}
+
+// Layouts are the built in layouts by set code.
+var Layouts = map[string]*Layout{
+	"RTR": RTRBlock,
+	"GTC": RTRBlock,
+	"DGM": DragonsMaze,
+}
+
+// Validate checks the layout for slots that can never be filled.
+func (l *Layout) Validate() error {
+	if len(l.Slots) == 0 {
+		return fmt.Errorf("layout %s: no slots", l.Name)
+	}
+	for _, s := range l.Slots {
+		if s.Count < 1 {
+			return fmt.Errorf("layout %s: slot %s: count must be at least 1", l.Name, s.Name)
+		}
+		if len(s.Pools) == 0 {
+			return fmt.Errorf("layout %s: slot %s: no pools", l.Name, s.Name)
+		}
+		for _, p := range s.Pools {
+			if p.Weight < 0 {
+				return fmt.Errorf("layout %s: slot %s: negative weight", l.Name, s.Name)
+			}
+			if p.Sheet != "" && len(l.Sheets[p.Sheet]) == 0 {
+				return fmt.Errorf("layout %s: slot %s: no sheet named %q", l.Name, s.Name, p.Sheet)
+			}
+			if p.Sheet == "" && p.Rarity == "" && len(p.Cards) == 0 {
+				return fmt.Errorf("layout %s: slot %s: a pool needs a rarity, a sheet or cards", l.Name, s.Name)
+			}
+		}
+	}
+	for name, code := range l.Reprints {
+		if code == "" {
+			return fmt.Errorf("layout %s: reprint %s: missing set code", l.Name, name)
+		}
+	}
+	return nil
+}
+
+// entry is a card in a pool with its weight.
+type entry struct {
+	price, weight float64
+}
+
+type pool struct {
+	weight  float64
+	entries []entry
+	total   float64 // the sum of the entries' weights
+}
+
+type slot struct {
+	count int
+	pools []pool
+	total float64 // the sum of the pools' weights
+}
+
+// resolve fills the layout's pools with the priced cards. Cards on a sheet
+// that were not priced count as free.
+func (l *Layout) resolve(cards []pricefetch.Card) ([]slot, error) {
+	prices := make(map[string]float64)
+	for _, c := range cards {
+		prices[c.Name] = c.Price
+	}
+	var slots []slot
+	for _, s := range l.Slots {
+		rs := slot{count: s.Count}
+		for _, p := range s.Pools {
+			rp := pool{weight: p.Weight}
+			if rp.weight == 0 {
+				rp.weight = 1
+			}
+			add := func(name string) {
+				w, ok := p.Cards[name]
+				if !ok {
+					w = 1
+				}
+				if w > 0 {
+					rp.entries = append(rp.entries, entry{prices[name], w})
+					rp.total += w
+				}
+			}
+			switch {
+			case p.Sheet != "":
+				for _, name := range l.Sheets[p.Sheet] {
+					add(name)
+				}
+			case p.Rarity != "":
+				for _, c := range cards {
+					if c.Rarity == p.Rarity {
+						add(c.Name)
+					}
+				}
+			default:
+				for name := range p.Cards {
+					add(name)
+				}
+			}
+			if rp.total == 0 {
+				return nil, fmt.Errorf("layout %s: slot %s: no cards for pool %s",
+					l.Name, s.Name, p.describe())
+			}
+			rs.pools = append(rs.pools, rp)
+			rs.total += rp.weight
+		}
+		slots = append(slots, rs)
+	}
+	return slots, nil
+}
+
+func (p Pool) describe() string {
+	switch {
+	case p.Sheet != "":
+		return "sheet " + p.Sheet
+	case p.Rarity != "":
+		return "rarity " + p.Rarity
+	}
+	return "of named cards"
+}
+
+// LayoutPack returns the expected value of a booster pack laid out as l. It
+// fails if a pool has no cards, as when the checklist gave no rarities.
+func LayoutPack(l *Layout, cards []pricefetch.Card) (float64, error) {
+	slots, err := l.resolve(cards)
+	if err != nil {
+		return 0, err
+	}
+	total := 0.0
+	for _, s := range slots {
+		value := 0.0
+		for _, p := range s.pools {
+			avg := 0.0
+			for _, e := range p.entries {
+				avg += e.price * e.weight
+			}
+			value += p.weight / s.total * avg / p.total
+		}
+		total += value * float64(s.count)
+	}
+	return total, nil
+}
+
+// Simulate opens packs boosters laid out as l and returns their mean value.
+// Each card is drawn on its own, so a pack may hold the same card twice.
+func Simulate(l *Layout, cards []pricefetch.Card, packs int, rng *rand.Rand) (float64, error) {
+	slots, err := l.resolve(cards)
+	if err != nil {
+		return 0, err
+	}
+	if packs < 1 {
+		return 0, nil
+	}
+	total := 0.0
+	for i := 0; i < packs; i++ {
+		for _, s := range slots {
+			for n := 0; n < s.count; n++ {
+				p := s.pools[pick(rng, s.total, len(s.pools), func(i int) float64 { return s.pools[i].weight })]
+				e := p.entries[pick(rng, p.total, len(p.entries), func(i int) float64 { return p.entries[i].weight })]
+				total += e.price
+			}
+		}
+	}
+	return total / float64(packs), nil
+}
+
+// pick chooses one of n items by weight.
+func pick(rng *rand.Rand, total float64, n int, weight func(int) float64) int {
+	r := rng.Float64() * total
+	for i := 0; i < n; i++ {
+		r -= weight(i)
+		if r < 0 {
+			return i
+		}
+	}
+	return n - 1
+}
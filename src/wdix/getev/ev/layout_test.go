@@ -0,0 +1,104 @@
+package ev
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"wdix/getev/pricefetch"
+)
+
+func testCards() []pricefetch.Card {
+	return []pricefetch.Card{
+		{Name: "Common A", Rarity: Common, Price: 0.1},
+		{Name: "Common B", Rarity: Common, Price: 0.3},
+		{Name: "Uncommon", Rarity: Uncommon, Price: 0.5},
+		{Name: "Rare", Rarity: Rare, Price: 2},
+		{Name: "Mythic", Rarity: Mythic, Price: 18},
+		{Name: "Forest", Rarity: BasicLand, Price: 0},
+	}
+}
+
+func TestLayoutPack(t *testing.T) {
+	// 10 * 0.2 + 3 * 0.5 + (7*2 + 18) / 8 + 0
+	got, err := LayoutPack(RTRBlock, testCards())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(got-7.5) > 1e-9 {
+		t.Errorf("got %v, want 7.5", got)
+	}
+}
+
+func TestLayoutWeights(t *testing.T) {
+	l := &Layout{
+		Name:  "test",
+		Slots: []Slot{{Name: "sheet", Count: 1, Pools: []Pool{{Sheet: "s"}}}},
+		// Common A is printed three times on the sheet.
+		Sheets: map[string][]string{"s": {"Common A", "Common A", "Common A", "Common B"}},
+	}
+	got, err := LayoutPack(l, testCards())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (3*0.1 + 0.3) / 4; math.Abs(got-want) > 1e-9 {
+		t.Errorf("sheet: got %v, want %v", got, want)
+	}
+
+	l.Slots[0].Pools = []Pool{{Rarity: Common, Cards: map[string]float64{"Common B": 3}}}
+	got, err = LayoutPack(l, testCards())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (0.1 + 3*0.3) / 4; math.Abs(got-want) > 1e-9 {
+		t.Errorf("card weights: got %v, want %v", got, want)
+	}
+}
+
+func TestLayoutNoRarities(t *testing.T) {
+	cards := []pricefetch.Card{{Name: "A", Price: 1}}
+	if _, err := LayoutPack(RTRBlock, cards); err == nil {
+		t.Error("expected an error for cards with no rarity")
+	}
+}
+
+func TestLayoutValidate(t *testing.T) {
+	for _, l := range []*Layout{RTRBlock, DragonsMaze} {
+		if err := l.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+	bad := []*Layout{
+		{Name: "empty"},
+		{Name: "count", Slots: []Slot{{Name: "c", Pools: []Pool{{Rarity: Common}}}}},
+		{Name: "sheet", Slots: []Slot{{Name: "c", Count: 1, Pools: []Pool{{Sheet: "missing"}}}}},
+		{Name: "pool", Slots: []Slot{{Name: "c", Count: 1, Pools: []Pool{{}}}}},
+		{Name: "reprint", Slots: RTRBlock.Slots, Reprints: map[string]string{"Forest": ""}},
+	}
+	for _, l := range bad {
+		if err := l.Validate(); err == nil {
+			t.Errorf("%s: expected an error", l.Name)
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	got, err := Simulate(RTRBlock, testCards(), 20000, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(got-7.5) > 0.3 {
+		t.Errorf("simulated %v, want about 7.5", got)
+	}
+}
+
+func TestDragonsMazeReprints(t *testing.T) {
+	// The lands are not on the checklist, so each must be priced elsewhere.
+	for sheet, names := range DragonsMaze.Sheets {
+		for _, name := range names {
+			if DragonsMaze.Reprints[name] == "" {
+				t.Errorf("%s: %s has no set to be priced from", sheet, name)
+			}
+		}
+	}
+}
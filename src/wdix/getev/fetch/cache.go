@@ -0,0 +1,97 @@
+package fetch
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A Cache keeps fetched pages so that they are only fetched once.
+type Cache interface {
+	Get(url string) ([]byte, bool)
+	Put(url string, body []byte)
+}
+
+// MemoryCache keeps pages for the life of the process.
+type MemoryCache struct {
+	mu    sync.Mutex
+	pages map[string][]byte
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{pages: make(map[string][]byte)}
+}
+
+func (c *MemoryCache) Get(url string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	body, ok := c.pages[url]
+	return body, ok
+}
+
+func (c *MemoryCache) Put(url string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pages[url] = body
+}
+
+// DirCache keeps pages as files in Dir so that they survive between runs.
+// Pages older than MaxAge, or DefaultMaxAge if it is zero, are fetched
+// again, so that prices don't go stale.
+type DirCache struct {
+	Dir    string
+	MaxAge time.Duration
+}
+
+// DefaultMaxAge is how long a DirCache keeps pages if its MaxAge is zero.
+const DefaultMaxAge = 24 * time.Hour
+
+func (c *DirCache) path(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *DirCache) Get(url string) ([]byte, bool) {
+	p := c.path(url)
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, false
+	}
+	maxAge := c.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultMaxAge
+	}
+	if time.Since(info.ModTime()) > maxAge {
+		return nil, false
+	}
+	body, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+// Put stores the page, ignoring errors since a page missing from the cache is
+// only fetched again.
+func (c *DirCache) Put(url string, body []byte) {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(c.Dir, "tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	os.Rename(tmp.Name(), c.path(url))
+}
@@ -0,0 +1,155 @@
+/*
+Package fetch is the HTTP layer shared by the getev scrapers.
+
+A Fetcher wraps an http.Client with the settings from the getev config: a
+User-Agent, how many times to retry a request that failed with a network
+error or a server error, and a Cache of the pages already fetched. Requests,
+retries and cache lookups are counted in its Metrics. Its Policy keeps it to
+each host's robots.txt and spaces out the requests it makes to a host.
+*/
+package fetch
+
+import (
+	"code.google.com/p/go-html-transform/h5"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"wdix/getev/metrics"
+)
+
+// Fetcher fetches pages over HTTP.
+type Fetcher struct {
+	Client    *http.Client
+	UserAgent string
+	Retries   int           // extra attempts after a failed request
+	Backoff   time.Duration // wait before the first retry, doubled each time
+	Cache     Cache         // pages already fetched, nil to always fetch
+	Metrics   *metrics.Registry
+	Policy    *Policy // nil to crawl as fast as the client allows
+	// Sent with every request, as some sites want a session cookie or a
+	// Referer.
+	Header  http.Header
+	Cookies []*http.Cookie
+	// Decode converts html pages to utf-8 from the encoding they are in,
+	// worked out from the Content-Type and the page the way a browser does.
+	// A response with no Content-Type is only converted if it looks like
+	// html.
+	Decode bool
+}
+
+// Default is the Fetcher used when none is configured.
+var Default = &Fetcher{Client: http.DefaultClient, Backoff: time.Second}
+
+// StatusError is returned for responses other than 200 OK.
+type StatusError struct {
+	URL    string
+	Status string
+	Code   int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("fetch %s: %s", e.URL, e.Status)
+}
+
+// Get fetches url and returns the response body.
+func (f *Fetcher) Get(url string) ([]byte, error) {
+	if f.Cache != nil {
+		if body, ok := f.Cache.Get(url); ok {
+			f.Metrics.Inc(metrics.CacheHits)
+			slog.Debug("cache hit", "url", url)
+			return body, nil
+		}
+		f.Metrics.Inc(metrics.CacheMisses)
+	}
+	body, err := f.fetch(url)
+	if err == nil && f.Cache != nil {
+		f.Cache.Put(url, body)
+	}
+	return body, err
+}
+
+func (f *Fetcher) fetch(url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range f.Header {
+		req.Header[k] = v
+	}
+	for _, c := range f.Cookies {
+		req.AddCookie(c)
+	}
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+	wait := f.Backoff
+	for attempt := 0; ; attempt++ {
+		if err := f.Policy.wait(f, req.URL); err != nil {
+			f.Metrics.Inc(metrics.Disallowed)
+			return nil, err
+		}
+		start := time.Now()
+		body, err := f.do(req)
+		f.Metrics.Inc(metrics.Requests)
+		f.Metrics.Observe(req.URL.Host, time.Since(start))
+		if err == nil {
+			slog.Debug("fetched", "url", url, "bytes", len(body), "took", time.Since(start))
+			return body, nil
+		}
+		if attempt >= f.Retries || !retryable(err) {
+			f.Metrics.Inc(metrics.RequestErrors)
+			return nil, err
+		}
+		slog.Warn("retrying", "url", url, "attempt", attempt+1, "wait", wait, "err", err)
+		f.Metrics.Inc(metrics.Retries)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (f *Fetcher) do(req *http.Request) ([]byte, error) {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		url := req.URL.String()
+		return nil, &StatusError{URL: url, Status: res.Status, Code: res.StatusCode}
+	}
+	if f.Decode && isHTML(res.Header.Get("Content-Type"), body) {
+		body, _ = h5.Decode(body, res.Header.Get("Content-Type"))
+	}
+	return body, nil
+}
+
+// isHTML reports whether a response is an html page, going by the body when
+// the server didn't say what it is.
+func isHTML(contentType string, body []byte) bool {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	return strings.Contains(contentType, "html")
+}
+
+// retryable reports whether a failed request is worth trying again.
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *StatusError:
+		return e.Code >= 500 || e.Code == http.StatusTooManyRequests
+	case *RobotsError:
+		return false
+	}
+	return true
+}
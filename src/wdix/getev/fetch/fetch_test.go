@@ -0,0 +1,170 @@
+package fetch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"wdix/getev/metrics"
+)
+
+func TestGetRetries(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(r.Header.Get("User-Agent")))
+	}))
+	defer srv.Close()
+
+	m := metrics.New()
+	f := &Fetcher{UserAgent: "getev-test", Retries: 2, Backoff: time.Millisecond, Metrics: m}
+	body, err := f.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "getev-test" || calls != 3 {
+		t.Errorf("got %q after %d calls, want %q after 3", body, calls, "getev-test")
+	}
+	if m.Count(metrics.Requests) != 3 || m.Count(metrics.Retries) != 2 || m.Count(metrics.RequestErrors) != 0 {
+		t.Errorf("metrics: %d requests, %d retries, %d errors",
+			m.Count(metrics.Requests), m.Count(metrics.Retries), m.Count(metrics.RequestErrors))
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{Retries: 2, Backoff: time.Millisecond}
+	_, err := f.Get(srv.URL)
+	if se, ok := err.(*StatusError); !ok || se.Code != http.StatusNotFound {
+		t.Errorf("got error %v, want a 404 StatusError", err)
+	}
+	if calls != 1 {
+		t.Errorf("a 404 was tried %d times, want 1", calls)
+	}
+}
+
+func TestGetDecode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/text":
+			// no Content-Type, so the body is sniffed
+			w.Header()["Content-Type"] = nil
+			w.Write([]byte("caf\xe9"))
+			return
+		case "/sniff":
+			w.Header()["Content-Type"] = nil
+			w.Write([]byte("<html><p>caf\xe9"))
+			return
+		}
+		if r.URL.Path == "/late" {
+			// undeclared utf-8 that is all ascii for more than the first KB
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(strings.Repeat(" ", 2000) + "<p>Jace \xe2\x80\x94 Architect"))
+			return
+		}
+		if r.URL.Path == "/meta" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<meta charset=windows-1251><p>\xc4\xe0"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+		w.Write([]byte("<p>caf\xe9 \x80"))
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{Decode: true}
+	for path, want := range map[string]string{
+		"/":      "<p>caf\u00e9 \u20ac",
+		"/meta":  "<meta charset=windows-1251><p>\u0414\u0430",
+		"/text":  "caf\xe9",
+		"/sniff": "<html><p>caf\u00e9",
+		"/late":  strings.Repeat(" ", 2000) + "<p>Jace \u2014 Architect",
+	} {
+		body, err := f.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != want {
+			t.Errorf("%s: got %q, want %q", path, body, want)
+		}
+	}
+}
+
+func TestCaches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getev-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caches := map[string]Cache{
+		"memory": NewMemoryCache(),
+		"dir":    &DirCache{Dir: dir},
+	}
+	for name, cache := range caches {
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Write([]byte("page"))
+		}))
+		m := metrics.New()
+		f := &Fetcher{Cache: cache, Metrics: m}
+		for i := 0; i < 2; i++ {
+			body, err := f.Get(srv.URL)
+			if err != nil || string(body) != "page" {
+				t.Errorf("%s: got %q, %v", name, body, err)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("%s: fetched %d times, want 1", name, calls)
+		}
+		if m.Count(metrics.CacheHits) != 1 || m.Count(metrics.CacheMisses) != 1 {
+			t.Errorf("%s: %d hits and %d misses, want 1 of each",
+				name, m.Count(metrics.CacheHits), m.Count(metrics.CacheMisses))
+		}
+		srv.Close()
+	}
+}
+
+func TestDirCacheExpires(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getev-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &DirCache{Dir: dir, MaxAge: time.Hour}
+	c.Put("http://example.com/", []byte("old"))
+	if _, ok := c.Get("http://example.com/"); !ok {
+		t.Fatal("fresh page not found")
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(c.path("http://example.com/"), old, old)
+	if _, ok := c.Get("http://example.com/"); ok {
+		t.Error("expired page still found")
+	}
+
+	// with no MaxAge, pages last DefaultMaxAge rather than forever
+	c.MaxAge = 0
+	if _, ok := c.Get("http://example.com/"); !ok {
+		t.Error("page younger than DefaultMaxAge not found")
+	}
+	old = time.Now().Add(-DefaultMaxAge - time.Hour)
+	os.Chtimes(c.path("http://example.com/"), old, old)
+	if _, ok := c.Get("http://example.com/"); ok {
+		t.Error("page older than DefaultMaxAge still found")
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Package fetchtest serves saved pages for testing the getev scrapers offline.
+
+A request for /magic/return-to-ravnica/Angel of Serenity is answered with the
+file magic/return-to-ravnica/angel-of-serenity.html under the fixture
+directory, and a request with no such file gets a 404.
+*/
+package fetchtest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"wdix/getev/fetch"
+)
+
+// NewServer starts a server for the fixtures in dir. The caller closes it.
+func NewServer(dir string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, err := ioutil.ReadFile(filepath.Join(dir, FixturePath(r.URL.Path)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	}))
+}
+
+// FixturePath returns the file, relative to the fixture directory, that
+// answers a request for urlPath.
+func FixturePath(urlPath string) string {
+	p := path.Clean("/" + urlPath)
+	if p == "/" {
+		p = "/index"
+	}
+	p = strings.ToLower(strings.Join(strings.Fields(p), "-"))
+	p = strings.Replace(p, "'", "", -1)
+	if path.Ext(p) == "" {
+		p += ".html"
+	}
+	return filepath.FromSlash(p[1:])
+}
+
+// NewFetcher returns a Fetcher for the server that does not retry.
+func NewFetcher(srv *httptest.Server) *fetch.Fetcher {
+	return &fetch.Fetcher{Client: srv.Client(), Backoff: time.Millisecond}
+}
@@ -0,0 +1,121 @@
+package fetch
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// RobotsError is returned for a url that robots.txt asks us not to fetch.
+type RobotsError struct {
+	URL string
+}
+
+func (e *RobotsError) Error() string {
+	return fmt.Sprintf("fetch %s: disallowed by robots.txt", e.URL)
+}
+
+// Policy is how politely a Fetcher crawls: whether it follows each host's
+// robots.txt, and how long it leaves between requests to a host. The wait is
+// the longer of MinInterval and the host's Crawl-delay.
+type Policy struct {
+	MinInterval time.Duration
+	Robots      bool
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	mu          sync.Mutex
+	robots      *Robots   // nil until fetched
+	robotsRetry time.Time // when to fetch robots again, zero for never
+	next        time.Time
+}
+
+// How long a host whose robots.txt could not be reached is left alone before
+// trying it again.
+var robotsRetry = time.Minute
+
+func NewPolicy(minInterval time.Duration, robots bool) *Policy {
+	return &Policy{MinInterval: minInterval, Robots: robots}
+}
+
+func (p *Policy) host(name string) *hostState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hosts == nil {
+		p.hosts = make(map[string]*hostState)
+	}
+	h, ok := p.hosts[name]
+	if !ok {
+		h = new(hostState)
+		p.hosts[name] = h
+	}
+	return h
+}
+
+// wait blocks until f may request u, or returns a *RobotsError if it may not
+// at all. A nil Policy lets every request through at once.
+func (p *Policy) wait(f *Fetcher, u *url.URL) error {
+	if p == nil {
+		return nil
+	}
+	h := p.host(u.Host)
+	h.mu.Lock()
+	if p.Robots && (h.robots == nil || !h.robotsRetry.IsZero() && time.Now().After(h.robotsRetry)) {
+		h.robots, h.robotsRetry = p.fetchRobots(f, u)
+		h.next = time.Now().Add(p.interval(h))
+	}
+	if !h.robots.Allowed(u.RequestURI()) {
+		h.mu.Unlock()
+		return &RobotsError{URL: u.String()}
+	}
+	// Take the next free turn at the host, then wait for it unlocked so
+	// that other requests can queue behind.
+	at := h.next
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	h.next = at.Add(p.interval(h))
+	h.mu.Unlock()
+	time.Sleep(time.Until(at))
+	return nil
+}
+
+func (p *Policy) interval(h *hostState) time.Duration {
+	if h.robots != nil && h.robots.CrawlDelay > p.MinInterval {
+		return h.robots.CrawlDelay
+	}
+	return p.MinInterval
+}
+
+// fetchRobots fetches the robots.txt of u's host, and returns when to fetch
+// it again, if ever. A host without one (a 4xx response) allows everything.
+// One whose robots.txt is unreachable, through a 5xx response or a network
+// error, disallows everything until it is retried, as RFC 9309 says.
+func (p *Policy) fetchRobots(f *Fetcher, u *url.URL) (*Robots, time.Time) {
+	robotsURL := u.Scheme + "://" + u.Host + "/robots.txt"
+	req, err := http.NewRequest("GET", robotsURL, nil)
+	if err != nil {
+		return new(Robots), time.Time{}
+	}
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+	body, err := f.do(req)
+	if se, ok := err.(*StatusError); ok && se.Code >= 400 && se.Code < 500 {
+		return new(Robots), time.Time{}
+	}
+	if err != nil {
+		slog.Warn("robots.txt unreachable, not crawling the host for now",
+			"url", robotsURL, "retry", robotsRetry, "err", err)
+		return &Robots{rules: []robotsRule{{false, "/"}}}, time.Now().Add(robotsRetry)
+	}
+	r := ParseRobots(body, f.UserAgent)
+	slog.Debug("robots.txt", "url", robotsURL, "rules", len(r.rules), "crawl-delay", r.CrawlDelay)
+	return r, time.Time{}
+}
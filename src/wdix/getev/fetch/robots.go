@@ -0,0 +1,141 @@
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Robots is a parsed robots.txt, narrowed to the rules for one user agent.
+type Robots struct {
+	rules      []robotsRule
+	CrawlDelay time.Duration // zero if the file sets none
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsGroup is a set of rules and the user agents they apply to.
+type robotsGroup struct {
+	agents []string
+	rules  []robotsRule
+	delay  time.Duration
+}
+
+// ParseRobots parses a robots.txt and keeps the rules for userAgent: those
+// of the group naming its product token, compared ignoring case, else those
+// of the * group.
+func ParseRobots(data []byte, userAgent string) *Robots {
+	var groups []*robotsGroup
+	var g *robotsGroup
+	inAgents := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share a group.
+			if !inAgents {
+				g = new(robotsGroup)
+				groups = append(groups, g)
+			}
+			g.agents = append(g.agents, strings.ToLower(value))
+			inAgents = true
+			continue
+		case "allow", "disallow":
+			if g != nil && value != "" {
+				g.rules = append(g.rules, robotsRule{key == "allow", value})
+			}
+		case "crawl-delay":
+			if g != nil {
+				if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+					g.delay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+		inAgents = false
+	}
+
+	token := strings.ToLower(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	var match, star *robotsGroup
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			if agent == "*" && star == nil {
+				star = g
+			} else if token != "" && agent == token && match == nil {
+				match = g
+			}
+		}
+	}
+	if match == nil {
+		match = star
+	}
+	r := new(Robots)
+	if match != nil {
+		r.rules, r.CrawlDelay = match.rules, match.delay
+	}
+	return r
+}
+
+// Allowed reports whether path, with any query, may be fetched. The longest
+// matching rule decides, and an allow wins a tie.
+func (r *Robots) Allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+	best, allowed := -1, true
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		n := len(rule.pattern)
+		if n > best || n == best && rule.allow {
+			best, allowed = n, rule.allow
+		}
+	}
+	return allowed
+}
+
+// robotsMatch matches a path against a rule, in which * stands for any run
+// of characters and a final $ anchors the end of the path.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+		j := strings.Index(rest, part)
+		if j < 0 {
+			return false
+		}
+		rest = rest[j+len(part):]
+	}
+	return !anchored || rest == ""
+}
@@ -0,0 +1,142 @@
+package fetch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRobots = `# robots.txt for a card shop
+User-agent: *
+Disallow: /search
+Disallow: /*.aspx$
+Allow: /search/help
+Crawl-delay: 2
+
+User-agent: BadBot
+User-agent: getev
+Disallow: /magic/private
+Allow: /magic/private/ok
+Crawl-delay: 0.5
+`
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		agent, path string
+		allowed     bool
+	}{
+		{"Mozilla/5.0", "/magic/return-to-ravnica", true},
+		{"Mozilla/5.0", "/search?q=angel", false},
+		{"Mozilla/5.0", "/search/help", true},
+		{"Mozilla/5.0", "/Pages/Default.aspx", false},
+		{"Mozilla/5.0", "/Pages/Default.aspx?x=1", true},
+		{"getev/1.0", "/search?q=angel", true},
+		{"getev/1.0", "/magic/private/cards", false},
+		{"getev/1.0", "/magic/private/ok", true},
+		{"BadBot", "/magic/private", false},
+	}
+	for _, test := range tests {
+		r := ParseRobots([]byte(testRobots), test.agent)
+		if got := r.Allowed(test.path); got != test.allowed {
+			t.Errorf("%s %s: got %v, want %v", test.agent, test.path, got, test.allowed)
+		}
+	}
+
+	if d := ParseRobots([]byte(testRobots), "Mozilla/5.0").CrawlDelay; d != 2*time.Second {
+		t.Errorf("* crawl-delay: got %v", d)
+	}
+	if d := ParseRobots([]byte(testRobots), "getev/1.0").CrawlDelay; d != 500*time.Millisecond {
+		t.Errorf("getev crawl-delay: got %v", d)
+	}
+	if r := ParseRobots(nil, "getev/1.0"); !r.Allowed("/anything") {
+		t.Error("an empty robots.txt should allow everything")
+	}
+}
+
+func TestParseRobotsWholeToken(t *testing.T) {
+	const robots = "User-agent: getev\nDisallow: /\n"
+	for _, agent := range []string{"notgetev-bot-evil", "getev-bot/1.0", "get"} {
+		if r := ParseRobots([]byte(robots), agent); !r.Allowed("/page") {
+			t.Errorf("%s: matched the group for getev", agent)
+		}
+	}
+	if r := ParseRobots([]byte(robots), "GETEV/2.0"); r.Allowed("/page") {
+		t.Error("GETEV/2.0: the product token should match ignoring case")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	robotsFetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsFetches++
+			w.Write([]byte("User-agent: getev\nDisallow: /private\nCrawl-delay: 0.05\n"))
+			return
+		}
+		w.Write([]byte("page"))
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{UserAgent: "getev/1.0", Policy: NewPolicy(10*time.Millisecond, true)}
+	if _, err := f.Get(srv.URL + "/private/page"); err == nil {
+		t.Error("fetched a page robots.txt disallows")
+	} else if _, ok := err.(*RobotsError); !ok {
+		t.Errorf("got %v, want a RobotsError", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := f.Get(srv.URL + "/public"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The crawl delay is longer than the minimum interval, so it wins.
+	if took := time.Since(start); took < 100*time.Millisecond {
+		t.Errorf("three requests took %v, want at least two crawl delays", took)
+	}
+	if robotsFetches != 1 {
+		t.Errorf("robots.txt fetched %d times, want 1", robotsFetches)
+	}
+}
+
+func TestPolicyNoRobots(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	p := NewPolicy(0, true)
+	f := &Fetcher{Policy: p}
+	_, err := f.Get(srv.URL + "/page")
+	if se, ok := err.(*StatusError); !ok || se.Code != http.StatusNotFound {
+		t.Errorf("got %v, want the page's 404 after a missing robots.txt", err)
+	}
+}
+
+func TestPolicyRobotsUnreachable(t *testing.T) {
+	old := robotsRetry
+	robotsRetry = 50 * time.Millisecond
+	defer func() { robotsRetry = old }()
+	var down int32 = 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" && atomic.LoadInt32(&down) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("page"))
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{Policy: NewPolicy(0, true)}
+	if _, err := f.Get(srv.URL + "/page"); err == nil {
+		t.Error("fetched a page while robots.txt was unreachable")
+	} else if _, ok := err.(*RobotsError); !ok {
+		t.Errorf("got %v, want a RobotsError", err)
+	}
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(2 * robotsRetry)
+	if _, err := f.Get(srv.URL + "/page"); err != nil {
+		t.Errorf("robots.txt not fetched again once reachable: %v", err)
+	}
+}
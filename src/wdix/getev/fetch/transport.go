@@ -0,0 +1,69 @@
+package fetch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+)
+
+// NewTransport returns a transport that sends requests through the proxies
+// in turn, or through the proxy named in the environment (HTTP_PROXY and
+// HTTPS_PROXY) if there are none. If caFile is set, servers are also trusted
+// when their certificate is signed by one in that PEM bundle.
+func NewTransport(proxies []string, caFile string) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if len(proxies) > 0 {
+		var urls []*url.URL
+		for _, p := range proxies {
+			u, err := url.Parse(p)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("proxy %q: not a url", p)
+			}
+			urls = append(urls, u)
+		}
+		t.Proxy = rotate(urls)
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", caFile)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return t, nil
+}
+
+// rotate returns a Proxy func that hands out the proxies round robin.
+func rotate(proxies []*url.URL) func(*http.Request) (*url.URL, error) {
+	var n uint32
+	return func(*http.Request) (*url.URL, error) {
+		i := atomic.AddUint32(&n, 1) - 1
+		return proxies[int(i)%len(proxies)], nil
+	}
+}
+
+// With returns a copy of f that adds the headers and cookies to each
+// request. The copy shares f's client, cache, policy and metrics.
+func (f *Fetcher) With(header http.Header, cookies []*http.Cookie) *Fetcher {
+	g := *f
+	g.Header = make(http.Header)
+	for k, v := range f.Header {
+		g.Header[k] = v
+	}
+	for k, v := range header {
+		g.Header[k] = v
+	}
+	g.Cookies = append(append([]*http.Cookie(nil), f.Cookies...), cookies...)
+	return &g
+}
@@ -0,0 +1,99 @@
+package fetch
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestProxyRotation(t *testing.T) {
+	var hits [2]int
+	var proxies []string
+	for i := range hits {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// A proxy is asked for the absolute url.
+			if r.URL.Host != "cards.example" {
+				t.Errorf("proxy %d asked for %s", i, r.URL)
+			}
+			hits[i]++
+			w.Write([]byte("page"))
+		}))
+		defer srv.Close()
+		proxies = append(proxies, srv.URL)
+	}
+
+	transport, err := NewTransport(proxies, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Fetcher{Client: &http.Client{Transport: transport}}
+	for i := 0; i < 4; i++ {
+		if _, err := f.Get("http://cards.example/magic/gatecrash"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits != [2]int{2, 2} {
+		t.Errorf("proxies were used %v times, want 2 each", hits)
+	}
+
+	if _, err := NewTransport([]string{"not a proxy"}, ""); err == nil {
+		t.Error("expected an error for a bad proxy url")
+	}
+}
+
+func TestCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	defer srv.Close()
+
+	// Without the bundle the test server's certificate is not trusted.
+	plain, _ := NewTransport(nil, "")
+	f := &Fetcher{Client: &http.Client{Transport: plain}}
+	if _, err := f.Get(srv.URL); err == nil {
+		t.Error("trusted an unknown certificate authority")
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0644); err != nil {
+		t.Fatal(err)
+	}
+	transport, err := NewTransport(nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = &Fetcher{Client: &http.Client{Transport: transport}}
+	if body, err := f.Get(srv.URL); err != nil || string(body) != "secure" {
+		t.Errorf("got %q, %v", body, err)
+	}
+}
+
+func TestWith(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := r.Cookie("session")
+		if r.Header.Get("Referer") != "http://cards.example/" || c == nil || c.Value != "abc" {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("page"))
+	}))
+	defer srv.Close()
+
+	base := &Fetcher{Cache: NewMemoryCache()}
+	f := base.With(http.Header{"Referer": {"http://cards.example/"}},
+		[]*http.Cookie{{Name: "session", Value: "abc"}})
+	if _, err := f.Get(srv.URL); err != nil {
+		t.Error(err)
+	}
+	if base.Header != nil || base.Cookies != nil {
+		t.Error("With changed the fetcher it copied")
+	}
+	if f.Cache != base.Cache {
+		t.Error("With did not share the cache")
+	}
+}
@@ -1,80 +1,319 @@
 package main
 
 import (
-	"fmt"
+	"flag"
 	"github.com/moovweb/gokogiri/html"
-	"io/ioutil"
-	"net/http"
+	"log/slog"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"wdix/getev/alert"
+	"wdix/getev/checkpoint"
+	"wdix/getev/config"
+	"wdix/getev/ev"
+	"wdix/getev/fetch"
+	"wdix/getev/metrics"
 	"wdix/getev/pricefetch"
 )
 
-const ravURL string = "http://gatherer.wizards.com/Pages/Search/Default.aspx?output=checklist&action=advanced&set=%5b%22Return+to+Ravnica%22%5d"
+var (
+	alertsPath = flag.String("alerts", "", "price-alert rules file to check after fetching")
+	statePath  = flag.String("state", "getev-state.json", "where to keep prices between runs for alerts, with the set code added to the name when several sets are priced")
+)
 
 func waitForCards(responseChannel chan pricefetch.Card, numberOfCards int) (cards []pricefetch.Card) {
 	returnedCount := 0
-	for {
+	for returnedCount < numberOfCards {
 		cards = append(cards, <-responseChannel)
 		returnedCount++
-
-		if returnedCount >= numberOfCards {
-			break
-		}
 	}
 	return
 }
 
-func fetchCardNames(names *[]string) {
-	res, err := http.Get(ravURL)
+// The parts of a Gatherer checklist that are read, the last three relative to
+// a row.
+const (
+	rowXPath    = "//tr[@class='cardItem']"
+	nameXPath   = "./td[@class='name']"
+	rarityXPath = "./td[@class='rarity']"
+	linkXPath   = "./td[@class='name']/a"
+)
+
+// checklistEntry is a row of a Gatherer checklist.
+type checklistEntry struct {
+	Name      string
+	Rarity    string // "C", "U", "R", "M" or "L", empty if not given
+	DetailURL string // the card's detail page, empty if the row has no link
+}
+
+func fetchCardNames(url string, names *[]string) {
+	for _, entry := range fetchChecklist(url) {
+		*names = append(*names, entry.Name)
+	}
+}
+
+// fetchChecklist fetches the Gatherer checklist at pageURL and returns its
+// rows, with their detail links made absolute.
+func fetchChecklist(pageURL string) []checklistEntry {
+	response, err := fetch.Default.Get(pageURL)
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("fetching checklist", "url", pageURL, "err", err)
+		return nil
 	}
-	response, err := ioutil.ReadAll(res.Body)
+	entries, err := parseChecklist(response, pageURL)
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("parsing checklist", "url", pageURL, "err", err)
 	}
-	res.Body.Close()
+	slog.Info("checklist", "url", pageURL, "cards", len(entries))
+	return entries
+}
 
-	doc, err := html.Parse(response, html.DefaultEncodingBytes, nil, html.DefaultParseOption, html.DefaultEncodingBytes)
+// parseChecklist returns the rows of the checklist page fetched from pageURL.
+// Rows without a name are skipped.
+func parseChecklist(page []byte, pageURL string) (entries []checklistEntry, err error) {
+	doc, err := html.Parse(page, html.DefaultEncodingBytes, nil, html.DefaultParseOption, html.DefaultEncodingBytes)
 
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 
 	html := doc.Root().FirstChild()
 	defer doc.Free()
 
-	results, err := html.Search("//tr[@class='cardItem']")
+	results, err := html.Search(rowXPath)
 
 	for _, row := range results {
 
-		name, err := row.Search("./td[@class='name']")
+		name, err := row.Search(nameXPath)
 
-		if err != nil {
-			fmt.Println(err)
+		if err != nil || len(name) == 0 {
 			continue
 		}
 
-		stringName := name[0].Content()
-		*names = append(*names, stringName)
+		entry := checklistEntry{Name: strings.TrimSpace(name[0].Content())}
+		if rarity, err := row.Search(rarityXPath); err == nil && len(rarity) > 0 {
+			entry.Rarity = strings.TrimSpace(rarity[0].Content())
+		}
+		if links, err := row.Search(linkXPath); err == nil && len(links) > 0 {
+			entry.DetailURL = resolveUrl(pageURL, links[0].Attr("href"))
+		}
+		entries = append(entries, entry)
 	}
 
+	return
+}
+
+// resolveUrl resolves the link href found on the page at base.
+func resolveUrl(base, href string) string {
+	if href == "" {
+		return ""
+	}
+	b, err := url.Parse(base)
 	if err != nil {
-		fmt.Println(err)
+		return href
 	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	return b.ResolveReference(ref).String()
+}
 
-	return
+// lookupCards prices the checklist's cards, at most concurrency at a time,
+// recording each priced card in cp. Cards that could not be priced are
+// reported and count as free.
+func lookupCards(src *pricefetch.Source, set string, entries []checklistEntry, concurrency int, cp *checkpoint.Writer) []pricefetch.Card {
+	cardChannel := make(chan pricefetch.Card)
+	slots := make(chan struct{}, concurrency)
+
+	for _, entry := range entries {
+		go func(entry checklistEntry) {
+			slots <- struct{}{}
+			card, err := src.LookupCard(set, entry.Name)
+			<-slots
+			if err != nil {
+				slog.Warn("no price", "card", entry.Name, "set", set, "err", err)
+				metrics.Default.Inc(metrics.CardsFailed)
+				card = pricefetch.Card{Name: entry.Name, Set: set, Unpriced: true}
+			} else {
+				slog.Debug("priced", "card", entry.Name, "set", set, "price", card.Price)
+				metrics.Default.Inc(metrics.CardsPriced)
+			}
+			card.Rarity = entry.Rarity
+			if err == nil {
+				if err := cp.Record(card); err != nil {
+					slog.Warn("recording checkpoint", "card", entry.Name, "err", err)
+				}
+			}
+			cardChannel <- card
+		}(entry)
+	}
+	return waitForCards(cardChannel, len(entries))
+}
+
+// packValue returns the EV of a pack of the set, from its layout if it has
+// one, and the mean value of simulate packs opened at random, if any.
+func packValue(cfg *config.Config, set *config.Set, cards []pricefetch.Card) (packEV, simulated float64) {
+	layout := cfg.EV.Layout(set.Code)
+	if layout == nil {
+		return ev.Pack(cards, cfg.EV.PackSize), 0
+	}
+	packEV, err := ev.LayoutPack(layout, cards)
+	if err != nil {
+		slog.Warn("treating every card as equally likely", "layout", layout.Name, "err", err)
+		return ev.Pack(cards, cfg.EV.PackSize), 0
+	}
+	if cfg.EV.Simulate > 0 {
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		simulated, _ = ev.Simulate(layout, cards, cfg.EV.Simulate, rng)
+	}
+	return packEV, simulated
+}
+
+// priceReprints prices the cards the set's layout puts in its packs that are
+// not on its checklist, each from the set its layout names.
+func priceReprints(cfg *config.Config, src *pricefetch.Source, set *config.Set) []pricefetch.Card {
+	layout := cfg.EV.Layout(set.Code)
+	if layout == nil {
+		return nil
+	}
+	bySet := make(map[string][]checklistEntry)
+	for name, code := range layout.Reprints {
+		bySet[code] = append(bySet[code], checklistEntry{Name: name})
+	}
+	var cards []pricefetch.Card
+	for code, entries := range bySet {
+		cards = append(cards, lookupCards(src, code, entries, cfg.Concurrency, nil)...)
+	}
+	return cards
+}
+
+func checkAlerts(cards []pricefetch.Card, packEV float64, statePath string) {
+	config, err := alert.LoadConfig(*alertsPath)
+	if err != nil {
+		slog.Error("loading alert rules", "err", err)
+		return
+	}
+	notifiers, err := config.Build()
+	if err != nil {
+		slog.Error("setting up notifiers", "err", err)
+		return
+	}
+	prev, loadErr := alert.LoadSnapshot(statePath)
+	if loadErr != nil {
+		slog.Warn("loading previous prices", "path", statePath, "err", loadErr)
+	}
+	curr := alert.NewSnapshot(cards, packEV)
+	alerts := alert.Check(config.Rules, prev, curr)
+	slog.Info("alerts checked", "rules", len(config.Rules), "fired", len(alerts))
+	if err := alert.NotifyAll(notifiers, alerts); err != nil {
+		slog.Error("sending alerts", "err", err)
+	}
+	// An unreadable state file is left alone, so the history it holds is
+	// there for the next run.
+	if loadErr != nil {
+		return
+	}
+	if err := curr.Save(statePath); err != nil {
+		slog.Error("saving prices", "path", statePath, "err", err)
+	}
 }
 
 func main() {
-	var names = make([]string, 0, 1)
-	fetchCardNames(&names)
-	fmt.Println(names)
+	flag.Parse()
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("loading config", "err", err)
+		os.Exit(1)
+	}
+	if err := applyConfig(cfg); err != nil {
+		slog.Error("applying config", "err", err)
+		os.Exit(1)
+	}
 
-	cardChannel := make(chan pricefetch.Card)
+	switch flag.Arg(0) {
+	case "collection":
+		runCollection(cfg, flag.Args()[1:])
+		return
+	case "draft":
+		runDraft(cfg, flag.Args()[1:])
+		return
+	case "check":
+		if !runCheck(cfg, flag.Args()[1:]) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	sets, _ := cfg.SelectedSets()
+	src := pricefetch.Default
+
+	// The sets are priced one after another, so they share the fetcher's
+	// cache and the concurrency limit.
+	var reports []*report
+	for _, set := range sets {
+		reports = append(reports, priceSet(cfg, src, set))
+	}
+
+	if len(reports) == 1 {
+		if err := reports[0].write(os.Stdout, cfg.Output); err != nil {
+			slog.Error("writing report", "err", err)
+		}
+	} else if err := writeComparison(os.Stdout, reports, cfg); err != nil {
+		slog.Error("writing report", "err", err)
+	}
+
+	if *alertsPath != "" {
+		for _, r := range reports {
+			checkAlerts(r.Cards, r.PackEV, statePathFor(r.Code, len(reports)))
+		}
+	}
+	metrics.Default.WriteSummary(os.Stderr)
+}
+
+// priceSet prices the cards of a set and values its packs and sealed
+// products.
+func priceSet(cfg *config.Config, src *pricefetch.Source, set *config.Set) *report {
+	r := pricePacks(cfg, src, set)
+	if src.SealedURL != "" {
+		sealed := fetchSealedPrices(src, set.Code, cfg.EV.Products())
+		r.Sealed = ev.Compare(sealed, r.PackEV)
+	}
+	return r
+}
+
+// pricePacks prices the cards of a set and values its packs, without
+// fetching the prices of its sealed products.
+func pricePacks(cfg *config.Config, src *pricefetch.Source, set *config.Set) *report {
+	entries := fetchChecklist(checklistUrl(set))
+
+	cards := priceCards(cfg, src, set.Code, entries)
+	if cfg.Enrich {
+		enrichCards(cards, entries, cfg.Concurrency)
+	}
+	packEV, simulated := packValue(cfg, set, append(priceReprints(cfg, src, set), cards...))
+
+	return &report{
+		Set:         set.Name,
+		Code:        set.Code,
+		Source:      src.Name,
+		Cards:       cards,
+		PackEV:      packEV,
+		SimulatedEV: simulated,
+	}
+}
 
-	for _, cardName := range names {
-		go pricefetch.LookupCard(cardChannel, cardName)
+// statePathFor returns the alert state file for a set. When a run prices
+// several sets each keeps its own, named after the set code, so a run only
+// compares against earlier runs of the same set. A run of a single set keeps
+// using the file as named, as it always has.
+func statePathFor(code string, sets int) string {
+	if sets == 1 {
+		return *statePath
 	}
-	cards := waitForCards(cardChannel, len(names))
-	fmt.Println(cards)
+	ext := filepath.Ext(*statePath)
+	return strings.TrimSuffix(*statePath, ext) + "-" + strings.ToLower(code) + ext
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"wdix/getev/config"
+	"wdix/getev/fetch"
+	"wdix/getev/fetch/fetchtest"
+	"wdix/getev/pricefetch"
+)
+
+// useFixtures points fetch.Default at the saved pages for the length of the
+// test.
+func useFixtures(t *testing.T) string {
+	srv := fetchtest.NewServer("testdata")
+	old := fetch.Default
+	fetch.Default = fetchtest.NewFetcher(srv)
+	t.Cleanup(func() {
+		fetch.Default = old
+		srv.Close()
+	})
+	return srv.URL
+}
+
+func TestFetchChecklist(t *testing.T) {
+	base := useFixtures(t)
+	tests := []struct {
+		page string
+		want []checklistEntry
+	}{
+		{"/gatherer/checklist.html", []checklistEntry{
+			{"Angel of Serenity", "M", base + "/Card/Details.aspx?multiverseid=253624"},
+			{"Dramatic Rescue", "C", base + "/Card/Details.aspx?multiverseid=253520"},
+			{"Dreg Mangler", "U", base + "/Card/Details.aspx?multiverseid=253538"},
+			{"Forest", "L", ""},
+		}},
+		{"/gatherer/no-results.html", nil},
+		// Unclosed cells, and a row with no name cell.
+		{"/gatherer/malformed.html", []checklistEntry{
+			{"Angel of Serenity", "", base + "/Card/Details.aspx?multiverseid=253624"},
+			{"Forest", "L", ""},
+		}},
+		{"/gatherer/missing.html", nil},
+	}
+	for _, test := range tests {
+		got := fetchChecklist(base + test.page)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", test.page, got, test.want)
+		}
+	}
+}
+
+func TestFetchCardNames(t *testing.T) {
+	base := useFixtures(t)
+	var names []string
+	fetchCardNames(base+"/gatherer/checklist.html", &names)
+	want := []string{"Angel of Serenity", "Dramatic Rescue", "Dreg Mangler", "Forest"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+}
+
+func TestFetchCardDetails(t *testing.T) {
+	base := useFixtures(t)
+	info, err := fetchCardDetails(base + "/gatherer/details.html?multiverseid=253624")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &pricefetch.CardInfo{
+		MultiverseID: 253624,
+		Type:         "Creature  — Angel",
+		Text: "Flying\n" +
+			"When Angel of Serenity enters the battlefield, you may exile up to three other target creatures from the battlefield and/or creature cards from graveyards until Angel of Serenity leaves the battlefield.\n" +
+			"When Angel of Serenity leaves the battlefield, return the exiled cards to their owners' hands.",
+		Artist:   "Aleksi Briclot",
+		ImageURL: base + "/Handlers/Image.ashx?multiverseid=253624&type=card",
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got  %+v\nwant %+v", info, want)
+	}
+}
+
+func TestLookupCards(t *testing.T) {
+	srv := fetchtest.NewServer("pricefetch/testdata/tcgplayer")
+	defer srv.Close()
+	src := *pricefetch.TCGplayer
+	src.CardURL = srv.URL + "/magic/{set}/{card}"
+	src.Fetcher = fetchtest.NewFetcher(srv)
+
+	entries := []checklistEntry{
+		{Name: "Angel of Serenity", Rarity: "M"},
+		{Name: "Dreg Mangler", Rarity: "U"},
+	}
+	cards := lookupCards(&src, "RTR", entries, 2, nil)
+	got := make(map[string]pricefetch.Card)
+	for _, c := range cards {
+		got[c.Name] = c
+	}
+	if c := got["Angel of Serenity"]; c.Price != 10.25 || c.Rarity != "M" {
+		t.Errorf("Angel of Serenity: got %+v", c)
+	}
+	// A card with no price is kept, as free.
+	if c, ok := got["Dreg Mangler"]; !ok || c.Price != 0 || !c.Unpriced || c.Rarity != "U" {
+		t.Errorf("Dreg Mangler: got %+v", c)
+	}
+}
+
+func TestApplyConfigDecode(t *testing.T) {
+	cfg := config.Default()
+	oldFetcher, oldSource, oldLogger := fetch.Default, pricefetch.Default, slog.Default()
+	oldSlugs := make(map[string]string)
+	for _, s := range cfg.Sets {
+		oldSlugs[s.Code] = pricefetch.SetSlug(s.Code)
+	}
+	t.Cleanup(func() {
+		fetch.Default, pricefetch.Default = oldFetcher, oldSource
+		slog.SetDefault(oldLogger)
+		for code, slug := range oldSlugs {
+			pricefetch.AddSet(code, slug)
+		}
+	})
+	cfg.HTTP.Decode = true
+	if err := applyConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !fetch.Default.Decode {
+		t.Error("http.decode not passed on to the fetcher")
+	}
+}
+
+func TestCheckAlertsKeepsUnreadableState(t *testing.T) {
+	dir := t.TempDir()
+	rules := filepath.Join(dir, "alerts.json")
+	if err := ioutil.WriteFile(rules, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := *alertsPath
+	*alertsPath = rules
+	defer func() { *alertsPath = old }()
+	cards := []pricefetch.Card{{Name: "Abrupt Decay", Price: 15}}
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := ioutil.WriteFile(corrupt, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkAlerts(cards, 3, corrupt)
+	if data, _ := ioutil.ReadFile(corrupt); string(data) != "{not json" {
+		t.Errorf("unreadable state overwritten with %q", data)
+	}
+
+	missing := filepath.Join(dir, "missing.json")
+	checkAlerts(cards, 3, missing)
+	if _, err := os.Stat(missing); err != nil {
+		t.Errorf("no state saved for a first run: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Package metrics counts what a getev run did: requests, retries, cache hits,
+cards priced, and how long each host took to answer.
+
+A Registry can print a summary at the end of a run, and serves its values in
+the Prometheus text format as an http.Handler.
+*/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The counters kept by getev.
+const (
+	Requests      = "fetch_requests"      // HTTP requests made, retries included
+	RequestErrors = "fetch_errors"        // fetches that failed after any retries
+	Retries       = "fetch_retries"       // requests that were tried again
+	Disallowed    = "fetch_disallowed"    // requests robots.txt ruled out
+	CacheHits     = "cache_hits"          // pages served from the cache
+	CacheMisses   = "cache_misses"        // pages not in the cache
+	CardsPriced   = "cards_priced"        // cards given a price
+	CardsFailed   = "cards_failed"        // cards that could not be priced
+	CardsEnriched = "cards_enriched"      // cards given their Gatherer details
+	EnrichFailed  = "cards_enrich_failed" // cards whose details could not be read
+)
+
+// Registry holds counters and per host latencies. Its methods may be called
+// from several goroutines, and on a nil Registry, which records nothing.
+type Registry struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	latency  map[string][]time.Duration
+}
+
+func New() *Registry {
+	return &Registry{
+		counters: make(map[string]int64),
+		latency:  make(map[string][]time.Duration),
+	}
+}
+
+// Default is the registry getev records into.
+var Default = New()
+
+// Add adds n to the named counter.
+func (r *Registry) Add(name string, n int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.counters[name] += n
+	r.mu.Unlock()
+}
+
+// Inc adds one to the named counter.
+func (r *Registry) Inc(name string) {
+	r.Add(name, 1)
+}
+
+// Count returns the value of the named counter.
+func (r *Registry) Count(name string) int64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[name]
+}
+
+// Observe records how long a request to host took.
+func (r *Registry) Observe(host string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.latency[host] = append(r.latency[host], d)
+	r.mu.Unlock()
+}
+
+// Hosts returns the hosts with recorded latencies, sorted.
+func (r *Registry) Hosts() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var hosts []string
+	for h := range r.latency {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Percentile returns the latency of host below which p percent of its
+// requests fell, or 0 if there were none.
+func (r *Registry) Percentile(host string, p float64) time.Duration {
+	ds := r.sorted(host)
+	return percentile(ds, p)
+}
+
+func (r *Registry) sorted(host string) []time.Duration {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	ds := append([]time.Duration(nil), r.latency[host]...)
+	r.mu.Unlock()
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds
+}
+
+// percentile picks the nearest rank from sorted latencies.
+func percentile(ds []time.Duration, p float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	i := int(p/100*float64(len(ds))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(ds) {
+		i = len(ds) - 1
+	}
+	return ds[i]
+}
+
+// CacheHitRate returns the share of pages served from the cache, or 0 if
+// there was no cache.
+func (r *Registry) CacheHitRate() float64 {
+	hits, misses := r.Count(CacheHits), r.Count(CacheMisses)
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// The percentiles reported for each host.
+var quantiles = []float64{50, 90, 99}
+
+// WriteSummary writes a summary of the run for people to read.
+func (r *Registry) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "cards: %d priced, %d failed\n", r.Count(CardsPriced), r.Count(CardsFailed))
+	if n, f := r.Count(CardsEnriched), r.Count(EnrichFailed); n+f > 0 {
+		fmt.Fprintf(w, "details: %d read, %d failed\n", n, f)
+	}
+	fmt.Fprintf(w, "requests: %d made, %d retried, %d failed\n",
+		r.Count(Requests), r.Count(Retries), r.Count(RequestErrors))
+	if n := r.Count(Disallowed); n > 0 {
+		fmt.Fprintf(w, "robots.txt: %d disallowed\n", n)
+	}
+	fmt.Fprintf(w, "cache: %d hits, %d misses (%.0f%%)\n",
+		r.Count(CacheHits), r.Count(CacheMisses), 100*r.CacheHitRate())
+	for _, host := range r.Hosts() {
+		ds := r.sorted(host)
+		var parts []string
+		for _, q := range quantiles {
+			parts = append(parts, fmt.Sprintf("p%.0f %s", q, percentile(ds, q).Round(time.Millisecond)))
+		}
+		fmt.Fprintf(w, "  %s: %d requests, %s\n", host, len(ds), strings.Join(parts, ", "))
+	}
+}
+
+// WritePrometheus writes the counters and latencies in the Prometheus text
+// format.
+func (r *Registry) WritePrometheus(w io.Writer) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	var names []string
+	for name := range r.counters {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE getev_%s_total counter\n", name)
+		fmt.Fprintf(w, "getev_%s_total %d\n", name, r.Count(name))
+	}
+
+	hosts := r.Hosts()
+	if len(hosts) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# TYPE getev_fetch_latency_seconds summary\n")
+	for _, host := range hosts {
+		ds := r.sorted(host)
+		var sum time.Duration
+		for _, d := range ds {
+			sum += d
+		}
+		for _, q := range quantiles {
+			fmt.Fprintf(w, "getev_fetch_latency_seconds{host=%q,quantile=\"%g\"} %g\n",
+				host, q/100, percentile(ds, q).Seconds())
+		}
+		fmt.Fprintf(w, "getev_fetch_latency_seconds_sum{host=%q} %g\n", host, sum.Seconds())
+		fmt.Fprintf(w, "getev_fetch_latency_seconds_count{host=%q} %d\n", host, len(ds))
+	}
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WritePrometheus(w)
+}
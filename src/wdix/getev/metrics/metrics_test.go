@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	r := New()
+	for i := 1; i <= 100; i++ {
+		r.Observe("example.com", time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := r.Percentile("example.com", test.p); got != test.want {
+			t.Errorf("p%v: got %v, want %v", test.p, got, test.want)
+		}
+	}
+	if got := r.Percentile("other.com", 50); got != 0 {
+		t.Errorf("unknown host: got %v", got)
+	}
+}
+
+func TestNilRegistry(t *testing.T) {
+	var r *Registry
+	r.Inc(Requests)
+	r.Observe("example.com", time.Second)
+	if r.Count(Requests) != 0 || r.Hosts() != nil {
+		t.Error("a nil registry recorded something")
+	}
+	var buf bytes.Buffer
+	r.WritePrometheus(&buf)
+}
+
+func TestSummary(t *testing.T) {
+	r := New()
+	r.Add(CardsPriced, 270)
+	r.Inc(CardsFailed)
+	r.Add(CacheHits, 3)
+	r.Inc(CacheMisses)
+	r.Observe("store.tcgplayer.com", 200*time.Millisecond)
+	if got := r.CacheHitRate(); got != 0.75 {
+		t.Errorf("hit rate: got %v, want 0.75", got)
+	}
+	var buf bytes.Buffer
+	r.WriteSummary(&buf)
+	for _, want := range []string{
+		"cards: 270 priced, 1 failed",
+		"cache: 3 hits, 1 misses (75%)",
+		"store.tcgplayer.com: 1 requests, p50 200ms",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary is missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := New()
+	r.Add(Requests, 5)
+	r.Observe("example.com", 1500*time.Millisecond)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		"getev_fetch_requests_total 5\n",
+		`getev_fetch_latency_seconds{host="example.com",quantile="0.5"} 1.5`,
+		`getev_fetch_latency_seconds_count{host="example.com"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
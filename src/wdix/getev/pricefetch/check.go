@@ -0,0 +1,68 @@
+package pricefetch
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// SelectorError reports a selector that no longer finds what it should on a
+// page, which usually means the site changed its markup.
+type SelectorError struct {
+	Selector string // "price" or "condition"
+	Pattern  string
+	URL      string
+	Found    string // what the selector matched, empty if nothing
+}
+
+func (e *SelectorError) Error() string {
+	if e.Found == "" {
+		return fmt.Sprintf("%s selector %s matched nothing on %s", e.Selector, e.Pattern, e.URL)
+	}
+	return fmt.Sprintf("%s selector %s matched %q on %s, which is not a price",
+		e.Selector, e.Pattern, e.Found, e.URL)
+}
+
+// CheckResult is what the source's selectors found on a card's page.
+type CheckResult struct {
+	URL      string
+	Price    float64
+	Listings int // listings found by the condition selector, -1 if it has none
+}
+
+// Check fetches a card's page and runs each of the source's selectors on it,
+// returning a *SelectorError for the first one that fails.
+func (s *Source) Check(set, name string) (CheckResult, error) {
+	r := CheckResult{URL: s.CardUrl(set, name), Listings: -1}
+	page, err := s.fetchUrl(r.URL)
+	if err != nil {
+		return r, err
+	}
+
+	price, err := s.findPrice(page)
+	if err != nil {
+		return r, &SelectorError{Selector: "price", Pattern: s.Price.String(), URL: r.URL}
+	}
+	if r.Price, err = strconv.ParseFloat(stripPrice(price), 64); err != nil {
+		return r, &SelectorError{Selector: "price", Pattern: s.Price.String(), URL: r.URL, Found: price}
+	}
+
+	if s.Conditions == nil {
+		return r, nil
+	}
+	matches := s.Conditions.FindAllStringSubmatch(page, -1)
+	if len(matches) == 0 {
+		return r, &SelectorError{Selector: "condition", Pattern: s.Conditions.String(), URL: r.URL}
+	}
+	pi := groupIndex(s.Conditions, "price")
+	r.Listings = 0
+	for _, m := range matches {
+		if _, err := strconv.ParseFloat(stripPrice(m[pi]), 64); err == nil {
+			r.Listings++
+		}
+	}
+	if r.Listings == 0 {
+		return r, &SelectorError{Selector: "condition", Pattern: s.Conditions.String(),
+			URL: r.URL, Found: matches[0][pi]}
+	}
+	return r, nil
+}
@@ -0,0 +1,42 @@
+package pricefetch
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	s := testSource(t)
+	tests := []struct {
+		card     string
+		selector string // the selector that should fail, if any
+		found    string
+	}{
+		{"Angel of Serenity", "", ""},
+		{"Dreg Mangler", "price", ""},
+		{"Forest", "price", "N/A"},
+		{"Dramatic Rescue", "condition", ""},
+	}
+	for _, test := range tests {
+		r, err := s.Check("RTR", test.card)
+		if test.selector == "" {
+			if err != nil || r.Price != 10.25 || r.Listings != 3 {
+				t.Errorf("%s: got %+v, %v", test.card, r, err)
+			}
+			continue
+		}
+		se, ok := err.(*SelectorError)
+		if !ok {
+			t.Errorf("%s: got %v, want a SelectorError", test.card, err)
+			continue
+		}
+		if se.Selector != test.selector || se.Found != test.found || se.URL != r.URL {
+			t.Errorf("%s: got %+v", test.card, se)
+		}
+	}
+
+	if _, err := s.Check("RTR", "Not a Card"); err == nil {
+		t.Error("missing page: expected an error")
+	} else if _, ok := err.(*SelectorError); ok {
+		t.Errorf("missing page: got %v, want a fetch error", err)
+	}
+}
@@ -1,23 +1,68 @@
 package pricefetch
 
 import (
-	"fmt"
-	"io/ioutil"
-	"net/http"
+	"errors"
+	"log/slog"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
 type Card struct {
-	name  string
-	price float64
+	Name   string    `json:"name"`
+	Set    string    `json:"set"`
+	Rarity string    `json:"rarity,omitempty"` // "C", "U", "R", "M" or "L"
+	Price  float64   `json:"price"`
+	Info   *CardInfo `json:"info,omitempty"`
+	// Unpriced is set when the price could not be fetched. Price is then 0
+	// and the card counts as free.
+	Unpriced bool `json:"unpriced,omitempty"`
+}
+
+// CardInfo is the metadata of a card from its Gatherer detail page.
+type CardInfo struct {
+	MultiverseID int    `json:"multiverseId"`
+	Type         string `json:"type"`
+	Text         string `json:"text"`
+	Artist       string `json:"artist"`
+	ImageURL     string `json:"imageUrl"`
+}
+
+// The set priced when none is given.
+const DefaultSet = "return-to-ravnica"
+
+var ErrNoPrice = errors.New("pricefetch: no price found")
+
+var setSlugs = map[string]string{
+	"RTR": "return-to-ravnica",
+	"GTC": "gatecrash",
+	"DGM": "dragons-maze",
+}
+
+// AddSet registers the slug for a set code, so that SetSlug(code) finds it.
+func AddSet(code, slug string) {
+	setSlugs[strings.ToUpper(code)] = slug
+}
+
+// SetSlug turns a set code or name ("RTR", "Return to Ravnica") into the form
+// used in TCGplayer urls ("return-to-ravnica").
+func SetSlug(set string) string {
+	if set == "" {
+		return DefaultSet
+	}
+	if slug, ok := setSlugs[strings.ToUpper(set)]; ok {
+		return slug
+	}
+	fields := strings.Fields(strings.ToLower(set))
+	return strings.Join(fields, "-")
 }
 
 func CardUrl(name string) string {
-	s := []string{"http://store.tcgplayer.com/magic/return-to-ravnica/", name}
-	return strings.Join(s, "")
+	return SetCardUrl(DefaultSet, name)
+}
 
+func SetCardUrl(set, name string) string {
+	return Default.CardUrl(set, name)
 }
 
 type myRegexp struct {
@@ -44,33 +89,78 @@ func (r *myRegexp) FindStringSubmatchMap(s string) map[string]string {
 	return captures
 }
 
+// Quote holds the prices found on a card's product page.
+type Quote struct {
+	Price float64 // the average price
+	// The lowest listed price for each condition, as the page names them
+	// ("Near Mint", "Lightly Played"). Empty if the page has no listings.
+	Conditions map[string]float64
+}
+
+// SealedProduct is a sealed product sold for every set.
+type SealedProduct struct {
+	Name  string
+	Slug  string // fills in {product} in a source's sealed url
+	Packs int    // the number of booster packs inside
+}
+
+var (
+	BoosterPack = SealedProduct{"Booster Pack", "booster-pack", 1}
+	BoosterBox  = SealedProduct{"Booster Box", "booster-box", 36}
+	FatPack     = SealedProduct{"Fat Pack", "fat-pack", 8}
+)
+
+// The sealed products priced for each set.
+var SealedProducts = []SealedProduct{BoosterPack, BoosterBox, FatPack}
+
+func SealedUrl(set string, product SealedProduct) string {
+	return Default.SealedUrl(set, product)
+}
+
 func FetchCardPrice(name string) (price string) {
 	price = "0.0"
-	url := CardUrl(name)
-	res, _ := http.Get(url)
-	response, _ := ioutil.ReadAll(res.Body)
-	res.Body.Close()
-	avgRegex := myRegexp{regexp.MustCompile(`<td class=\"avg\">(?P<price>.*?)</td>`)}
-	captures := avgRegex.FindStringSubmatchMap(string(response))
-	elem, ok := captures["price"]
-	if ok {
+	if elem, err := FetchSetCardPrice(DefaultSet, name); err == nil {
 		price = elem
 	}
 	return
 }
 
+// FetchSetCardPrice fetches the average price of a card in the given set from
+// the Default source. It returns ErrNoPrice if the page has no price on it.
+func FetchSetCardPrice(set, name string) (string, error) {
+	return Default.FetchCardPrice(set, name)
+}
+
+// LookupSetCard fetches and parses the price of a card in the given set.
+func LookupSetCard(set, name string) (Card, error) {
+	return Default.LookupCard(set, name)
+}
+
+// FetchQuote fetches the average price of a card along with the cheapest
+// listing in each condition.
+func FetchQuote(set, name string) (Quote, error) {
+	return Default.FetchQuote(set, name)
+}
+
+// FetchSealedPrice fetches the average price of a sealed product of the set.
+func FetchSealedPrice(set string, product SealedProduct) (float64, error) {
+	return Default.FetchSealedPrice(set, product)
+}
+
 func LookupCard(returnChannel chan Card, name string) {
 	price := FetchCardPrice(name)
 	floatPrice := parsePriceString(price)
-	fmt.Println("completed: ", name)
-	returnChannel <- Card{name, floatPrice}
+	slog.Debug("completed", "card", name, "price", floatPrice)
+	returnChannel <- Card{Name: name, Set: DefaultSet, Price: floatPrice}
 }
 
 func parsePriceString(price string) (cost float64) {
-	replacer := strings.NewReplacer("$", "")
-	stripped := replacer.Replace(price)
-
-	cost, _ = strconv.ParseFloat(stripped, 64)
+	cost, _ = strconv.ParseFloat(stripPrice(price), 64)
 
 	return
 }
+
+func stripPrice(price string) string {
+	replacer := strings.NewReplacer("$", "", ",", "")
+	return strings.TrimSpace(replacer.Replace(price))
+}
@@ -0,0 +1,113 @@
+package pricefetch
+
+import (
+	"testing"
+	"wdix/getev/fetch/fetchtest"
+)
+
+// testSource returns the TCGplayer source pointed at the saved pages.
+func testSource(t *testing.T) *Source {
+	srv := fetchtest.NewServer("testdata/tcgplayer")
+	t.Cleanup(srv.Close)
+	s := *TCGplayer
+	s.CardURL = srv.URL + "/magic/{set}/{card}"
+	s.SealedURL = srv.URL + "/magic/{set}/{set}-{product}"
+	s.Fetcher = fetchtest.NewFetcher(srv)
+	return &s
+}
+
+func TestLookupCard(t *testing.T) {
+	s := testSource(t)
+	tests := []struct {
+		name    string
+		price   float64
+		wantErr bool
+	}{
+		{"Angel of Serenity", 10.25, false},
+		{"Dramatic Rescue", 0.15, false}, // padded with spaces
+		{"Dreg Mangler", 0, true},        // no price on the page
+		{"Forest", 0, true},              // cut off, with "N/A" for a price
+		{"Not a Card", 0, true},          // 404
+	}
+	for _, test := range tests {
+		card, err := s.LookupCard("RTR", test.name)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", test.name, card)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if card.Name != test.name || card.Set != "RTR" || card.Price != test.price {
+			t.Errorf("%s: got %+v, want price %v", test.name, card, test.price)
+		}
+	}
+}
+
+func TestFetchCardPriceMissing(t *testing.T) {
+	s := testSource(t)
+	if _, err := s.FetchCardPrice("RTR", "Dreg Mangler"); err != ErrNoPrice {
+		t.Errorf("got %v, want ErrNoPrice", err)
+	}
+}
+
+func TestFetchQuote(t *testing.T) {
+	s := testSource(t)
+	q, err := s.FetchQuote("RTR", "Angel of Serenity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"Near Mint": 10.99, "Lightly Played": 9.75}
+	if q.Price != 10.25 || len(q.Conditions) != len(want) {
+		t.Fatalf("got %+v, want price 10.25 and %v", q, want)
+	}
+	for c, p := range want {
+		if q.Conditions[c] != p {
+			t.Errorf("%s: got %v, want %v", c, q.Conditions[c], p)
+		}
+	}
+}
+
+func TestFetchQuoteAdjacentRows(t *testing.T) {
+	s := testSource(t)
+	q, err := s.FetchQuote("RTR", "Pack Rat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the sold out listing has no price, and doesn't take the next row's
+	if len(q.Conditions) != 1 || q.Conditions["Near Mint"] != 14.50 {
+		t.Errorf("got %v, want only Near Mint at 14.50", q.Conditions)
+	}
+}
+
+func TestFetchSealedPrice(t *testing.T) {
+	s := testSource(t)
+	price, err := s.FetchSealedPrice("RTR", BoosterBox)
+	if err != nil || price != 104.50 {
+		t.Errorf("booster box: got %v, %v, want 104.50", price, err)
+	}
+	if _, err := s.FetchSealedPrice("RTR", FatPack); err == nil {
+		t.Error("fat pack: expected an error for a missing page")
+	}
+}
+
+func TestParsePriceString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"$10.25", 10.25},
+		{" $1,299.99 ", 1299.99},
+		{"0.15", 0.15},
+		{"N/A", 0},
+		{"", 0},
+	}
+	for _, test := range tests {
+		if got := parsePriceString(test.in); got != test.want {
+			t.Errorf("%q: got %v, want %v", test.in, got, test.want)
+		}
+	}
+}
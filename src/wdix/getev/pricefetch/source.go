@@ -0,0 +1,185 @@
+package pricefetch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"wdix/getev/fetch"
+)
+
+// Source is a site that prices cards. Its urls are templates in which {set},
+// {card} and {product} are replaced by the set slug, the card name and the
+// sealed product slug.
+type Source struct {
+	Name      string
+	CardURL   string
+	SealedURL string
+	// Price matches the price on a product page in a group named "price".
+	Price *regexp.Regexp
+	// Conditions, if set, matches each listing on a card's page with groups
+	// named "condition" and "price".
+	Conditions *regexp.Regexp
+	// Fetcher fetches the pages, fetch.Default if nil.
+	Fetcher *fetch.Fetcher
+}
+
+// TCGplayer is the built in TCGplayer source.
+var TCGplayer = &Source{
+	Name:      "tcgplayer",
+	CardURL:   "http://store.tcgplayer.com/magic/{set}/{card}",
+	SealedURL: "http://store.tcgplayer.com/magic/{set}/{set}-{product}",
+	Price:     regexp.MustCompile(`<td class=\"avg\">(?P<price>.*?)</td>`),
+	// Only whole cells may come between a listing's condition and its
+	// price, so a listing with no price can't take the next one's.
+	Conditions: regexp.MustCompile(
+		`<td class=\"condition\">(?P<condition>[^<]*)</td>(?:\s*<td[^>]*>[^<]*</td>)*?\s*<td class=\"price\">(?P<price>[^<]*)</td>`),
+}
+
+// Default is the source used by the package level functions.
+var Default = TCGplayer
+
+// NewSource builds a source from the patterns in a config file, checking that
+// they have the groups the source needs.
+func NewSource(name, cardURL, sealedURL, price, conditions string) (*Source, error) {
+	s := &Source{Name: name, CardURL: cardURL, SealedURL: sealedURL}
+	var err error
+	if s.Price, err = compileGroups(price, "price"); err != nil {
+		return nil, fmt.Errorf("price selector: %s", err)
+	}
+	if conditions != "" {
+		s.Conditions, err = compileGroups(conditions, "condition", "price")
+		if err != nil {
+			return nil, fmt.Errorf("condition selector: %s", err)
+		}
+	}
+	return s, nil
+}
+
+func compileGroups(pattern string, groups ...string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		if groupIndex(re, g) < 0 {
+			return nil, fmt.Errorf("%q has no (?P<%s>...) group", pattern, g)
+		}
+	}
+	return re, nil
+}
+
+func groupIndex(re *regexp.Regexp, name string) int {
+	for i, n := range re.SubexpNames() {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Source) fetcher() *fetch.Fetcher {
+	if s.Fetcher != nil {
+		return s.Fetcher
+	}
+	return fetch.Default
+}
+
+func (s *Source) CardUrl(set, name string) string {
+	r := strings.NewReplacer("{set}", SetSlug(set), "{card}", name)
+	return r.Replace(s.CardURL)
+}
+
+func (s *Source) SealedUrl(set string, product SealedProduct) string {
+	r := strings.NewReplacer("{set}", SetSlug(set), "{product}", product.Slug)
+	return r.Replace(s.SealedURL)
+}
+
+func (s *Source) fetchUrl(url string) (string, error) {
+	body, err := s.fetcher().Get(url)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func (s *Source) findPrice(page string) (string, error) {
+	r := myRegexp{s.Price}
+	elem, ok := r.FindStringSubmatchMap(page)["price"]
+	if !ok {
+		return "", ErrNoPrice
+	}
+	return elem, nil
+}
+
+// FetchCardPrice fetches the average price of a card in the given set. It
+// returns ErrNoPrice if the page has no price on it.
+func (s *Source) FetchCardPrice(set, name string) (string, error) {
+	page, err := s.fetchUrl(s.CardUrl(set, name))
+	if err != nil {
+		return "", err
+	}
+	return s.findPrice(page)
+}
+
+// LookupCard fetches and parses the price of a card in the given set.
+func (s *Source) LookupCard(set, name string) (Card, error) {
+	price, err := s.FetchCardPrice(set, name)
+	if err != nil {
+		return Card{}, err
+	}
+	cost, err := strconv.ParseFloat(stripPrice(price), 64)
+	if err != nil {
+		return Card{}, err
+	}
+	return Card{Name: name, Set: set, Price: cost}, nil
+}
+
+// FetchQuote fetches the average price of a card along with the cheapest
+// listing in each condition.
+func (s *Source) FetchQuote(set, name string) (Quote, error) {
+	page, err := s.fetchUrl(s.CardUrl(set, name))
+	if err != nil {
+		return Quote{}, err
+	}
+	return s.parseQuote(page)
+}
+
+func (s *Source) parseQuote(page string) (Quote, error) {
+	price, err := s.findPrice(page)
+	if err != nil {
+		return Quote{}, err
+	}
+	q := Quote{Conditions: make(map[string]float64)}
+	if q.Price, err = strconv.ParseFloat(stripPrice(price), 64); err != nil {
+		return Quote{}, err
+	}
+	if s.Conditions == nil {
+		return q, nil
+	}
+	ci, pi := groupIndex(s.Conditions, "condition"), groupIndex(s.Conditions, "price")
+	for _, m := range s.Conditions.FindAllStringSubmatch(page, -1) {
+		condition := strings.TrimSpace(m[ci])
+		cost, err := strconv.ParseFloat(stripPrice(m[pi]), 64)
+		if err != nil {
+			continue
+		}
+		if old, ok := q.Conditions[condition]; !ok || cost < old {
+			q.Conditions[condition] = cost
+		}
+	}
+	return q, nil
+}
+
+// FetchSealedPrice fetches the average price of a sealed product of the set.
+func (s *Source) FetchSealedPrice(set string, product SealedProduct) (float64, error) {
+	page, err := s.fetchUrl(s.SealedUrl(set, product))
+	if err != nil {
+		return 0, err
+	}
+	price, err := s.findPrice(page)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(stripPrice(price), 64)
+}
@@ -0,0 +1,176 @@
+/*
+Package pricing adjusts a card's base price for its condition and language.
+
+When the price source lists copies by condition the cheapest listing in the
+card's condition is used, otherwise the base price is scaled by a configurable
+multiplier. Language multipliers are applied on top of either.
+*/
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"wdix/getev/pricefetch"
+)
+
+// The card conditions, best first.
+const (
+	NearMint         = "NM"
+	LightlyPlayed    = "LP"
+	ModeratelyPlayed = "MP"
+	HeavilyPlayed    = "HP"
+	Damaged          = "DMG"
+)
+
+// The language assumed when none is given.
+const English = "EN"
+
+var conditionNames = map[string]string{
+	"NM":                NearMint,
+	"M":                 NearMint,
+	"MINT":              NearMint,
+	"NEAR MINT":         NearMint,
+	"LP":                LightlyPlayed,
+	"SP":                LightlyPlayed,
+	"LIGHTLY PLAYED":    LightlyPlayed,
+	"SLIGHTLY PLAYED":   LightlyPlayed,
+	"MP":                ModeratelyPlayed,
+	"PLAYED":            ModeratelyPlayed,
+	"MODERATELY PLAYED": ModeratelyPlayed,
+	"HP":                HeavilyPlayed,
+	"HEAVILY PLAYED":    HeavilyPlayed,
+	"DMG":               Damaged,
+	"D":                 Damaged,
+	"DAMAGED":           Damaged,
+}
+
+// NormalizeCondition maps the many ways of writing a condition onto one of
+// the condition constants. An empty condition is NearMint.
+func NormalizeCondition(s string) (string, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return NearMint, nil
+	}
+	if c, ok := conditionNames[s]; ok {
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown condition %q", s)
+}
+
+// Rules are the multipliers applied to a base price.
+type Rules struct {
+	Conditions map[string]float64 `json:"conditions"`
+	Languages  map[string]float64 `json:"languages"`
+	// Prefer the source's listings for a condition over the multiplier.
+	VendorConditions bool `json:"vendorConditions"`
+}
+
+// DefaultRules returns the rules used when no rules file is given.
+func DefaultRules() *Rules {
+	return &Rules{
+		Conditions: map[string]float64{
+			NearMint:         1.0,
+			LightlyPlayed:    0.85,
+			ModeratelyPlayed: 0.7,
+			HeavilyPlayed:    0.5,
+			Damaged:          0.3,
+		},
+		Languages: map[string]float64{
+			English: 1.0,
+			"DE":    0.9,
+			"FR":    0.9,
+			"IT":    0.85,
+			"ES":    0.85,
+			"PT":    0.8,
+			"JA":    1.0,
+			"KO":    0.8,
+			"RU":    0.9,
+			"ZHS":   0.8,
+			"ZHT":   0.8,
+		},
+		VendorConditions: true,
+	}
+}
+
+// LoadRules reads rules from a JSON file. Anything the file leaves out keeps
+// its default.
+func LoadRules(path string) (*Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file Rules
+	file.VendorConditions = true
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	r := DefaultRules()
+	r.VendorConditions = file.VendorConditions
+	for c, m := range file.Conditions {
+		cond, err := NormalizeCondition(c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		r.Conditions[cond] = m
+	}
+	for l, m := range file.Languages {
+		r.Languages[strings.ToUpper(l)] = m
+	}
+	for k, m := range r.Conditions {
+		if m < 0 {
+			return nil, fmt.Errorf("%s: negative multiplier for %s", path, k)
+		}
+	}
+	for k, m := range r.Languages {
+		if m < 0 {
+			return nil, fmt.Errorf("%s: negative multiplier for %s", path, k)
+		}
+	}
+	return r, nil
+}
+
+// Price returns the price of a copy of the quoted card in the given
+// condition and language.
+func (r *Rules) Price(q pricefetch.Quote, condition, language string) (float64, error) {
+	cond, err := NormalizeCondition(condition)
+	if err != nil {
+		return 0, err
+	}
+	price, ok := 0.0, false
+	if r.VendorConditions {
+		price, ok = vendorPrice(q, cond)
+	}
+	if !ok {
+		m, known := r.Conditions[cond]
+		if !known {
+			return 0, fmt.Errorf("no multiplier for condition %s", cond)
+		}
+		price = q.Price * m
+	}
+	lang := strings.ToUpper(strings.TrimSpace(language))
+	if lang == "" {
+		lang = English
+	}
+	m, known := r.Languages[lang]
+	if !known {
+		return 0, fmt.Errorf("no multiplier for language %s", lang)
+	}
+	return price * m, nil
+}
+
+// vendorPrice returns the cheapest listing in the condition.
+func vendorPrice(q pricefetch.Quote, cond string) (float64, bool) {
+	best, found := 0.0, false
+	for name, price := range q.Conditions {
+		c, err := NormalizeCondition(name)
+		if err != nil || c != cond {
+			continue
+		}
+		if !found || price < best {
+			best, found = price, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,98 @@
+package pricing
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"wdix/getev/pricefetch"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestNormalizeCondition(t *testing.T) {
+	tests := map[string]string{
+		"":               NearMint,
+		"nm":             NearMint,
+		"Near Mint":      NearMint,
+		"Lightly Played": LightlyPlayed,
+		" mp ":           ModeratelyPlayed,
+		"HP":             HeavilyPlayed,
+		"damaged":        Damaged,
+	}
+	for in, want := range tests {
+		got, err := NormalizeCondition(in)
+		if err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := NormalizeCondition("pristine"); err == nil {
+		t.Error("expected an error for an unknown condition")
+	}
+}
+
+func TestPrice(t *testing.T) {
+	r := DefaultRules()
+	q := pricefetch.Quote{
+		Price: 10,
+		Conditions: map[string]float64{
+			"Near Mint":       11,
+			"Lightly Played":  9,
+			"Slightly Played": 8.5,
+		},
+	}
+	tests := []struct {
+		condition, language string
+		want                float64
+	}{
+		{"NM", "", 11},
+		{"LP", "EN", 8.5},
+		{"MP", "", 7},
+		{"HP", "de", 4.5},
+		{"DMG", "", 3},
+	}
+	for _, test := range tests {
+		got, err := r.Price(q, test.condition, test.language)
+		if err != nil || !near(got, test.want) {
+			t.Errorf("%s %s: got %v, %v, want %v",
+				test.condition, test.language, got, err, test.want)
+		}
+	}
+
+	r.VendorConditions = false
+	if got, _ := r.Price(q, "LP", ""); !near(got, 8.5) {
+		t.Errorf("multiplier only: got %v, want 8.5", got)
+	}
+	if _, err := r.Price(q, "NM", "Klingon"); err == nil {
+		t.Error("expected an error for an unknown language")
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	data := `{"conditions": {"Lightly Played": 0.9}, "languages": {"ja": 1.2},
+		"vendorConditions": false}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Conditions[LightlyPlayed] != 0.9 || r.Conditions[HeavilyPlayed] != 0.5 {
+		t.Errorf("conditions: %v", r.Conditions)
+	}
+	if r.Languages["JA"] != 1.2 || r.VendorConditions {
+		t.Errorf("got %+v", r)
+	}
+
+	data = `{"conditions": {"pristine": 2}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRules(path); err == nil {
+		t.Error("expected an error for an unknown condition")
+	}
+}
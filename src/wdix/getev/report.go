@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"wdix/getev/config"
+	"wdix/getev/ev"
+	"wdix/getev/pricefetch"
+)
+
+// report is the result of pricing a set.
+type report struct {
+	Set         string            `json:"set"`
+	Code        string            `json:"code"`
+	Source      string            `json:"source"`
+	Cards       []pricefetch.Card `json:"cards"`
+	PackEV      float64           `json:"packEV"`
+	SimulatedEV float64           `json:"simulatedEV,omitempty"`
+	Sealed      []ev.Comparison   `json:"sealed,omitempty"`
+}
+
+type byPrice []pricefetch.Card
+
+func (s byPrice) Len() int      { return len(s) }
+func (s byPrice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byPrice) Less(i, j int) bool {
+	if s[i].Price != s[j].Price {
+		return s[i].Price > s[j].Price
+	}
+	return s[i].Name < s[j].Name
+}
+
+// trimmed returns a copy of the report listing only its top cards by price,
+// all of them if top is 0. The report itself keeps every card, for alerts.
+func (r *report) trimmed(top int) *report {
+	t := *r
+	t.Cards = append([]pricefetch.Card(nil), r.Cards...)
+	sort.Sort(byPrice(t.Cards))
+	if top > 0 && top < len(t.Cards) {
+		t.Cards = t.Cards[:top]
+	}
+	return &t
+}
+
+func (r *report) write(w io.Writer, out config.Output) error {
+	r = r.trimmed(out.Top)
+	if out.Format == config.JSON {
+		data, err := json.MarshalIndent(r, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	fmt.Fprintf(w, "%s, priced from %s\n", r.Set, r.Source)
+	for _, c := range r.Cards {
+		fmt.Fprintf(w, "  $%8.2f  %s\n", c.Price, c.Name)
+	}
+	fmt.Fprintf(w, "Pack EV: $%.2f\n", r.PackEV)
+	if r.SimulatedEV > 0 {
+		fmt.Fprintf(w, "Simulated: $%.2f\n", r.SimulatedEV)
+	}
+	// Compare the market price of each sealed product against the EV of
+	// opening it.
+	for _, c := range r.Sealed {
+		_, err := fmt.Fprintf(w, "%-12s market $%8.2f  opened $%8.2f  %s\n",
+			c.Product, c.Price, c.Opened, c.Verdict)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+	"wdix/getev/checkpoint"
+	"wdix/getev/config"
+	"wdix/getev/pricefetch"
+)
+
+// priceCards prices the checklist's cards, recording each in the checkpoint
+// file as it arrives. With -resume, cards recorded for the same set and
+// source within the checkpoint's max age are taken from the file instead.
+func priceCards(cfg *config.Config, src *pricefetch.Source, set string, entries []checklistEntry) []pricefetch.Card {
+	var done map[string]pricefetch.Card
+	if *resume && cfg.Checkpoint.Path != "" {
+		since := time.Now().Add(-time.Duration(cfg.Checkpoint.MaxAge))
+		var err error
+		done, err = checkpoint.Load(cfg.Checkpoint.Path, set, src.Name, since)
+		if err != nil {
+			slog.Warn("reading checkpoint", "path", cfg.Checkpoint.Path, "err", err)
+		}
+	}
+
+	var cards []pricefetch.Card
+	var todo []checklistEntry
+	for _, entry := range entries {
+		if card, ok := done[entry.Name]; ok {
+			cards = append(cards, card)
+			continue
+		}
+		todo = append(todo, entry)
+	}
+	if len(done) > 0 {
+		slog.Info("resuming", "checkpoint", cfg.Checkpoint.Path, "priced", len(cards), "left", len(todo))
+	}
+
+	var cp *checkpoint.Writer
+	if cfg.Checkpoint.Path != "" {
+		var err error
+		if cp, err = checkpoint.Create(cfg.Checkpoint.Path, src.Name); err != nil {
+			slog.Warn("opening checkpoint", "path", cfg.Checkpoint.Path, "err", err)
+		}
+		defer cp.Close()
+		stop := notifyInterrupt(cfg.Checkpoint.Path)
+		defer stop()
+	}
+	return append(cards, lookupCards(src, set, todo, cfg.Concurrency, cp)...)
+}
+
+// notifyInterrupt makes Ctrl-C say how to pick the run up again before
+// exiting. The returned func stops it.
+func notifyInterrupt(path string) (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		if _, ok := <-c; ok {
+			slog.Warn("interrupted, run again with -resume to carry on", "checkpoint", path)
+			os.Exit(130)
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(c)
+	}
+}
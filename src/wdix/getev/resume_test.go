@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+	"wdix/getev/checkpoint"
+	"wdix/getev/config"
+	"wdix/getev/fetch/fetchtest"
+	"wdix/getev/pricefetch"
+)
+
+func TestPriceCardsResume(t *testing.T) {
+	srv := fetchtest.NewServer("pricefetch/testdata/tcgplayer")
+	defer srv.Close()
+	src := *pricefetch.TCGplayer
+	src.CardURL = srv.URL + "/magic/{set}/{card}"
+	src.Fetcher = fetchtest.NewFetcher(srv)
+
+	cfg := config.Default()
+	cfg.Checkpoint.Path = filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	cp, err := checkpoint.Create(cfg.Checkpoint.Path, src.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Record(pricefetch.Card{Name: "Angel of Serenity", Set: "RTR", Rarity: "M", Price: 99})
+	cp.Close()
+
+	*resume = true
+	defer func() { *resume = false }()
+	entries := []checklistEntry{
+		{Name: "Angel of Serenity", Rarity: "M"},
+		{Name: "Dramatic Rescue", Rarity: "C"},
+	}
+	cards := priceCards(cfg, &src, "RTR", entries)
+	got := make(map[string]float64)
+	for _, c := range cards {
+		got[c.Name] = c.Price
+	}
+	if got["Angel of Serenity"] != 99 || got["Dramatic Rescue"] != 0.15 || len(got) != 2 {
+		t.Errorf("got %v, want the checkpointed angel and a fetched rescue", got)
+	}
+
+	// The fetched card was recorded for next time.
+	done, err := checkpoint.Load(cfg.Checkpoint.Path, "RTR", src.Name, time.Time{})
+	if err != nil || len(done) != 2 {
+		t.Errorf("checkpoint holds %v, %v", done, err)
+	}
+}
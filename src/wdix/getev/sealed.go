@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log/slog"
+	"wdix/getev/ev"
+	"wdix/getev/pricefetch"
+)
+
+func fetchSealedPrices(src *pricefetch.Source, set string, products []pricefetch.SealedProduct) []ev.Sealed {
+	var sealed []ev.Sealed
+	for _, p := range products {
+		price, err := src.FetchSealedPrice(set, p)
+		if err != nil {
+			slog.Warn("no sealed price", "product", p.Name, "set", set, "err", err)
+			continue
+		}
+		sealed = append(sealed, ev.Sealed{Product: p.Name, Packs: p.Packs, Price: price})
+	}
+	return sealed
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"wdix/getev/config"
+	"wdix/getev/fetch"
+	"wdix/getev/metrics"
+	"wdix/getev/pricefetch"
+)
+
+var (
+	configPath     = flag.String("config", "", "config file, the built in defaults are used if empty")
+	setFlag        = flag.String("set", "", "codes of the sets to price, as RTR,GTC,DGM, overrides the config")
+	sourceFlag     = flag.String("source", "", "name of the price source, overrides the config")
+	concurrency    = flag.Int("concurrency", 0, "cards fetched at once, overrides the config")
+	timeout        = flag.Duration("timeout", 0, "HTTP timeout, overrides the config")
+	retries        = flag.Int("retries", 0, "HTTP retries, overrides the config")
+	userAgent      = flag.String("user-agent", "", "HTTP User-Agent, overrides the config")
+	robots         = flag.Bool("robots", true, "follow robots.txt, overrides the config")
+	proxy          = flag.String("proxy", "", "proxy urls to rotate through, comma separated, overrides the config")
+	minInterval    = flag.Duration("min-interval", 0, "least time between requests to a host, overrides the config")
+	format         = flag.String("format", "", "output format, text or json, overrides the config")
+	enrich         = flag.Bool("enrich", false, "add Gatherer details to each card, overrides the config")
+	top            = flag.Int("top", 0, "number of cards to list, overrides the config")
+	simulate       = flag.Int("simulate", 0, "number of packs to open at random, overrides the config")
+	logLevel       = flag.String("log-level", "", "debug, info, warn or error, overrides the config")
+	logFormat      = flag.String("log-format", "", "log format, text or json, overrides the config")
+	checkpointPath = flag.String("checkpoint", "", "file to record priced cards in, overrides the config")
+	resume         = flag.Bool("resume", false, "skip cards already in the checkpoint file")
+	metricsAddr    = flag.String("metrics-addr", "", "address to serve /metrics on, overrides the config")
+)
+
+// loadConfig loads the config file, if any, and applies the command line
+// flags that were given on top of it.
+func loadConfig() (*config.Config, error) {
+	cfg := config.Default()
+	if *configPath != "" {
+		var err error
+		if cfg, err = config.Load(*configPath); err != nil {
+			return nil, err
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "set":
+			cfg.Set = *setFlag
+		case "source":
+			cfg.Source = *sourceFlag
+		case "concurrency":
+			cfg.Concurrency = *concurrency
+		case "timeout":
+			cfg.HTTP.Timeout = config.Duration(*timeout)
+		case "retries":
+			cfg.HTTP.Retries = *retries
+		case "user-agent":
+			cfg.HTTP.UserAgent = *userAgent
+		case "robots":
+			cfg.HTTP.Robots = *robots
+		case "proxy":
+			cfg.HTTP.Proxies = strings.Split(*proxy, ",")
+		case "min-interval":
+			cfg.HTTP.MinInterval = config.Duration(*minInterval)
+		case "format":
+			cfg.Output.Format = *format
+		case "enrich":
+			cfg.Enrich = *enrich
+		case "top":
+			cfg.Output.Top = *top
+		case "simulate":
+			cfg.EV.Simulate = *simulate
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		case "log-format":
+			cfg.Log.Format = *logFormat
+		case "checkpoint":
+			cfg.Checkpoint.Path = *checkpointPath
+		case "metrics-addr":
+			cfg.MetricsAddr = *metricsAddr
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyConfig sets up logging, the shared fetcher, price source and set slugs
+// from the config.
+func applyConfig(cfg *config.Config) error {
+	setupLogging(cfg.Log)
+	if cfg.MetricsAddr != "" {
+		serveMetrics(cfg.MetricsAddr)
+	}
+
+	var cache fetch.Cache = fetch.NewMemoryCache()
+	if cfg.HTTP.CacheDir != "" {
+		cache = &fetch.DirCache{
+			Dir:    cfg.HTTP.CacheDir,
+			MaxAge: time.Duration(cfg.HTTP.CacheMaxAge),
+		}
+	}
+	transport, err := fetch.NewTransport(cfg.HTTP.Proxies, cfg.HTTP.CABundle)
+	if err != nil {
+		return err
+	}
+	fetch.Default = &fetch.Fetcher{
+		Client: &http.Client{
+			Timeout:   time.Duration(cfg.HTTP.Timeout),
+			Transport: transport,
+		},
+		UserAgent: cfg.HTTP.UserAgent,
+		Retries:   cfg.HTTP.Retries,
+		Backoff:   time.Duration(cfg.HTTP.Backoff),
+		Cache:     cache,
+		Metrics:   metrics.Default,
+		Policy:    fetch.NewPolicy(time.Duration(cfg.HTTP.MinInterval), cfg.HTTP.Robots),
+		Decode:    cfg.HTTP.Decode,
+	}
+	for _, s := range cfg.Sets {
+		if s.Slug != "" {
+			pricefetch.AddSet(s.Code, s.Slug)
+		}
+	}
+	srcConfig, err := cfg.FindSource(cfg.Source)
+	if err != nil {
+		return err
+	}
+	src, err := srcConfig.Build(fetch.Default)
+	if err != nil {
+		return err
+	}
+	pricefetch.Default = src
+	return nil
+}
+
+func checklistUrl(set *config.Set) string {
+	if set.Checklist != "" {
+		return set.Checklist
+	}
+	return "http://gatherer.wizards.com/Pages/Search/Default.aspx?output=checklist&action=advanced&set=" +
+		url.QueryEscape(`["`+set.Name+`"]`)
+}
+
+// setupLogging sends leveled logs to stderr.
+func setupLogging(l config.Log) {
+	var level slog.Level
+	level.UnmarshalText([]byte(l.Level))
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if l.Format == config.JSON {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// serveMetrics serves metrics.Default at /metrics on addr for as long as
+// getev runs.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("serving metrics", "addr", addr, "err", err)
+		}
+	}()
+}